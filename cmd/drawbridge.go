@@ -30,9 +30,10 @@ func init() {
 	}
 
 	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "config file")
-	rootCmd.PersistentFlags().String("eth-rpc-url", "", "URL to a running Ethereum RPC node")
+	rootCmd.PersistentFlags().String("eth-rpc-url", "", "URL to a running Ethereum RPC node; websocket and IPC endpoints let blocks be indexed as they arrive")
 	rootCmd.PersistentFlags().String("contract-address", "", "address of the payment channel smart contract")
 	rootCmd.PersistentFlags().String("chain-id", "", "target chain ID")
+	rootCmd.PersistentFlags().Uint64("eth-confirmations", 0, "blocks an Ethereum event must be buried under before it is indexed")
 	rootCmd.PersistentFlags().String("private-key", "", "your wallet's private key")
 	rootCmd.PersistentFlags().String("identity-private-key", "", "your node's identity private key")
 	rootCmd.PersistentFlags().String("rpc-ip", "127.0.0.1", "IP address to listen for RPC requests on")
@@ -45,9 +46,17 @@ func init() {
 	rootCmd.PersistentFlags().String("lnd-macaroon-file", "", "location of lnd's macaroon file")
 	rootCmd.PersistentFlags().String("lnd-host", "", "lnd's hostname")
 	rootCmd.PersistentFlags().String("lnd-port", "", "lnd's port")
+	rootCmd.PersistentFlags().String("swap-rate", "0", "satoshis paid per 10^18 base units of the ETH-side token in swaps")
+	rootCmd.PersistentFlags().Uint64("swap-fee-bps", 0, "fee in basis points charged on swaps")
+	rootCmd.PersistentFlags().Uint64("swap-tolerance-bps", 0, "basis points above our own quote a peer may request in a swap")
+	rootCmd.PersistentFlags().String("swap-rate-file", "", "JSON file to read swap rates from, overriding the other swap-rate flags")
+	rootCmd.PersistentFlags().Int("peer-queue-size", 32, "messages buffered in each direction per peer")
+	rootCmd.PersistentFlags().Float64("peer-msg-rate", 20, "messages per second a peer may send of any type without its own limit")
+	rootCmd.PersistentFlags().Int("peer-msg-burst", 50, "messages a peer may send at once of any type without its own limit")
 	viper.BindPFlag("eth-rpc-url", rootCmd.PersistentFlags().Lookup("eth-rpc-url"))
 	viper.BindPFlag("contract-address", rootCmd.PersistentFlags().Lookup("contract-address"))
 	viper.BindPFlag("chain-id", rootCmd.PersistentFlags().Lookup("chain-id"))
+	viper.BindPFlag("eth-confirmations", rootCmd.PersistentFlags().Lookup("eth-confirmations"))
 	viper.BindPFlag("private-key", rootCmd.PersistentFlags().Lookup("private-key"))
 	viper.BindPFlag("identity-private-key", rootCmd.PersistentFlags().Lookup("identity-private-key"))
 	viper.BindPFlag("rpc-ip", rootCmd.PersistentFlags().Lookup("rpc-ip"))
@@ -60,6 +69,13 @@ func init() {
 	viper.BindPFlag("lnd-macaroon-file", rootCmd.PersistentFlags().Lookup("lnd-macaroon-file"))
 	viper.BindPFlag("lnd-host", rootCmd.PersistentFlags().Lookup("lnd-host"))
 	viper.BindPFlag("lnd-port", rootCmd.PersistentFlags().Lookup("lnd-port"))
+	viper.BindPFlag("swap-rate", rootCmd.PersistentFlags().Lookup("swap-rate"))
+	viper.BindPFlag("swap-fee-bps", rootCmd.PersistentFlags().Lookup("swap-fee-bps"))
+	viper.BindPFlag("swap-tolerance-bps", rootCmd.PersistentFlags().Lookup("swap-tolerance-bps"))
+	viper.BindPFlag("swap-rate-file", rootCmd.PersistentFlags().Lookup("swap-rate-file"))
+	viper.BindPFlag("peer-queue-size", rootCmd.PersistentFlags().Lookup("peer-queue-size"))
+	viper.BindPFlag("peer-msg-rate", rootCmd.PersistentFlags().Lookup("peer-msg-rate"))
+	viper.BindPFlag("peer-msg-burst", rootCmd.PersistentFlags().Lookup("peer-msg-burst"))
 	viper.SetDefault("rpc-ip", "127.0.0.1")
 	viper.SetDefault("rpc-port", "8080")
 	viper.SetDefault("p2p-ip", "0.0.0.0")
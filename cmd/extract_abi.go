@@ -9,12 +9,13 @@ import (
 	"fmt"
 	rawlog "log"
 	"errors"
+	"strings"
 )
 
 func main() {
 	rootCmd := &cobra.Command{
 		Use: "extract-abi",
-		Short: "extracts the ABI from Truffle contracts",
+		Short: "extracts the ABI and bytecode from Truffle contracts",
 		Run: func(c *cobra.Command, args []string) {
 			if err := action(c); err != nil {
 				fmt.Println(err)
@@ -24,7 +25,7 @@ func main() {
 	}
 
 	rootCmd.PersistentFlags().StringSliceP("contracts", "c", nil, "list of contracts to extract from")
-	rootCmd.PersistentFlags().StringP("output-dir", "o", "", "directory to output ABI files")
+	rootCmd.PersistentFlags().StringP("output-dir", "o", "", "directory to output ABI and bytecode files")
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -54,6 +55,7 @@ func action(c *cobra.Command) error {
 	}
 
 	abis := make(map[string][]byte)
+	bins := make(map[string][]byte)
 
 	for _, contract := range contracts {
 		contents, err := ioutil.ReadFile(contract)
@@ -74,7 +76,13 @@ func action(c *cobra.Command) error {
 			return err
 		}
 
-		abis[path.Base(contract)] = abi
+		name := path.Base(contract)
+		abis[name] = abi
+
+		// abigen only generates a deploy method when given the bytecode
+		if bytecode, ok := data["bytecode"].(string); ok {
+			bins[strings.TrimSuffix(name, ".json")+".bin"] = []byte(bytecode)
+		}
 	}
 
 	for k, v := range abis {
@@ -88,5 +96,16 @@ func action(c *cobra.Command) error {
 		rawlog.Printf("Outputted ABI file %s", k)
 	}
 
+	for k, v := range bins {
+		p := path.Join(outputDir, k)
+		err := ioutil.WriteFile(p, v, 0744)
+
+		if err != nil {
+			return err
+		}
+
+		rawlog.Printf("Outputted bytecode file %s", k)
+	}
+
 	return nil
 }
\ No newline at end of file
@@ -8,6 +8,8 @@ import (
 	"github.com/kyokan/drawbridge/internal/logger"
 	"github.com/kyokan/drawbridge/pkg/crypto"
 	"github.com/kyokan/drawbridge/internal/protocol"
+	"github.com/kyokan/drawbridge/internal/conv"
+	"context"
 )
 
 var fsLog *zap.SugaredLogger
@@ -132,7 +134,79 @@ func (f *FundingService) OpenChannel(r *http.Request, args *OpenChannelArgs, rep
 		return err
 	}
 
-	err = f.chanHandler.InitChannel(pub, amountBig)
+	ctx, cancel := context.WithTimeout(r.Context(), sendTimeout)
+	defer cancel()
+	err = f.chanHandler.InitChannel(ctx, pub, amountBig)
+
+	if err != nil {
+		return err
+	}
+
+	reply.Status = StatusOk
+
+	return nil
+}
+
+type CloseChannelArgs struct {
+	PeerPubkey string
+	ChannelID  string
+}
+
+type CloseChannelReply struct {
+	Status string
+}
+
+func (f *FundingService) CloseChannel(r *http.Request, args *CloseChannelArgs, reply *CloseChannelReply) error {
+	fsLog.Infow("received close channel request",
+		"peerId", args.PeerPubkey,
+		"chanId", args.ChannelID,
+	)
+
+	pub, err := crypto.PublicFromCompressedHex(args.PeerPubkey)
+
+	if err != nil {
+		return err
+	}
+
+	chanId, err := conv.HexToBytes32(args.ChannelID)
+
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), sendTimeout)
+	defer cancel()
+	err = f.chanHandler.CloseChannel(ctx, pub, chanId)
+
+	if err != nil {
+		return err
+	}
+
+	reply.Status = StatusOk
+
+	return nil
+}
+
+type ForceCloseChannelArgs struct {
+	ChannelID string
+}
+
+type ForceCloseChannelReply struct {
+	Status string
+}
+
+func (f *FundingService) ForceCloseChannel(r *http.Request, args *ForceCloseChannelArgs, reply *ForceCloseChannelReply) error {
+	fsLog.Infow("received force close channel request",
+		"chanId", args.ChannelID,
+	)
+
+	chanId, err := conv.HexToBytes32(args.ChannelID)
+
+	if err != nil {
+		return err
+	}
+
+	err = f.chanHandler.ForceCloseChannel(chanId)
 
 	if err != nil {
 		return err
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"github.com/kyokan/drawbridge/internal/p2p"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+)
+
+type PeerService struct {
+	node *p2p.Node
+}
+
+func NewPeerService(node *p2p.Node) *PeerService {
+	return &PeerService{
+		node: node,
+	}
+}
+
+type AddPeerArgs struct {
+	Pubkey    string
+	Addresses []string
+}
+
+type AddPeerReply struct {
+	Status string
+}
+
+func (p *PeerService) AddPeer(r *http.Request, args *AddPeerArgs, reply *AddPeerReply) error {
+	pub, err := crypto.PublicFromCompressedHex(args.Pubkey)
+
+	if err != nil {
+		return err
+	}
+
+	err = p.node.AddPersistentPeer(pub, args.Addresses)
+	if err != nil {
+		return err
+	}
+
+	reply.Status = StatusOk
+	return nil
+}
+
+type RemovePeerArgs struct {
+	Pubkey string
+}
+
+type RemovePeerReply struct {
+	Status string
+}
+
+func (p *PeerService) RemovePeer(r *http.Request, args *RemovePeerArgs, reply *RemovePeerReply) error {
+	pub, err := crypto.PublicFromCompressedHex(args.Pubkey)
+
+	if err != nil {
+		return err
+	}
+
+	err = p.node.RemovePersistentPeer(pub)
+	if err != nil {
+		return err
+	}
+
+	reply.Status = StatusOk
+	return nil
+}
@@ -6,10 +6,14 @@ import (
 	"net/http"
 	"go.uber.org/zap"
 	"github.com/kyokan/drawbridge/internal/logger"
+	"time"
 )
 
 const StatusOk = "OK"
 
+// sendTimeout bounds how long an RPC waits for room in a peer's send queue.
+const sendTimeout = time.Second * 10
+
 var sLog *zap.SugaredLogger
 
 func init() {
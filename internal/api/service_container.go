@@ -4,10 +4,14 @@ import "github.com/gorilla/rpc"
 
 type ServiceContainer struct {
 	FundingService *FundingService
-	SwapService *SwapService
+	SwapService    *SwapService
+	PeerService    *PeerService
+	StatusService  *StatusService
 }
 
 func (s *ServiceContainer) RegisterServices(server *rpc.Server) {
 	server.RegisterService(s.FundingService, "")
 	server.RegisterService(s.SwapService, "")
+	server.RegisterService(s.PeerService, "")
+	server.RegisterService(s.StatusService, "")
 }
\ No newline at end of file
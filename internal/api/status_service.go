@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+	"github.com/kyokan/drawbridge/internal/ethclient"
+)
+
+type StatusService struct {
+	chainsaw *ethclient.Chainsaw
+}
+
+func NewStatusService(chainsaw *ethclient.Chainsaw) *StatusService {
+	return &StatusService{
+		chainsaw: chainsaw,
+	}
+}
+
+type GetStatusArgs struct {
+}
+
+type GetStatusReply struct {
+	Status string
+	// IndexingLag is how many confirmed blocks the chainsaw had yet to index
+	// as of its last poll.
+	IndexingLag uint64
+}
+
+func (s *StatusService) GetStatus(r *http.Request, args *GetStatusArgs, reply *GetStatusReply) error {
+	reply.Status = StatusOk
+	reply.IndexingLag = s.chainsaw.Lag()
+	return nil
+}
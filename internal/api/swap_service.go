@@ -6,7 +6,10 @@ import (
 	"github.com/kyokan/drawbridge/internal/logger"
 	"github.com/kyokan/drawbridge/pkg/crypto"
 	"github.com/kyokan/drawbridge/internal/protocol"
-	"math/big"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/kyokan/drawbridge/internal/db"
+	"errors"
+	"context"
 )
 
 var csLog *zap.SugaredLogger
@@ -26,7 +29,11 @@ func NewSwapService(swapHandler *protocol.SwapHandler) (*SwapService) {
 }
 
 type DoSwapArgs struct {
-	PeerPubkey string
+	PeerPubkey  string
+	ETHAmount   string
+	BTCAmount   string
+	MaxSlippage uint64
+	Direction   string
 }
 
 type DoSwapReply struct {
@@ -34,7 +41,21 @@ type DoSwapReply struct {
 }
 
 func (f *SwapService) DoSwap(r *http.Request, args *DoSwapArgs, reply *DoSwapReply) error {
-	csLog.Infow("performing swap")
+	csLog.Infow("performing swap",
+		"peerId", args.PeerPubkey,
+		"ethAmount", args.ETHAmount,
+		"btcAmount", args.BTCAmount,
+		"maxSlippage", args.MaxSlippage,
+		"direction", args.Direction,
+	)
+
+	direction := db.SwapDirection(args.Direction)
+	if direction == "" {
+		direction = db.SwapETHToBTC
+	}
+	if direction != db.SwapETHToBTC && direction != db.SwapBTCToETH {
+		return errors.New("direction must be eth_to_btc or btc_to_eth")
+	}
 
 	pub, err := crypto.PublicFromCompressedHex(args.PeerPubkey)
 
@@ -42,7 +63,21 @@ func (f *SwapService) DoSwap(r *http.Request, args *DoSwapArgs, reply *DoSwapRep
 		return err
 	}
 
-	err = f.swapHandler.InitSwap(pub, big.NewInt(1000), big.NewInt(1000))
+	ethAmount, err := hexutil.DecodeBig(args.ETHAmount)
+
+	if err != nil {
+		return err
+	}
+
+	btcAmount, err := hexutil.DecodeBig(args.BTCAmount)
+
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), sendTimeout)
+	defer cancel()
+	err = f.swapHandler.InitSwap(ctx, pub, direction, ethAmount, btcAmount, args.MaxSlippage)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"database/sql"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"time"
+)
+
+type Bans interface {
+	Save(ban *Ban) error
+	FindActive(identityKey *crypto.PublicKey) (*Ban, error)
+}
+
+type PostgresBans struct {
+	db *sql.DB
+}
+
+// Save bans a peer, replacing any earlier ban on it.
+func (p *PostgresBans) Save(ban *Ban) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO bans (
+				identity_key,
+				reason,
+				expires_at,
+				created_at
+			) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (identity_key) DO UPDATE SET reason = EXCLUDED.reason, expires_at = EXCLUDED.expires_at
+		`,
+			ban.IdentityKey.CompressedHex(),
+			ban.Reason,
+			ban.ExpiresAt,
+			time.Now().Unix(),
+		)
+		return err
+	})
+}
+
+// FindActive returns the peer's ban, or nil if it is not banned or the ban
+// has expired.
+func (p *PostgresBans) FindActive(identityKey *crypto.PublicKey) (*Ban, error) {
+	row := p.db.QueryRow(`
+		SELECT reason, expires_at FROM bans WHERE identity_key = $1 AND expires_at > $2
+	`, identityKey.CompressedHex(), time.Now().Unix())
+
+	var reason string
+	var expiresAt int64
+	err := row.Scan(&reason, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ban{
+		IdentityKey: identityKey,
+		Reason:      reason,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"database/sql"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type ChannelUpdates interface {
+	Save(updates *ChannelUpdates) error
+	FindByChannel(chanId common.Hash) (*ChannelUpdates, error)
+}
+
+type PostgresChannelUpdates struct {
+	db *sql.DB
+}
+
+// Save replaces the channel's pending updates. Saving an empty set clears
+// them.
+func (p *PostgresChannelUpdates) Save(updates *ChannelUpdates) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		chanId := updates.ChannelID.Hex()
+		if _, err := tx.Exec("DELETE FROM eth_htlc_updates WHERE channel_id = $1", chanId); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM eth_channel_updates WHERE channel_id = $1", chanId); err != nil {
+			return err
+		}
+
+		if len(updates.Updates) == 0 && len(updates.Deferred) == 0 && !updates.SignedNext {
+			return nil
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO eth_channel_updates (channel_id, commitment_number, signed_next) VALUES ($1, $2, $3)
+		`, chanId, updates.CommitmentNumber, updates.SignedNext)
+		if err != nil {
+			return err
+		}
+
+		stmt, err := tx.Prepare(`
+			INSERT INTO eth_htlc_updates (
+				channel_id,
+				position,
+				htlc_id,
+				offered,
+				amount,
+				payment_hash,
+				delay,
+				preimage,
+				fulfilled,
+				failed,
+				deferred
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		`)
+		if err != nil {
+			return err
+		}
+
+		position := 0
+		insert := func(htlc *ETHHTLC, deferred bool) error {
+			_, err := stmt.Exec(
+				chanId,
+				position,
+				htlc.ID,
+				htlc.Offered,
+				htlc.Amount.Text(10),
+				htlc.PaymentHash.Hex(),
+				htlc.Delay,
+				htlc.Preimage.Hex(),
+				htlc.Fulfilled,
+				htlc.Failed,
+				deferred,
+			)
+			position++
+			return err
+		}
+
+		for _, htlc := range updates.Updates {
+			if err := insert(htlc, false); err != nil {
+				return err
+			}
+		}
+		for _, htlc := range updates.Deferred {
+			if err := insert(htlc, true); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// FindByChannel returns the channel's pending updates, which are empty if
+// nothing has been proposed since its latest commitment.
+func (p *PostgresChannelUpdates) FindByChannel(chanId common.Hash) (*ChannelUpdates, error) {
+	out := &ChannelUpdates{
+		ChannelID: chanId,
+	}
+
+	row := p.db.QueryRow(`
+		SELECT commitment_number, signed_next FROM eth_channel_updates WHERE channel_id = $1
+	`, chanId.Hex())
+	err := row.Scan(&out.CommitmentNumber, &out.SignedNext)
+	if err == sql.ErrNoRows {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := p.db.Query(`
+		SELECT channel_id, htlc_id, offered, amount, payment_hash, delay, preimage, fulfilled, failed, deferred
+			FROM eth_htlc_updates WHERE channel_id = $1 ORDER BY position
+	`, chanId.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		raw := &rawHTLC{}
+		var deferred bool
+		err := rows.Scan(&raw.ChannelID, &raw.ID, &raw.Offered, &raw.Amount, &raw.PaymentHash, &raw.Delay,
+			&raw.Preimage, &raw.Fulfilled, &raw.Failed, &deferred)
+		if err != nil {
+			return nil, err
+		}
+
+		htlc, err := raw.toHTLC()
+		if err != nil {
+			return nil, err
+		}
+
+		if deferred {
+			out.Deferred = append(out.Deferred, htlc)
+		} else {
+			out.Updates = append(out.Updates, htlc)
+		}
+	}
+
+	return out, rows.Err()
+}
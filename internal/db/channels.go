@@ -5,56 +5,258 @@ import (
 	"database/sql"
 	"math/big"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/kyokan/drawbridge/internal/conv"
+	"github.com/kyokan/drawbridge/pkg/crypto"
 )
 
 type Channels interface {
 	Save(channel *ETHChannel) error
+	UpdateState(channel *ETHChannel, htlcs []*ETHHTLC) error
+	MarkClosed(chanId common.Hash) error
 	FindById(chanId common.Hash) (*ETHChannel, error)
+	FindByFundingOutput(outputId common.Hash) (*ETHChannel, error)
 	FindByAmount(amount *big.Int) (*ETHChannel, error)
+	FindByCounterparty(counterparty common.Address) ([]*ETHChannel, error)
+	FindHTLCs(chanId common.Hash) ([]*ETHHTLC, error)
+	FindFulfilledHTLC(paymentHash common.Hash) (*ETHHTLC, error)
 }
 
 type PostgresChannels struct {
 	db *sql.DB
 }
 
+const channelColumns = `e.id, e.funding_output, e.counterparty, e.commitment_number, e.local_balance, e.remote_balance,
+	e.revocation_root, e.their_commit_sig, e.their_revocation_point, e.their_next_revocation_point,
+	e.their_revocation_store, e.their_revocation_basepoint, e.closed`
+
 func (p *PostgresChannels) Save(channel *ETHChannel) error {
 	return NewTransactor(p.db, func(tx *sql.Tx) error {
-		_, err := tx.Exec(
-			"INSERT INTO eth_channels (id, funding_output, counterparty) VALUES ($1, $2, $3)",
+		_, err := tx.Exec(`
+			INSERT INTO eth_channels (
+				id,
+				funding_output,
+				counterparty,
+				commitment_number,
+				local_balance,
+				remote_balance,
+				revocation_root,
+				their_commit_sig,
+				their_revocation_point,
+				their_next_revocation_point,
+				their_revocation_store,
+				their_revocation_basepoint
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		`,
 			channel.ID.Hex(),
 			channel.FundingOutput.Hex(),
 			channel.Counterparty.Hex(),
+			channel.CommitmentNumber,
+			channel.LocalBalance.Text(10),
+			channel.RemoteBalance.Text(10),
+			channel.RevocationRoot.Hex(),
+			hexutil.Encode(channel.TheirCommitSig),
+			encodeOptionalKey(channel.TheirRevocationPoint),
+			encodeOptionalKey(channel.TheirNextRevocationPoint),
+			hexutil.Encode(channel.TheirRevocationStore),
+			encodeOptionalKey(channel.TheirRevocationBasepoint),
 		)
 		return err
 	})
 }
 
+// UpdateState writes a channel's commitment state together with the HTLCs
+// that make it up, so the two can never disagree.
+func (p *PostgresChannels) UpdateState(channel *ETHChannel, htlcs []*ETHHTLC) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE eth_channels SET (
+				commitment_number,
+				local_balance,
+				remote_balance,
+				their_commit_sig,
+				their_revocation_point,
+				their_next_revocation_point,
+				their_revocation_store
+			) = ($1, $2, $3, $4, $5, $6, $7) WHERE id = $8
+		`,
+			channel.CommitmentNumber,
+			channel.LocalBalance.Text(10),
+			channel.RemoteBalance.Text(10),
+			hexutil.Encode(channel.TheirCommitSig),
+			encodeOptionalKey(channel.TheirRevocationPoint),
+			encodeOptionalKey(channel.TheirNextRevocationPoint),
+			hexutil.Encode(channel.TheirRevocationStore),
+			channel.ID.Hex(),
+		)
+		if err != nil {
+			return err
+		}
+
+		if len(htlcs) == 0 {
+			return nil
+		}
+
+		stmt, err := tx.Prepare(`
+			INSERT INTO eth_htlcs (
+				channel_id,
+				id,
+				offered,
+				amount,
+				payment_hash,
+				delay,
+				preimage,
+				fulfilled,
+				failed
+			) VALUES (
+				$1, $2, $3, $4, $5, $6, $7, $8, $9
+			) ON CONFLICT (channel_id, offered, id) DO UPDATE SET (
+				preimage,
+				fulfilled,
+				failed
+			) = (
+				EXCLUDED.preimage,
+				EXCLUDED.fulfilled,
+				EXCLUDED.failed
+			)
+		`)
+		if err != nil {
+			return err
+		}
+
+		for _, htlc := range htlcs {
+			_, err := stmt.Exec(
+				htlc.ChannelID.Hex(),
+				htlc.ID,
+				htlc.Offered,
+				htlc.Amount.Text(10),
+				htlc.PaymentHash.Hex(),
+				htlc.Delay,
+				htlc.Preimage.Hex(),
+				htlc.Fulfilled,
+				htlc.Failed,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (p *PostgresChannels) MarkClosed(chanId common.Hash) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE eth_channels SET closed = $1 WHERE id = $2", true, chanId.Hex())
+		return err
+	})
+}
+
 func (p *PostgresChannels) FindById(chanId common.Hash) (*ETHChannel, error) {
 	row := p.db.QueryRow(`
-		SELECT e.id, e.funding_output, e.counterparty FROM eth_channels e
-		WHERE e.id = $1 
+		SELECT `+channelColumns+` FROM eth_channels e
+		WHERE e.id = $1
 	`, chanId.Hex())
 	return deserChannelRow(row)
 }
 
+func (p *PostgresChannels) FindByFundingOutput(outputId common.Hash) (*ETHChannel, error) {
+	row := p.db.QueryRow(`
+		SELECT `+channelColumns+` FROM eth_channels e
+		WHERE e.funding_output = $1
+	`, outputId.Hex())
+	return deserChannelRow(row)
+}
+
 func (p *PostgresChannels) FindByAmount(amount *big.Int) (*ETHChannel, error) {
 	row := p.db.QueryRow(`
-		SELECT e.id, e.funding_output, e.counterparty FROM eth_channels e
+		SELECT `+channelColumns+` FROM eth_channels e
 		JOIN eth_outputs o ON e.funding_output = o.id
-		WHERE o.amount = $1 
+		WHERE o.amount = $1 AND e.closed = FALSE
 	`, amount.Text(10))
 	return deserChannelRow(row)
 }
 
+func (p *PostgresChannels) FindByCounterparty(counterparty common.Address) ([]*ETHChannel, error) {
+	rows, err := p.db.Query(`
+		SELECT `+channelColumns+` FROM eth_channels e
+		WHERE e.counterparty = $1
+	`, counterparty.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*ETHChannel
+	for rows.Next() {
+		channel, err := deserChannelRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, channel)
+	}
+
+	return out, rows.Err()
+}
+
+func (p *PostgresChannels) FindHTLCs(chanId common.Hash) ([]*ETHHTLC, error) {
+	rows, err := p.db.Query(`
+		SELECT channel_id, id, offered, amount, payment_hash, delay, preimage, fulfilled, failed
+			FROM eth_htlcs WHERE channel_id = $1 ORDER BY offered, id
+	`, chanId.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*ETHHTLC
+	for rows.Next() {
+		htlc, err := deserHTLCRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, htlc)
+	}
+
+	return out, rows.Err()
+}
+
+// FindFulfilledHTLC returns a fulfilled HTLC with the given payment hash on
+// any channel, which carries its preimage, or nil if there is none.
+func (p *PostgresChannels) FindFulfilledHTLC(paymentHash common.Hash) (*ETHHTLC, error) {
+	row := p.db.QueryRow(`
+		SELECT channel_id, id, offered, amount, payment_hash, delay, preimage, fulfilled, failed
+			FROM eth_htlcs WHERE payment_hash = $1 AND fulfilled = TRUE LIMIT 1
+	`, paymentHash.Hex())
+
+	htlc, err := deserHTLCRow(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return htlc, err
+}
+
 type rawChannel struct {
-	ID            string
-	FundingOutput string
-	Counterparty  string
+	ID                       string
+	FundingOutput            string
+	Counterparty             string
+	CommitmentNumber         uint64
+	LocalBalance             string
+	RemoteBalance            string
+	RevocationRoot           string
+	TheirCommitSig           string
+	TheirRevocationPoint     string
+	TheirNextRevocationPoint string
+	TheirRevocationStore     string
+	TheirRevocationBasepoint string
+	IsClosed                 bool
 }
 
-func deserChannelRow(row *sql.Row) (*ETHChannel, error) {
+func deserChannelRow(row scanner) (*ETHChannel, error) {
 	raw := &rawChannel{}
-	err := row.Scan(&raw.ID, &raw.FundingOutput, &raw.Counterparty)
+	err := row.Scan(&raw.ID, &raw.FundingOutput, &raw.Counterparty, &raw.CommitmentNumber, &raw.LocalBalance,
+		&raw.RemoteBalance, &raw.RevocationRoot, &raw.TheirCommitSig, &raw.TheirRevocationPoint,
+		&raw.TheirNextRevocationPoint, &raw.TheirRevocationStore, &raw.TheirRevocationBasepoint, &raw.IsClosed)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -78,9 +280,134 @@ func deserChannelRow(row *sql.Row) (*ETHChannel, error) {
 
 	counterparty := common.HexToAddress(raw.Counterparty)
 
+	localBalance, err := conv.StringToBig(raw.LocalBalance)
+	if err != nil {
+		return nil, err
+	}
+	remoteBalance, err := conv.StringToBig(raw.RemoteBalance)
+	if err != nil {
+		return nil, err
+	}
+
+	var revocationRoot common.Hash
+	if raw.RevocationRoot != "" {
+		revocationRoot, err = conv.HexToBytes32(raw.RevocationRoot)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	theirCommitSig, err := hexutil.Decode(raw.TheirCommitSig)
+	if err != nil {
+		return nil, err
+	}
+
+	theirPoint, err := decodeOptionalKey(raw.TheirRevocationPoint)
+	if err != nil {
+		return nil, err
+	}
+	theirNextPoint, err := decodeOptionalKey(raw.TheirNextRevocationPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	theirStore, err := hexutil.Decode(raw.TheirRevocationStore)
+	if err != nil {
+		return nil, err
+	}
+
+	theirBasepoint, err := decodeOptionalKey(raw.TheirRevocationBasepoint)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ETHChannel{
-		ID:            id,
-		FundingOutput: fundingOutput,
-		Counterparty:  counterparty,
+		ID:                       id,
+		FundingOutput:            fundingOutput,
+		Counterparty:             counterparty,
+		CommitmentNumber:         raw.CommitmentNumber,
+		LocalBalance:             localBalance,
+		RemoteBalance:            remoteBalance,
+		RevocationRoot:           revocationRoot,
+		TheirCommitSig:           theirCommitSig,
+		TheirRevocationPoint:     theirPoint,
+		TheirNextRevocationPoint: theirNextPoint,
+		TheirRevocationStore:     theirStore,
+		TheirRevocationBasepoint: theirBasepoint,
+		IsClosed:                 raw.IsClosed,
+	}, nil
+}
+
+type rawHTLC struct {
+	ChannelID   string
+	ID          uint64
+	Offered     bool
+	Amount      string
+	PaymentHash string
+	Delay       uint64
+	Preimage    string
+	Fulfilled   bool
+	Failed      bool
+}
+
+func deserHTLCRow(row scanner) (*ETHHTLC, error) {
+	raw := &rawHTLC{}
+	err := row.Scan(&raw.ChannelID, &raw.ID, &raw.Offered, &raw.Amount, &raw.PaymentHash, &raw.Delay,
+		&raw.Preimage, &raw.Fulfilled, &raw.Failed)
+	if err != nil {
+		return nil, err
+	}
+
+	return raw.toHTLC()
+}
+
+func (raw *rawHTLC) toHTLC() (*ETHHTLC, error) {
+	chanId, err := conv.HexToBytes32(raw.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := conv.StringToBig(raw.Amount)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := conv.HexToBytes32(raw.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var preimage common.Hash
+	if raw.Preimage != "" {
+		preimage, err = conv.HexToBytes32(raw.Preimage)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ETHHTLC{
+		ChannelID:   chanId,
+		ID:          raw.ID,
+		Offered:     raw.Offered,
+		Amount:      amount,
+		PaymentHash: paymentHash,
+		Delay:       raw.Delay,
+		Preimage:    preimage,
+		Fulfilled:   raw.Fulfilled,
+		Failed:      raw.Failed,
 	}, nil
 }
+
+func encodeOptionalKey(key *crypto.PublicKey) string {
+	if key == nil {
+		return ""
+	}
+
+	return key.CompressedHex()
+}
+
+func decodeOptionalKey(hex string) (*crypto.PublicKey, error) {
+	if hex == "" {
+		return nil, nil
+	}
+
+	return crypto.PublicFromCompressedHex(hex)
+}
@@ -8,10 +8,19 @@ import (
 )
 
 type DB struct {
-	Outputs  Outputs
-	Channels Channels
-	dbUrl    string
-	db       *sql.DB
+	Outputs         Outputs
+	Channels        Channels
+	ChannelUpdates  ChannelUpdates
+	PendingChannels PendingChannels
+	Swaps           Swaps
+	HTLCRefunds     HTLCRefunds
+	DelayedSweeps   DelayedSweeps
+	Punishments     Punishments
+	Peers           Peers
+	Bans            Bans
+	Transactions    Transactions
+	dbUrl           string
+	db              *sql.DB
 }
 
 func NewDB(dbUrl string) (*DB, error) {
@@ -38,6 +47,33 @@ func NewDB(dbUrl string) (*DB, error) {
 		Channels: &PostgresChannels{
 			db: db,
 		},
+		ChannelUpdates: &PostgresChannelUpdates{
+			db: db,
+		},
+		PendingChannels: &PostgresPendingChannels{
+			db: db,
+		},
+		Swaps: &PostgresSwaps{
+			db: db,
+		},
+		HTLCRefunds: &PostgresHTLCRefunds{
+			db: db,
+		},
+		DelayedSweeps: &PostgresDelayedSweeps{
+			db: db,
+		},
+		Punishments: &PostgresPunishments{
+			db: db,
+		},
+		Peers: &PostgresPeers{
+			db: db,
+		},
+		Bans: &PostgresBans{
+			db: db,
+		},
+		Transactions: &PostgresTransactions{
+			db: db,
+		},
 		dbUrl: dbUrl,
 		db:    db,
 	}, nil
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"database/sql"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/internal/conv"
+	"time"
+)
+
+type DelayedSweeps interface {
+	Save(sweep *DelayedSweep) error
+	Delete(outputId common.Hash) error
+	FindUnswept() ([]*DelayedSweep, error)
+}
+
+type PostgresDelayedSweeps struct {
+	db *sql.DB
+}
+
+func (p *PostgresDelayedSweeps) Save(sweep *DelayedSweep) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO delayed_sweeps (
+				output_id,
+				channel_id,
+				tx_hash,
+				swept,
+				created_at
+			) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (output_id) DO UPDATE SET tx_hash = EXCLUDED.tx_hash, swept = EXCLUDED.swept
+		`,
+			sweep.OutputID.Hex(),
+			sweep.ChannelID.Hex(),
+			sweep.TxHash.Hex(),
+			sweep.Swept,
+			time.Now().Unix(),
+		)
+		return err
+	})
+}
+
+func (p *PostgresDelayedSweeps) Delete(outputId common.Hash) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM delayed_sweeps WHERE output_id = $1", outputId.Hex())
+		return err
+	})
+}
+
+func (p *PostgresDelayedSweeps) FindUnswept() ([]*DelayedSweep, error) {
+	rows, err := p.db.Query(`
+		SELECT output_id, channel_id, tx_hash, swept FROM delayed_sweeps WHERE swept = FALSE
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*DelayedSweep
+	for rows.Next() {
+		var rawOutputId string
+		var rawChanId string
+		var rawTxHash string
+		var swept bool
+		if err := rows.Scan(&rawOutputId, &rawChanId, &rawTxHash, &swept); err != nil {
+			return nil, err
+		}
+
+		outputId, err := conv.HexToBytes32(rawOutputId)
+		if err != nil {
+			return nil, err
+		}
+		chanId, err := conv.HexToBytes32(rawChanId)
+		if err != nil {
+			return nil, err
+		}
+		txHash, err := conv.HexToBytes32(rawTxHash)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, &DelayedSweep{
+			OutputID:  outputId,
+			ChannelID: chanId,
+			TxHash:    txHash,
+			Swept:     swept,
+		})
+	}
+
+	return out, rows.Err()
+}
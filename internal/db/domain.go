@@ -3,6 +3,7 @@ package db
 import (
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
+	"github.com/kyokan/drawbridge/pkg/crypto"
 )
 
 type ETHOutput struct {
@@ -17,8 +18,170 @@ type ETHOutput struct {
 	IsWithdrawn     bool
 }
 
+// IndexedBlock is a block the chainsaw has processed. Its hash is kept so
+// that reorganizations can be detected.
+type IndexedBlock struct {
+	Number     uint64
+	Hash       common.Hash
+	ParentHash common.Hash
+}
+
 type ETHChannel struct {
-	ID common.Hash
-	FundingOutput common.Hash
-	Counterparty common.Address
-}
\ No newline at end of file
+	ID                       common.Hash
+	FundingOutput            common.Hash
+	Counterparty             common.Address
+	CommitmentNumber         uint64
+	LocalBalance             *big.Int
+	RemoteBalance            *big.Int
+	RevocationRoot           common.Hash
+	TheirCommitSig           crypto.Signature
+	TheirRevocationPoint     *crypto.PublicKey
+	TheirNextRevocationPoint *crypto.PublicKey
+	TheirRevocationStore     []byte
+	TheirRevocationBasepoint *crypto.PublicKey
+	IsClosed                 bool
+}
+
+type ETHHTLC struct {
+	ChannelID   common.Hash
+	ID          uint64
+	Offered     bool
+	Amount      *big.Int
+	PaymentHash common.Hash
+	Delay       uint64
+	Preimage    common.Hash
+	Fulfilled   bool
+	Failed      bool
+}
+
+// ChannelUpdates are the HTLC updates proposed on a channel since its latest
+// commitment, numbered CommitmentNumber. Fulfills and fails are told apart
+// from adds by their Fulfilled and Failed flags.
+// Deferred holds our own updates waiting for the counterparty's to be
+// committed first.
+type ChannelUpdates struct {
+	ChannelID        common.Hash
+	CommitmentNumber uint64
+	Updates          []*ETHHTLC
+	Deferred         []*ETHHTLC
+	SignedNext       bool
+}
+
+type SwapState string
+
+const (
+	SwapInitiated        SwapState = "initiate_swap"
+	SwapAccepted         SwapState = "swap_accepted"
+	SwapInvoiceGenerated SwapState = "invoice_generated"
+	SwapInvoiceExecuted  SwapState = "invoice_executed"
+	SwapHTLCRedeemed     SwapState = "htlc_redeemed"
+	SwapFailed           SwapState = "failed"
+)
+
+// SwapDirection is named for what the initiator sends and what it receives.
+type SwapDirection string
+
+const (
+	SwapETHToBTC SwapDirection = "eth_to_btc"
+	SwapBTCToETH SwapDirection = "btc_to_eth"
+)
+
+type Swap struct {
+	ID             common.Hash
+	PaymentHash    common.Hash
+	Preimage       common.Hash
+	ETHChannelID   common.Hash
+	BTCChannelID   uint64
+	ETHAmount      *big.Int
+	BTCAmount      *big.Int
+	PaymentRequest string
+	Counterparty   *crypto.PublicKey
+	IsInitiator    bool
+	Direction      SwapDirection
+	State          SwapState
+}
+
+type PendingChannel struct {
+	PendingChannelID common.Hash
+	ChannelID        common.Hash
+	InputID          common.Hash
+	FundingAmount    *big.Int
+	OurFundingKey    *crypto.PublicKey
+	TheirFundingKey  *crypto.PublicKey
+	OurSignature     crypto.Signature
+	TheirSignature   crypto.Signature
+	FundingOutput    common.Hash
+	SentLocked       bool
+	ReceivedLocked   bool
+	RevocationRoot   common.Hash
+	TheirFirstPoint  *crypto.PublicKey
+	TheirCommitSig   crypto.Signature
+	// FundingTx is the hash the funder first broadcast the funding under.
+	FundingTx common.Hash
+}
+
+type HTLCRefund struct {
+	OutputID common.Hash
+	TxHash   common.Hash
+	Amount   *big.Int
+}
+
+// DelayedSweep is our balance on a commitment we broadcast, waiting out its
+// delay before we can claim it. TxHash is set once it is swept.
+type DelayedSweep struct {
+	OutputID  common.Hash
+	ChannelID common.Hash
+	TxHash    common.Hash
+	Swept     bool
+}
+
+// Punishment is a revoked commitment output we are sweeping through its
+// revocation path. It is kept until the output is spent, so that a sweep
+// that fails is retried.
+type Punishment struct {
+	OutputID  common.Hash
+	ChannelID common.Hash
+	TxHash    common.Hash
+	Punished  bool
+}
+
+// Peer is a node we keep a connection to across restarts. LNDIdentity is nil
+// until the peer has completed a handshake with us.
+type Peer struct {
+	IdentityKey *crypto.PublicKey
+	Addresses   []string
+	LNDIdentity *crypto.PublicKey
+	LastSeen    int64
+}
+
+type Ban struct {
+	IdentityKey *crypto.PublicKey
+	Reason      string
+	ExpiresAt   int64
+}
+
+type ETHTransactionStatus string
+
+const (
+	ETHTransactionPending  ETHTransactionStatus = "pending"
+	ETHTransactionMined    ETHTransactionStatus = "mined"
+	ETHTransactionReverted ETHTransactionStatus = "reverted"
+	ETHTransactionDropped  ETHTransactionStatus = "dropped"
+)
+
+// ETHTransaction is a transaction we sent. A fee bump re-signs it under the
+// same nonce, so Hashes holds every version that was broadcast and Hash the
+// latest one, or the one that was mined.
+type ETHTransaction struct {
+	From        common.Address
+	Nonce       uint64
+	To          common.Address
+	Value       *big.Int
+	Data        []byte
+	GasLimit    uint64
+	GasPrice    *big.Int
+	Hash        common.Hash
+	Hashes      []common.Hash
+	Status      ETHTransactionStatus
+	BroadcastAt int64
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"database/sql"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/internal/conv"
+	"time"
+)
+
+type HTLCRefunds interface {
+	Save(refund *HTLCRefund) error
+	FindByOutputId(outputId common.Hash) (*HTLCRefund, error)
+}
+
+type PostgresHTLCRefunds struct {
+	db *sql.DB
+}
+
+func (p *PostgresHTLCRefunds) Save(refund *HTLCRefund) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO htlc_refunds (
+				output_id,
+				tx_hash,
+				amount,
+				created_at
+			) VALUES ($1, $2, $3, $4)
+		`,
+			refund.OutputID.Hex(),
+			refund.TxHash.Hex(),
+			refund.Amount.Text(10),
+			time.Now().Unix(),
+		)
+		return err
+	})
+}
+
+func (p *PostgresHTLCRefunds) FindByOutputId(outputId common.Hash) (*HTLCRefund, error) {
+	row := p.db.QueryRow(`
+		SELECT output_id, tx_hash, amount FROM htlc_refunds WHERE output_id = $1
+	`, outputId.Hex())
+
+	var rawOutputId string
+	var rawTxHash string
+	var rawAmount string
+	err := row.Scan(&rawOutputId, &rawTxHash, &rawAmount)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := conv.HexToBytes32(rawOutputId)
+	if err != nil {
+		return nil, err
+	}
+	txHash, err := conv.HexToBytes32(rawTxHash)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := conv.StringToBig(rawAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HTLCRefund{
+		OutputID: id,
+		TxHash:   txHash,
+		Amount:   amount,
+	}, nil
+}
@@ -12,23 +12,37 @@ import (
 )
 
 type Outputs interface {
-	SavePoll(outputs *PolledOutputs, blockNum uint64) error
+	SavePoll(outputs *PolledOutputs, block *IndexedBlock) error
 	LastPoll() (uint64, error)
+	FindBlock(number uint64) (*IndexedBlock, error)
+	Rollback(toBlock uint64) error
 	FindById(common.Hash) (*ETHOutput, error)
 	FindSpendableByOwnerAmount(script *txout.Payment, amount *big.Int) (*ETHOutput, error)
+	FindUnspentByType(outputType txout.OutputType) ([]*ETHOutput, error)
 }
 
+// MaxReorgDepth is how many indexed block hashes are kept. Reorganizations
+// deeper than this cannot be unwound precisely.
+const MaxReorgDepth = 256
+
 type PostgresOutputs struct {
 	db *sql.DB
 }
 
 type PolledOutputs struct {
 	New []*ETHOutput
-	Spent []uint64
-	Withdrawn []uint64
+	Spent []common.Hash
+	// SpentIn maps each spent output to the transaction that spent it.
+	SpentIn map[common.Hash]common.Hash
+	// Withdrawn outputs are also listed in Spent.
+	Withdrawn []common.Hash
 }
 
-func (p *PostgresOutputs) SavePoll(outputs *PolledOutputs, blockNum uint64) error {
+// SavePoll records the events up to and including block, which is the only
+// block whose hash is kept. Spends are recorded against block, so callers
+// saving a range should keep it out of reorg reach. Outputs orphaned by an
+// earlier rollback are revived if they are created again.
+func (p *PostgresOutputs) SavePoll(outputs *PolledOutputs, block *IndexedBlock) error {
 	return NewTransactor(p.db, func(tx *sql.Tx) error {
 		if len(outputs.New) > 0 {
 			stmt, err := tx.Prepare(`
@@ -45,6 +59,13 @@ func (p *PostgresOutputs) SavePoll(outputs *PolledOutputs, blockNum uint64) erro
 				) VALUES (
 					$1, $2, $3, $4, $5, $6, $7, $8, $9
 				)
+				ON CONFLICT (id) DO UPDATE SET
+					block_number = EXCLUDED.block_number,
+					tx_hash = EXCLUDED.tx_hash,
+					spent = FALSE,
+					withdrawn = FALSE,
+					spent_block = 0,
+					orphaned = FALSE
 			`)
 			if err != nil {
 				return err
@@ -55,7 +76,7 @@ func (p *PostgresOutputs) SavePoll(outputs *PolledOutputs, blockNum uint64) erro
 					out.ID.Hex(),
 					out.ContractAddress.Hex(),
 					out.Amount.Text(10),
-					blockNum,
+					out.BlockNumber,
 					out.TxHash.Hex(),
 					hexutil.Encode(out.Script),
 					out.Type,
@@ -70,13 +91,15 @@ func (p *PostgresOutputs) SavePoll(outputs *PolledOutputs, blockNum uint64) erro
 		}
 
 		if len(outputs.Spent) > 0 {
-			stmt, err := tx.Prepare("UPDATE eth_outputs SET spent = $1 WHERE id = $2")
+			stmt, err := tx.Prepare("UPDATE eth_outputs SET spent = $1, spent_block = $2 WHERE id = $3")
 			if err != nil {
 				return err
 			}
 
 			for _, id := range outputs.Spent {
-				stmt.Exec(true, id)
+				if _, err := stmt.Exec(true, block.Number, id.Hex()); err != nil {
+					return err
+				}
 			}
 		}
 
@@ -87,13 +110,34 @@ func (p *PostgresOutputs) SavePoll(outputs *PolledOutputs, blockNum uint64) erro
 			}
 
 			for _, id := range outputs.Withdrawn {
-				stmt.Exec(true, id)
+				if _, err := stmt.Exec(true, id.Hex()); err != nil {
+					return err
+				}
+			}
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO eth_indexed_blocks (block_number, block_hash, parent_hash) VALUES ($1, $2, $3)
+			ON CONFLICT (block_number) DO UPDATE SET block_hash = EXCLUDED.block_hash, parent_hash = EXCLUDED.parent_hash
+		`,
+			block.Number,
+			block.Hash.Hex(),
+			block.ParentHash.Hex(),
+		)
+		if err != nil {
+			return err
+		}
+
+		if block.Number > MaxReorgDepth {
+			_, err = tx.Exec("DELETE FROM eth_indexed_blocks WHERE block_number < $1", block.Number-MaxReorgDepth)
+			if err != nil {
+				return err
 			}
 		}
 
-		_, err := tx.Exec(
+		_, err = tx.Exec(
 			"UPDATE eth_chainsaw_status SET (last_seen_block, last_polled_at) = ($1, $2)",
-			blockNum,
+			block.Number,
 			time.Now().Unix(),
 		)
 		if err != nil {
@@ -104,6 +148,68 @@ func (p *PostgresOutputs) SavePoll(outputs *PolledOutputs, blockNum uint64) erro
 	})
 }
 
+func (p *PostgresOutputs) FindBlock(number uint64) (*IndexedBlock, error) {
+	row := p.db.QueryRow(`
+		SELECT block_hash, parent_hash FROM eth_indexed_blocks WHERE block_number = $1
+	`, number)
+
+	var rawHash string
+	var rawParentHash string
+	err := row.Scan(&rawHash, &rawParentHash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := conv.HexToBytes32(rawHash)
+	if err != nil {
+		return nil, err
+	}
+	parentHash, err := conv.HexToBytes32(rawParentHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IndexedBlock{
+		Number:     number,
+		Hash:       hash,
+		ParentHash: parentHash,
+	}, nil
+}
+
+// Rollback forgets everything indexed after toBlock. Outputs created after it
+// are marked orphaned rather than deleted, since channels and refunds may
+// reference them; they come back if the new chain creates them again.
+func (p *PostgresOutputs) Rollback(toBlock uint64) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE eth_outputs SET orphaned = TRUE WHERE block_number > $1", toBlock)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE eth_outputs SET spent = FALSE, withdrawn = FALSE, spent_block = 0 WHERE spent_block > $1
+		`, toBlock)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DELETE FROM eth_indexed_blocks WHERE block_number > $1", toBlock)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			"UPDATE eth_chainsaw_status SET (last_seen_block, last_polled_at) = ($1, $2)",
+			toBlock,
+			time.Now().Unix(),
+		)
+		return err
+	})
+}
+
 func (p *PostgresOutputs) LastPoll() (uint64, error) {
 	row := p.db.QueryRow("SELECT MAX(last_seen_block) FROM eth_chainsaw_status;")
 	var blockNum uint64
@@ -118,7 +224,7 @@ func (p *PostgresOutputs) LastPoll() (uint64, error) {
 func (p *PostgresOutputs) FindById(id common.Hash) (*ETHOutput, error) {
 	row := p.db.QueryRow(`
 		SELECT id, contract_address, amount, block_number, tx_hash, script, type, spent, withdrawn 
-			FROM eth_outputs WHERE id = $1
+			FROM eth_outputs WHERE id = $1 AND orphaned = FALSE
 	`, id.Hex())
 
 	out, err := deserOutputRow(row)
@@ -140,11 +246,34 @@ func (p *PostgresOutputs) FindSpendableByOwnerAmount(script *txout.Payment, amou
 	hexScript := hexutil.Encode(b.Bytes())
 	row := p.db.QueryRow(`
 		SELECT id, contract_address, amount, block_number, tx_hash, script, type, spent, withdrawn 
-			FROM eth_outputs WHERE type = 1 AND script = $1 AND amount = $2;
+			FROM eth_outputs WHERE type = 1 AND script = $1 AND amount = $2 AND spent = FALSE AND withdrawn = FALSE
+				AND orphaned = FALSE;
 	`, hexScript, amount.Text(10))
 	return deserOutputRow(row)
 }
 
+func (p *PostgresOutputs) FindUnspentByType(outputType txout.OutputType) ([]*ETHOutput, error) {
+	rows, err := p.db.Query(`
+		SELECT id, contract_address, amount, block_number, tx_hash, script, type, spent, withdrawn 
+			FROM eth_outputs WHERE type = $1 AND spent = FALSE AND withdrawn = FALSE AND orphaned = FALSE
+	`, uint8(outputType))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*ETHOutput
+	for rows.Next() {
+		output, err := deserOutputRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, output)
+	}
+
+	return out, rows.Err()
+}
+
 type rawOutput struct {
 	ID              string
 	ContractAddress string
@@ -157,10 +286,10 @@ type rawOutput struct {
 	IsSpent         bool
 }
 
-func deserOutputRow(row *sql.Row) (*ETHOutput, error) {
+func deserOutputRow(row scanner) (*ETHOutput, error) {
 	raw := &rawOutput{}
 	err := row.Scan(&raw.ID, &raw.ContractAddress, &raw.Amount, &raw.BlockNumber,
-		&raw.TxHash, &raw.Script, &raw.Type, &raw.IsWithdrawn, &raw.IsSpent)
+		&raw.TxHash, &raw.Script, &raw.Type, &raw.IsSpent, &raw.IsWithdrawn)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"database/sql"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"strings"
+	"time"
+)
+
+type Peers interface {
+	Save(peer *Peer) error
+	FindAll() ([]*Peer, error)
+	Delete(identityKey *crypto.PublicKey) error
+	MarkSeen(identityKey *crypto.PublicKey, lndIdentity *crypto.PublicKey) error
+}
+
+type PostgresPeers struct {
+	db *sql.DB
+}
+
+// Save adds a peer, or replaces the addresses of one we already know.
+func (p *PostgresPeers) Save(peer *Peer) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO peers (
+				identity_key,
+				addresses,
+				created_at
+			) VALUES ($1, $2, $3)
+			ON CONFLICT (identity_key) DO UPDATE SET addresses = EXCLUDED.addresses
+		`,
+			peer.IdentityKey.CompressedHex(),
+			strings.Join(peer.Addresses, ","),
+			time.Now().Unix(),
+		)
+		return err
+	})
+}
+
+func (p *PostgresPeers) FindAll() ([]*Peer, error) {
+	rows, err := p.db.Query(`
+		SELECT identity_key, addresses, lnd_identity, last_seen FROM peers
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Peer
+	for rows.Next() {
+		var rawIdentity string
+		var rawAddresses string
+		var rawLNDIdentity string
+		var lastSeen int64
+		if err := rows.Scan(&rawIdentity, &rawAddresses, &rawLNDIdentity, &lastSeen); err != nil {
+			return nil, err
+		}
+
+		identity, err := crypto.PublicFromCompressedHex(rawIdentity)
+		if err != nil {
+			return nil, err
+		}
+		var lndIdentity *crypto.PublicKey
+		if rawLNDIdentity != "" {
+			lndIdentity, err = crypto.PublicFromCompressedHex(rawLNDIdentity)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		out = append(out, &Peer{
+			IdentityKey: identity,
+			Addresses:   strings.Split(rawAddresses, ","),
+			LNDIdentity: lndIdentity,
+			LastSeen:    lastSeen,
+		})
+	}
+
+	return out, rows.Err()
+}
+
+func (p *PostgresPeers) Delete(identityKey *crypto.PublicKey) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM peers WHERE identity_key = $1`, identityKey.CompressedHex())
+		return err
+	})
+}
+
+// MarkSeen records a completed handshake. Peers we do not store are ignored.
+func (p *PostgresPeers) MarkSeen(identityKey *crypto.PublicKey, lndIdentity *crypto.PublicKey) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE peers SET lnd_identity = $1, last_seen = $2 WHERE identity_key = $3
+		`,
+			lndIdentity.CompressedHex(),
+			time.Now().Unix(),
+			identityKey.CompressedHex(),
+		)
+		return err
+	})
+}
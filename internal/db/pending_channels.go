@@ -0,0 +1,236 @@
+package db
+
+import (
+	"database/sql"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/kyokan/drawbridge/internal/conv"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"time"
+)
+
+type PendingChannels interface {
+	Save(channel *PendingChannel) error
+	FindAll() ([]*PendingChannel, error)
+	Delete(pendingChanId common.Hash) error
+}
+
+type PostgresPendingChannels struct {
+	db *sql.DB
+}
+
+func (p *PostgresPendingChannels) Save(channel *PendingChannel) error {
+	theirKey := ""
+	if channel.TheirFundingKey != nil {
+		theirKey = channel.TheirFundingKey.CompressedHex()
+	}
+
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO pending_channels (
+				pending_channel_id,
+				channel_id,
+				input_id,
+				funding_amount,
+				our_funding_key,
+				their_funding_key,
+				our_signature,
+				their_signature,
+				funding_output,
+				sent_locked,
+				received_locked,
+				revocation_root,
+				their_first_point,
+				their_commit_sig,
+				funding_tx,
+				updated_at
+			) VALUES (
+				$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16
+			) ON CONFLICT (pending_channel_id) DO UPDATE SET (
+				channel_id,
+				input_id,
+				their_funding_key,
+				our_signature,
+				their_signature,
+				funding_output,
+				sent_locked,
+				received_locked,
+				their_first_point,
+				their_commit_sig,
+				funding_tx,
+				updated_at
+			) = (
+				EXCLUDED.channel_id,
+				EXCLUDED.input_id,
+				EXCLUDED.their_funding_key,
+				EXCLUDED.our_signature,
+				EXCLUDED.their_signature,
+				EXCLUDED.funding_output,
+				EXCLUDED.sent_locked,
+				EXCLUDED.received_locked,
+				EXCLUDED.their_first_point,
+				EXCLUDED.their_commit_sig,
+				EXCLUDED.funding_tx,
+				EXCLUDED.updated_at
+			)
+		`,
+			channel.PendingChannelID.Hex(),
+			channel.ChannelID.Hex(),
+			channel.InputID.Hex(),
+			channel.FundingAmount.Text(10),
+			channel.OurFundingKey.CompressedHex(),
+			theirKey,
+			hexutil.Encode(channel.OurSignature),
+			hexutil.Encode(channel.TheirSignature),
+			channel.FundingOutput.Hex(),
+			channel.SentLocked,
+			channel.ReceivedLocked,
+			channel.RevocationRoot.Hex(),
+			encodeOptionalKey(channel.TheirFirstPoint),
+			hexutil.Encode(channel.TheirCommitSig),
+			channel.FundingTx.Hex(),
+			time.Now().Unix(),
+		)
+		return err
+	})
+}
+
+func (p *PostgresPendingChannels) FindAll() ([]*PendingChannel, error) {
+	rows, err := p.db.Query(`
+		SELECT pending_channel_id, channel_id, input_id, funding_amount, our_funding_key, their_funding_key,
+			our_signature, their_signature, funding_output, sent_locked, received_locked, revocation_root,
+			their_first_point, their_commit_sig, funding_tx
+			FROM pending_channels
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*PendingChannel
+	for rows.Next() {
+		channel, err := deserPendingChannelRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, channel)
+	}
+
+	return out, rows.Err()
+}
+
+func (p *PostgresPendingChannels) Delete(pendingChanId common.Hash) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM pending_channels WHERE pending_channel_id = $1", pendingChanId.Hex())
+		return err
+	})
+}
+
+type rawPendingChannel struct {
+	PendingChannelID string
+	ChannelID        string
+	InputID          string
+	FundingAmount    string
+	OurFundingKey    string
+	TheirFundingKey  string
+	OurSignature     string
+	TheirSignature   string
+	FundingOutput    string
+	SentLocked       bool
+	ReceivedLocked   bool
+	RevocationRoot   string
+	TheirFirstPoint  string
+	TheirCommitSig   string
+	FundingTx        string
+}
+
+func deserPendingChannelRow(row scanner) (*PendingChannel, error) {
+	raw := &rawPendingChannel{}
+	err := row.Scan(&raw.PendingChannelID, &raw.ChannelID, &raw.InputID, &raw.FundingAmount, &raw.OurFundingKey,
+		&raw.TheirFundingKey, &raw.OurSignature, &raw.TheirSignature, &raw.FundingOutput, &raw.SentLocked,
+		&raw.ReceivedLocked, &raw.RevocationRoot, &raw.TheirFirstPoint, &raw.TheirCommitSig,
+		&raw.FundingTx)
+	if err != nil {
+		return nil, err
+	}
+
+	pendingChanId, err := conv.HexToBytes32(raw.PendingChannelID)
+	if err != nil {
+		return nil, err
+	}
+	chanId, err := conv.HexToBytes32(raw.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	inputId, err := conv.HexToBytes32(raw.InputID)
+	if err != nil {
+		return nil, err
+	}
+	fundingAmount, err := conv.StringToBig(raw.FundingAmount)
+	if err != nil {
+		return nil, err
+	}
+	ourKey, err := crypto.PublicFromCompressedHex(raw.OurFundingKey)
+	if err != nil {
+		return nil, err
+	}
+	var theirKey *crypto.PublicKey
+	if raw.TheirFundingKey != "" {
+		theirKey, err = crypto.PublicFromCompressedHex(raw.TheirFundingKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ourSig, err := hexutil.Decode(raw.OurSignature)
+	if err != nil {
+		return nil, err
+	}
+	theirSig, err := hexutil.Decode(raw.TheirSignature)
+	if err != nil {
+		return nil, err
+	}
+	fundingOutput, err := conv.HexToBytes32(raw.FundingOutput)
+	if err != nil {
+		return nil, err
+	}
+	var revocationRoot common.Hash
+	if raw.RevocationRoot != "" {
+		revocationRoot, err = conv.HexToBytes32(raw.RevocationRoot)
+		if err != nil {
+			return nil, err
+		}
+	}
+	theirPoint, err := decodeOptionalKey(raw.TheirFirstPoint)
+	if err != nil {
+		return nil, err
+	}
+	theirCommitSig, err := hexutil.Decode(raw.TheirCommitSig)
+	if err != nil {
+		return nil, err
+	}
+	var fundingTx common.Hash
+	if raw.FundingTx != "" {
+		fundingTx, err = conv.HexToBytes32(raw.FundingTx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &PendingChannel{
+		PendingChannelID: pendingChanId,
+		ChannelID:        chanId,
+		InputID:          inputId,
+		FundingAmount:    fundingAmount,
+		OurFundingKey:    ourKey,
+		TheirFundingKey:  theirKey,
+		OurSignature:     ourSig,
+		TheirSignature:   theirSig,
+		FundingOutput:    fundingOutput,
+		SentLocked:       raw.SentLocked,
+		ReceivedLocked:   raw.ReceivedLocked,
+		RevocationRoot:   revocationRoot,
+		TheirFirstPoint:  theirPoint,
+		TheirCommitSig:   theirCommitSig,
+		FundingTx:        fundingTx,
+	}, nil
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"database/sql"
+	"github.com/kyokan/drawbridge/internal/conv"
+	"time"
+)
+
+type Punishments interface {
+	Save(punishment *Punishment) error
+	FindUnpunished() ([]*Punishment, error)
+}
+
+type PostgresPunishments struct {
+	db *sql.DB
+}
+
+func (p *PostgresPunishments) Save(punishment *Punishment) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO punishments (
+				output_id,
+				channel_id,
+				tx_hash,
+				punished,
+				created_at
+			) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (output_id) DO UPDATE SET tx_hash = EXCLUDED.tx_hash, punished = EXCLUDED.punished
+		`,
+			punishment.OutputID.Hex(),
+			punishment.ChannelID.Hex(),
+			punishment.TxHash.Hex(),
+			punishment.Punished,
+			time.Now().Unix(),
+		)
+		return err
+	})
+}
+
+func (p *PostgresPunishments) FindUnpunished() ([]*Punishment, error) {
+	rows, err := p.db.Query(`
+		SELECT output_id, channel_id, tx_hash, punished FROM punishments WHERE punished = FALSE
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Punishment
+	for rows.Next() {
+		var rawOutputId string
+		var rawChanId string
+		var rawTxHash string
+		var punished bool
+		if err := rows.Scan(&rawOutputId, &rawChanId, &rawTxHash, &punished); err != nil {
+			return nil, err
+		}
+
+		outputId, err := conv.HexToBytes32(rawOutputId)
+		if err != nil {
+			return nil, err
+		}
+		chanId, err := conv.HexToBytes32(rawChanId)
+		if err != nil {
+			return nil, err
+		}
+		txHash, err := conv.HexToBytes32(rawTxHash)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, &Punishment{
+			OutputID:  outputId,
+			ChannelID: chanId,
+			TxHash:    txHash,
+			Punished:  punished,
+		})
+	}
+
+	return out, rows.Err()
+}
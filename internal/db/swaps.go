@@ -0,0 +1,205 @@
+package db
+
+import (
+	"database/sql"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/internal/conv"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"strconv"
+	"time"
+)
+
+type Swaps interface {
+	Save(swap *Swap) error
+	FindById(swapId common.Hash) (*Swap, error)
+	FindByPaymentHash(paymentHash common.Hash) (*Swap, error)
+	FindPending() ([]*Swap, error)
+}
+
+type PostgresSwaps struct {
+	db *sql.DB
+}
+
+func (p *PostgresSwaps) Save(swap *Swap) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO swaps (
+				id,
+				payment_hash,
+				preimage,
+				eth_channel_id,
+				btc_channel_id,
+				eth_amount,
+				btc_amount,
+				payment_request,
+				counterparty,
+				is_initiator,
+				direction,
+				state,
+				updated_at
+			) VALUES (
+				$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13
+			) ON CONFLICT (id) DO UPDATE SET (
+				preimage,
+				btc_channel_id,
+				payment_request,
+				state,
+				updated_at
+			) = (
+				EXCLUDED.preimage,
+				EXCLUDED.btc_channel_id,
+				EXCLUDED.payment_request,
+				EXCLUDED.state,
+				EXCLUDED.updated_at
+			)
+		`,
+			swap.ID.Hex(),
+			swap.PaymentHash.Hex(),
+			swap.Preimage.Hex(),
+			swap.ETHChannelID.Hex(),
+			strconv.FormatUint(swap.BTCChannelID, 10),
+			swap.ETHAmount.Text(10),
+			swap.BTCAmount.Text(10),
+			swap.PaymentRequest,
+			swap.Counterparty.CompressedHex(),
+			swap.IsInitiator,
+			string(swap.Direction),
+			string(swap.State),
+			time.Now().Unix(),
+		)
+		return err
+	})
+}
+
+func (p *PostgresSwaps) FindById(swapId common.Hash) (*Swap, error) {
+	row := p.db.QueryRow(`
+		SELECT id, payment_hash, preimage, eth_channel_id, btc_channel_id, eth_amount, btc_amount,
+			payment_request, counterparty, is_initiator, direction, state
+			FROM swaps WHERE id = $1
+	`, swapId.Hex())
+
+	swap, err := deserSwapRow(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return swap, err
+}
+
+func (p *PostgresSwaps) FindByPaymentHash(paymentHash common.Hash) (*Swap, error) {
+	row := p.db.QueryRow(`
+		SELECT id, payment_hash, preimage, eth_channel_id, btc_channel_id, eth_amount, btc_amount,
+			payment_request, counterparty, is_initiator, direction, state
+			FROM swaps WHERE payment_hash = $1
+	`, paymentHash.Hex())
+
+	swap, err := deserSwapRow(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return swap, err
+}
+
+// FindPending returns every swap with work left to do. Whoever receives the
+// ETH side of a swap still has to redeem the HTLC after the invoice is
+// executed, so only the ETH sender is done at that point.
+func (p *PostgresSwaps) FindPending() ([]*Swap, error) {
+	rows, err := p.db.Query(`
+		SELECT id, payment_hash, preimage, eth_channel_id, btc_channel_id, eth_amount, btc_amount,
+			payment_request, counterparty, is_initiator, direction, state
+			FROM swaps WHERE state NOT IN ($1, $4) AND NOT (state = $2 AND is_initiator = (direction = $3))
+	`, string(SwapHTLCRedeemed), string(SwapInvoiceExecuted), string(SwapETHToBTC), string(SwapFailed))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Swap
+	for rows.Next() {
+		swap, err := deserSwapRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, swap)
+	}
+
+	return out, rows.Err()
+}
+
+type rawSwap struct {
+	ID             string
+	PaymentHash    string
+	Preimage       string
+	ETHChannelID   string
+	BTCChannelID   string
+	ETHAmount      string
+	BTCAmount      string
+	PaymentRequest string
+	Counterparty   string
+	IsInitiator    bool
+	Direction      string
+	State          string
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func deserSwapRow(row scanner) (*Swap, error) {
+	raw := &rawSwap{}
+	err := row.Scan(&raw.ID, &raw.PaymentHash, &raw.Preimage, &raw.ETHChannelID, &raw.BTCChannelID,
+		&raw.ETHAmount, &raw.BTCAmount, &raw.PaymentRequest, &raw.Counterparty,
+		&raw.IsInitiator, &raw.Direction, &raw.State)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := conv.HexToBytes32(raw.ID)
+	if err != nil {
+		return nil, err
+	}
+	paymentHash, err := conv.HexToBytes32(raw.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	preimage, err := conv.HexToBytes32(raw.Preimage)
+	if err != nil {
+		return nil, err
+	}
+	ethChannelId, err := conv.HexToBytes32(raw.ETHChannelID)
+	if err != nil {
+		return nil, err
+	}
+	btcChannelId, err := strconv.ParseUint(raw.BTCChannelID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	ethAmount, err := conv.StringToBig(raw.ETHAmount)
+	if err != nil {
+		return nil, err
+	}
+	btcAmount, err := conv.StringToBig(raw.BTCAmount)
+	if err != nil {
+		return nil, err
+	}
+	counterparty, err := crypto.PublicFromCompressedHex(raw.Counterparty)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Swap{
+		ID:             id,
+		PaymentHash:    paymentHash,
+		Preimage:       preimage,
+		ETHChannelID:   ethChannelId,
+		BTCChannelID:   btcChannelId,
+		ETHAmount:      ethAmount,
+		BTCAmount:      btcAmount,
+		PaymentRequest: raw.PaymentRequest,
+		Counterparty:   counterparty,
+		IsInitiator:    raw.IsInitiator,
+		Direction:      SwapDirection(raw.Direction),
+		State:          SwapState(raw.State),
+	}, nil
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"database/sql"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/kyokan/drawbridge/internal/conv"
+	"strings"
+	"time"
+)
+
+type Transactions interface {
+	Save(tx *ETHTransaction) error
+	Delete(from common.Address, nonce uint64) error
+	FindPending(from common.Address) ([]*ETHTransaction, error)
+	FindByHash(from common.Address, hash common.Hash) (*ETHTransaction, error)
+	NextNonce(from common.Address) (uint64, error)
+}
+
+type PostgresTransactions struct {
+	db *sql.DB
+}
+
+// Save records a transaction, or updates the broadcast state of one with the
+// same sender and nonce.
+func (p *PostgresTransactions) Save(ethTx *ETHTransaction) error {
+	hashes := make([]string, len(ethTx.Hashes))
+	for i, hash := range ethTx.Hashes {
+		hashes[i] = hash.Hex()
+	}
+
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO eth_transactions (
+				from_address,
+				nonce,
+				to_address,
+				value,
+				data,
+				gas_limit,
+				gas_price,
+				tx_hash,
+				tx_hashes,
+				status,
+				broadcast_at,
+				created_at
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			ON CONFLICT (from_address, nonce) DO UPDATE SET gas_price = EXCLUDED.gas_price, tx_hash = EXCLUDED.tx_hash,
+				tx_hashes = EXCLUDED.tx_hashes, status = EXCLUDED.status, broadcast_at = EXCLUDED.broadcast_at
+		`,
+			ethTx.From.Hex(),
+			ethTx.Nonce,
+			ethTx.To.Hex(),
+			ethTx.Value.Text(10),
+			hexutil.Encode(ethTx.Data),
+			ethTx.GasLimit,
+			ethTx.GasPrice.Text(10),
+			ethTx.Hash.Hex(),
+			strings.Join(hashes, ","),
+			string(ethTx.Status),
+			ethTx.BroadcastAt,
+			time.Now().Unix(),
+		)
+		return err
+	})
+}
+
+func (p *PostgresTransactions) Delete(from common.Address, nonce uint64) error {
+	return NewTransactor(p.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM eth_transactions WHERE from_address = $1 AND nonce = $2
+		`, from.Hex(), nonce)
+		return err
+	})
+}
+
+// FindPending returns from's unconfirmed transactions in nonce order.
+func (p *PostgresTransactions) FindPending(from common.Address) ([]*ETHTransaction, error) {
+	rows, err := p.db.Query(`
+		SELECT nonce, to_address, value, data, gas_limit, gas_price, tx_hash, tx_hashes, status, broadcast_at
+		FROM eth_transactions WHERE from_address = $1 AND status = $2 ORDER BY nonce ASC
+	`, from.Hex(), string(ETHTransactionPending))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*ETHTransaction
+	for rows.Next() {
+		ethTx, err := deserTransactionRow(from, rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ethTx)
+	}
+
+	return out, rows.Err()
+}
+
+// FindByHash returns the transaction from sent with hash as any of its
+// versions, or nil if there is none.
+func (p *PostgresTransactions) FindByHash(from common.Address, hash common.Hash) (*ETHTransaction, error) {
+	row := p.db.QueryRow(`
+		SELECT nonce, to_address, value, data, gas_limit, gas_price, tx_hash, tx_hashes, status, broadcast_at
+		FROM eth_transactions WHERE from_address = $1 AND $2 = ANY(string_to_array(tx_hashes, ','))
+	`, from.Hex(), hash.Hex())
+
+	ethTx, err := deserTransactionRow(from, row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return ethTx, err
+}
+
+func deserTransactionRow(from common.Address, row scanner) (*ETHTransaction, error) {
+	var nonce uint64
+	var rawTo string
+	var rawValue string
+	var rawData string
+	var gasLimit uint64
+	var rawGasPrice string
+	var rawHash string
+	var rawHashes string
+	var status string
+	var broadcastAt int64
+	err := row.Scan(&nonce, &rawTo, &rawValue, &rawData, &gasLimit, &rawGasPrice, &rawHash, &rawHashes, &status, &broadcastAt)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := conv.StringToBig(rawValue)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hexutil.Decode(rawData)
+	if err != nil {
+		return nil, err
+	}
+	gasPrice, err := conv.StringToBig(rawGasPrice)
+	if err != nil {
+		return nil, err
+	}
+	var hashes []common.Hash
+	for _, hash := range strings.Split(rawHashes, ",") {
+		hashes = append(hashes, common.HexToHash(hash))
+	}
+
+	return &ETHTransaction{
+		From:        from,
+		Nonce:       nonce,
+		To:          common.HexToAddress(rawTo),
+		Value:       value,
+		Data:        data,
+		GasLimit:    gasLimit,
+		GasPrice:    gasPrice,
+		Hash:        common.HexToHash(rawHash),
+		Hashes:      hashes,
+		Status:      ETHTransactionStatus(status),
+		BroadcastAt: broadcastAt,
+	}, nil
+}
+
+// NextNonce returns the nonce after the highest one from has used, or 0 if it
+// has never sent a transaction.
+func (p *PostgresTransactions) NextNonce(from common.Address) (uint64, error) {
+	row := p.db.QueryRow(`
+		SELECT COALESCE(MAX(nonce) + 1, 0) FROM eth_transactions WHERE from_address = $1
+	`, from.Hex())
+
+	var nonce uint64
+	err := row.Scan(&nonce)
+	return nonce, err
+}
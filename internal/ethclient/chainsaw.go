@@ -13,17 +13,32 @@ import (
 	"time"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"context"
+	"sync"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"errors"
+	"github.com/ethereum/go-ethereum"
+	"sync/atomic"
 )
 
 var csLog *zap.SugaredLogger
 
 var lightningABI abi.ABI
 
-const ConfirmationCount = 0
+const pollInterval = time.Second * 15
+
+const initialBackfillWindow = 1000
+
+const maxBackfillWindow = 10000
+
+// A failed head subscription is retried after resubscribeBackoff, doubling
+// after every failed attempt up to maxResubscribeBackoff.
+const resubscribeBackoff = time.Second * 5
+
+const maxResubscribeBackoff = time.Minute * 5
 
 var CreateSignature = crypto.Keccak256Hash([]byte("Create(uint256,uint256,bytes,bytes32)"))
 
-var SpendSignature = crypto.Keccak256Hash([]byte("Spend(uint256)"))
+var SpendSignature = crypto.Keccak256Hash([]byte("Spend(bytes32)"))
 
 var WithdrawalSignature = crypto.Keccak256Hash([]byte("Withdrawal(address,uint256)"))
 
@@ -57,27 +72,56 @@ type SpendEvent struct {
 	Id [32]byte
 }
 
+// PollObserver is notified of every batch of events the chainsaw persists.
+type PollObserver interface {
+	OnPoll(outputs *db.PolledOutputs)
+}
+
+// ChainReader is the part of Client the chainsaw needs.
+type ChainReader interface {
+	BlockHeight() (uint64, error)
+	HeaderByNumber(number uint64) (*ethtypes.Header, error)
+	FilterContract(from uint64, to uint64) ([]ethtypes.Log, error)
+	SubscribeNewHeads(ctx context.Context, ch chan<- *ethtypes.Header) (ethereum.Subscription, error)
+}
+
 type Chainsaw struct {
-	client    *Client
-	lastBlock uint64
-	db        *db.DB
-	lastTick  time.Time
+	chain         ChainReader
+	outputs       db.Outputs
+	confirmations uint64
+	lastBlock     uint64
+	window        uint64
+	lag           uint64
+	observers     []PollObserver
+	mtx           sync.Mutex
 }
 
-func NewChainsaw(client *Client, db *db.DB) *Chainsaw {
+// NewChainsaw indexes the lightning contract's events once they are
+// confirmations blocks deep.
+func NewChainsaw(client *Client, db *db.DB, confirmations uint64) *Chainsaw {
 	return &Chainsaw{
-		client:    client,
-		lastBlock: 0,
-		db:        db,
+		chain:         client,
+		outputs:       db.Outputs,
+		confirmations: confirmations,
+		lastBlock:     0,
+		observers:     []PollObserver{client.notifier},
 	}
 }
 
+func (c *Chainsaw) AddObserver(observer PollObserver) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.observers = append(c.observers, observer)
+}
+
+// Start indexes new blocks as they arrive. If the RPC endpoint supports
+// subscriptions the chainsaw polls on every new head; otherwise it polls every
+// pollInterval. A subscription that fails is retried with backoff, polling
+// in the meantime.
 func (c *Chainsaw) Start() {
 	csLog.Info("chainsaw started")
 
-	c.lastTick = time.Now()
-
-	lastBlock, err := c.db.Outputs.LastPoll()
+	lastBlock, err := c.outputs.LastPoll()
 
 	if err != nil {
 		csLog.Errorw("failed to fetch initial poll data", "err", err.Error())
@@ -86,92 +130,349 @@ func (c *Chainsaw) Start() {
 
 	c.lastBlock = lastBlock
 
+	heads := make(chan *ethtypes.Header, 16)
+	var subErr <-chan error
+	sub, err := c.chain.SubscribeNewHeads(context.Background(), heads)
+	if err != nil {
+		csLog.Infow("head subscriptions unavailable, polling", "err", err.Error(), "interval", pollInterval.String())
+	} else {
+		csLog.Info("subscribed to new heads")
+		subErr = sub.Err()
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	var resubscribe <-chan time.Time
+	backoff := resubscribeBackoff
 	for {
-		c.awaitNextTick()
-		nextBlock := c.lastBlock + 1
-		blockHeight, err := c.client.BlockHeight()
-		if err != nil {
-			csLog.Warnw("failed to get block height", "err", err.Error())
-			continue
+		if err := c.poll(); err != nil {
+			csLog.Warnw("failed to poll", "err", err.Error())
 		}
 
-		confirmedBlockHeight := blockHeight - ConfirmationCount
-		if confirmedBlockHeight < nextBlock {
-			csLog.Infow("already at latest block")
-			continue
+		select {
+		case <-heads:
+		case <-ticker.C:
+		case err := <-subErr:
+			csLog.Warnw("head subscription failed, polling", "err", err, "retryIn", backoff.String())
+			sub.Unsubscribe()
+			subErr = nil
+			resubscribe = time.After(backoff)
+		case <-resubscribe:
+			sub, err = c.chain.SubscribeNewHeads(context.Background(), heads)
+			if err != nil {
+				backoff = nextResubscribeBackoff(backoff)
+				csLog.Warnw("failed to resubscribe to new heads", "err", err.Error(), "retryIn", backoff.String())
+				resubscribe = time.After(backoff)
+				continue
+			}
+
+			csLog.Info("resubscribed to new heads")
+			subErr = sub.Err()
+			resubscribe = nil
+			backoff = resubscribeBackoff
 		}
+	}
+}
+
+func nextResubscribeBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > maxResubscribeBackoff {
+		return maxResubscribeBackoff
+	}
+	return backoff
+}
+
+// poll indexes every confirmed block we have not seen yet. Blocks beyond
+// reorg reach are backfilled in windows; the rest one at a time, so that each
+// block's hash can be checked against its successor.
+func (c *Chainsaw) poll() error {
+	blockHeight, err := c.chain.BlockHeight()
+	if err != nil {
+		return err
+	}
 
-		logs, err := c.client.FilterContract(nextBlock, confirmedBlockHeight)
+	if blockHeight < c.confirmations || blockHeight-c.confirmations <= c.lastBlock {
+		csLog.Debugw("already at latest block")
+		atomic.StoreUint64(&c.lag, 0)
+		return nil
+	}
+
+	confirmedBlockHeight := blockHeight - c.confirmations
+	for c.lastBlock < confirmedBlockHeight {
+		if confirmedBlockHeight-c.lastBlock > db.MaxReorgDepth {
+			err = c.backfill(confirmedBlockHeight - db.MaxReorgDepth)
+		} else {
+			err = c.indexBlock(c.lastBlock + 1)
+		}
 		if err != nil {
-			csLog.Warnw("failed to filter contract", "err", err)
-			continue
+			return err
 		}
 
-		results := &db.PolledOutputs{}
-
-		for _, log := range logs {
-			switch log.Topics[0] {
-			case CreateSignature:
-				out := &CreateEvent{}
-				err := lightningABI.Unpack(out, "Create", log.Data)
-				if err != nil {
-					csLog.Errorw("failed to unpack event", "err", err.Error())
-					continue
-				}
-
-				results.New = append(results.New, &db.ETHOutput{
-					ID: out.Id,
-					ContractAddress: log.Address,
-					Amount: out.Value,
-					BlockNumber: log.BlockNumber,
-					TxHash: log.TxHash,
-					Script: out.Script,
-					Type: uint8(out.Script[0]),
-					IsSpent: false,
-					IsWithdrawn: false,
-				})
-				csLog.Infow("processed CreateEvent log", "id", hexutil.Encode(out.Id[:]))
-			default:
-				csLog.Infow("received unknown event", "topic", log.Topics[0].Hex())
-			}
+		atomic.StoreUint64(&c.lag, confirmedBlockHeight-c.lastBlock)
+	}
+
+	csLog.Infow("finished poll", "blockHeight", confirmedBlockHeight)
+	return nil
+}
+
+// Lag returns how many confirmed blocks are still waiting to be indexed as
+// of the last poll.
+func (c *Chainsaw) Lag() uint64 {
+	return atomic.LoadUint64(&c.lag)
+}
+
+// backfill indexes the next window of blocks, ending no later than until, in
+// a single eth_getLogs call. The window halves whenever the provider rejects
+// the range and doubles again after every full-sized success.
+func (c *Chainsaw) backfill(until uint64) error {
+	if c.window == 0 {
+		c.window = initialBackfillWindow
+	}
+
+	from := c.lastBlock + 1
+	to := from + c.window - 1
+	if to > until {
+		to = until
+	}
+
+	first, err := c.chain.HeaderByNumber(from)
+	if err != nil {
+		return err
+	}
+	parent, err := c.outputs.FindBlock(from - 1)
+	if err != nil {
+		return err
+	}
+	if parent != nil && parent.Hash != first.ParentHash {
+		return c.rollback(from - 1)
+	}
+
+	logs, err := c.chain.FilterContract(from, to)
+	if err != nil && isRangeTooLarge(err) && c.window > 1 {
+		c.window /= 2
+		csLog.Infow("shrinking backfill window", "window", c.window, "err", err.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	last, err := c.chain.HeaderByNumber(to)
+	if err != nil {
+		return err
+	}
+
+	results := decodeLogs(logs)
+	err = c.outputs.SavePoll(results, &db.IndexedBlock{
+		Number:     to,
+		Hash:       last.Hash(),
+		ParentHash: last.ParentHash,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.lastBlock = to
+	c.notify(results)
+	csLog.Infow("backfilled blocks", "from", from, "to", to, "lag", until+db.MaxReorgDepth-to)
+
+	// a window clipped at until says nothing about whether a larger one works
+	if to == from+c.window-1 && c.window < maxBackfillWindow {
+		c.window *= 2
+	}
+	return nil
+}
+
+// isRangeTooLarge recognizes the errors providers return when an eth_getLogs
+// range spans too many blocks or matches too many logs.
+func isRangeTooLarge(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"too many", "more than", "limit exceeded", "range is too large", "block range"} {
+		if strings.Contains(msg, s) {
+			return true
 		}
+	}
 
-		err = c.db.Outputs.SavePoll(results, confirmedBlockHeight)
-		if err != nil {
-			csLog.Errorw("failed to save poll", "err", err.Error())
-			continue
+	return false
+}
+
+// indexBlock saves the events in block number, or rolls back to the last
+// common ancestor if the block does not build on the one we indexed before it.
+func (c *Chainsaw) indexBlock(number uint64) error {
+	header, err := c.chain.HeaderByNumber(number)
+	if err != nil {
+		return err
+	}
+
+	parent, err := c.outputs.FindBlock(number - 1)
+	if err != nil {
+		return err
+	}
+	if parent != nil && parent.Hash != header.ParentHash {
+		return c.rollback(number - 1)
+	}
+
+	logs, err := c.chain.FilterContract(number, number)
+	if err != nil {
+		return err
+	}
+
+	hash := header.Hash()
+	for _, log := range logs {
+		if log.BlockHash != hash {
+			return errors.New("block changed while it was being indexed")
 		}
+	}
 
-		csLog.Infow("finished poll", "blockHeight", confirmedBlockHeight)
-		c.lastBlock = confirmedBlockHeight
+	results := decodeLogs(logs)
+	err = c.outputs.SavePoll(results, &db.IndexedBlock{
+		Number:     number,
+		Hash:       hash,
+		ParentHash: header.ParentHash,
+	})
+	if err != nil {
+		return err
 	}
+
+	c.lastBlock = number
+	c.notify(results)
+	return nil
 }
 
-func (c *Chainsaw) awaitNextTick() {
-	diff := time.Since(c.lastTick).Seconds()
+// rollback walks back from block from until it finds a block whose indexed
+// hash is still on the chain, then discards everything indexed after it.
+func (c *Chainsaw) rollback(from uint64) error {
+	ancestor := from
+	for ancestor > 0 {
+		stored, err := c.outputs.FindBlock(ancestor)
+		if err != nil {
+			return err
+		}
+		if stored == nil {
+			csLog.Errorw("reorganization is deeper than the indexed block history", "block", ancestor)
+			break
+		}
+
+		header, err := c.chain.HeaderByNumber(ancestor)
+		if err != nil {
+			return err
+		}
+		if header.Hash() == stored.Hash {
+			break
+		}
 
-	if diff < 15 {
-		time.Sleep(time.Duration(15-diff) * time.Second)
+		ancestor--
 	}
 
-	c.lastTick = time.Now()
+	csLog.Warnw("chain reorganization detected", "forkBlock", ancestor, "orphanedBlocks", c.lastBlock-ancestor)
+	if err := c.outputs.Rollback(ancestor); err != nil {
+		return err
+	}
+
+	c.lastBlock = ancestor
+	return nil
 }
 
-func AwaitOutput(ctx context.Context, d *db.DB, outputId common.Hash) (*db.ETHOutput, error) {
-	ticker := time.NewTicker(time.Second * 10)
+// decodeLogs turns contract logs into a poll result. The contract emits a
+// Spend for every input of a withdrawal before the Withdrawal itself, so the
+// outputs spent earlier in a withdrawal's transaction are the ones withdrawn.
+func decodeLogs(logs []ethtypes.Log) *db.PolledOutputs {
+	results := &db.PolledOutputs{
+		SpentIn: make(map[common.Hash]common.Hash),
+	}
+	spentByTx := make(map[common.Hash][]common.Hash)
 
-	for {
-		select {
-		case <- ticker.C:
-			output, err := d.Outputs.FindById(outputId)
+	for _, log := range logs {
+		if len(log.Topics) == 0 {
+			continue
+		}
+
+		switch log.Topics[0] {
+		case CreateSignature:
+			out := &CreateEvent{}
+			err := lightningABI.Unpack(out, "Create", log.Data)
 			if err != nil {
-				return nil, err
+				csLog.Errorw("failed to unpack event", "err", err.Error())
+				continue
 			}
-			if output != nil {
-				return output, err
+
+			results.New = append(results.New, &db.ETHOutput{
+				ID:              out.Id,
+				ContractAddress: log.Address,
+				Amount:          out.Value,
+				BlockNumber:     log.BlockNumber,
+				TxHash:          log.TxHash,
+				Script:          out.Script,
+				Type:            uint8(out.Script[0]),
+				IsSpent:         false,
+				IsWithdrawn:     false,
+			})
+			csLog.Infow("processed CreateEvent log", "id", hexutil.Encode(out.Id[:]))
+		case SpendSignature:
+			out := &SpendEvent{}
+			err := lightningABI.Unpack(out, "Spend", log.Data)
+			if err != nil {
+				csLog.Errorw("failed to unpack event", "err", err.Error())
+				continue
 			}
-			case <- ctx.Done():
-				return nil, ctx.Err()
+
+			results.Spent = append(results.Spent, out.Id)
+			results.SpentIn[out.Id] = log.TxHash
+			spentByTx[log.TxHash] = append(spentByTx[log.TxHash], out.Id)
+			csLog.Infow("processed SpendEvent log", "id", hexutil.Encode(out.Id[:]))
+		case WithdrawalSignature:
+			out := &WithdrawalEvent{}
+			err := lightningABI.Unpack(out, "Withdrawal", log.Data)
+			if err != nil {
+				csLog.Errorw("failed to unpack event", "err", err.Error())
+				continue
+			}
+
+			results.Withdrawn = append(results.Withdrawn, spentByTx[log.TxHash]...)
+			delete(spentByTx, log.TxHash)
+			csLog.Infow("processed WithdrawalEvent log", "owner", out.Owner.Hex(), "value", out.Value.Text(10))
+		default:
+			csLog.Infow("received unknown event", "topic", log.Topics[0].Hex())
 		}
 	}
-}
\ No newline at end of file
+
+	return results
+}
+
+func (c *Chainsaw) notify(results *db.PolledOutputs) {
+	c.mtx.Lock()
+	observers := make([]PollObserver, len(c.observers))
+	copy(observers, c.observers)
+	c.mtx.Unlock()
+
+	for _, observer := range observers {
+		observer.OnPoll(results)
+	}
+}
+
+// AwaitOutput returns once outputId has been indexed. It wakes as soon as
+// client's chainsaw indexes the output, and also re-checks the db every
+// pollInterval in case another process indexed it.
+func AwaitOutput(ctx context.Context, client *Client, d *db.DB, outputId common.Hash) (*db.ETHOutput, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		indexed, cancel := client.notifier.subscribe(outputId)
+		output, err := d.Outputs.FindById(outputId)
+		if err != nil || output != nil {
+			cancel()
+			return output, err
+		}
+
+		select {
+		case <-indexed:
+		case <-ticker.C:
+		case <-ctx.Done():
+			cancel()
+			return nil, ctx.Err()
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,321 @@
+package ethclient
+
+import (
+	"testing"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"errors"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/pkg/txout"
+	"context"
+	"github.com/ethereum/go-ethereum"
+	"time"
+)
+
+func TestDecodeLogs_SpendAndWithdrawal(t *testing.T) {
+	spent := common.HexToHash("0x01")
+	withdrawn := common.HexToHash("0x02")
+	spendTx := common.HexToHash("0xaa")
+	withdrawTx := common.HexToHash("0xbb")
+
+	withdrawal := append(common.LeftPadBytes(common.HexToAddress("0x627306090abab3a6e1400e9345bc60c78a8bef57").Bytes(), 32),
+		common.LeftPadBytes([]byte{100}, 32)...)
+
+	results := decodeLogs([]ethtypes.Log{
+		{Topics: []common.Hash{SpendSignature}, Data: spent.Bytes(), TxHash: spendTx},
+		{Topics: []common.Hash{SpendSignature}, Data: withdrawn.Bytes(), TxHash: withdrawTx},
+		{Topics: []common.Hash{WithdrawalSignature}, Data: withdrawal, TxHash: withdrawTx},
+	})
+
+	assert.Empty(t, results.New)
+	assert.Equal(t, []common.Hash{spent, withdrawn}, results.Spent)
+	assert.Equal(t, []common.Hash{withdrawn}, results.Withdrawn)
+	assert.Equal(t, map[common.Hash]common.Hash{spent: spendTx, withdrawn: withdrawTx}, results.SpentIn)
+}
+
+type simChain struct {
+	headers    []*ethtypes.Header
+	logs       map[common.Hash][]ethtypes.Log
+	fork       byte
+	maxResults int
+}
+
+func newSimChain() *simChain {
+	return &simChain{
+		headers: []*ethtypes.Header{{Number: big.NewInt(0)}},
+		logs:    make(map[common.Hash][]ethtypes.Log),
+	}
+}
+
+func (s *simChain) mine(logs ...ethtypes.Log) {
+	parent := s.headers[len(s.headers)-1]
+	header := &ethtypes.Header{
+		ParentHash: parent.Hash(),
+		Number:     big.NewInt(int64(len(s.headers))),
+		Extra:      []byte{s.fork},
+	}
+	s.headers = append(s.headers, header)
+
+	for _, log := range logs {
+		log.BlockHash = header.Hash()
+		log.BlockNumber = header.Number.Uint64()
+		s.logs[header.Hash()] = append(s.logs[header.Hash()], log)
+	}
+}
+
+// reorg drops the last depth blocks. Blocks mined afterwards get different
+// hashes from the ones they replace.
+func (s *simChain) reorg(depth int) {
+	s.headers = s.headers[:len(s.headers)-depth]
+	s.fork++
+}
+
+func (s *simChain) BlockHeight() (uint64, error) {
+	return uint64(len(s.headers) - 1), nil
+}
+
+func (s *simChain) HeaderByNumber(number uint64) (*ethtypes.Header, error) {
+	if number >= uint64(len(s.headers)) {
+		return nil, errors.New("block not found")
+	}
+
+	return s.headers[number], nil
+}
+
+func (s *simChain) SubscribeNewHeads(ctx context.Context, ch chan<- *ethtypes.Header) (ethereum.Subscription, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s *simChain) FilterContract(from uint64, to uint64) ([]ethtypes.Log, error) {
+	var out []ethtypes.Log
+	for n := from; n <= to && n < uint64(len(s.headers)); n++ {
+		out = append(out, s.logs[s.headers[n].Hash()]...)
+	}
+
+	if s.maxResults > 0 && len(out) > s.maxResults {
+		return nil, errors.New("query returned more than 2 results")
+	}
+
+	return out, nil
+}
+
+type memOutput struct {
+	output     *db.ETHOutput
+	spentBlock uint64
+	orphaned   bool
+}
+
+// memOutputs mirrors PostgresOutputs in memory.
+type memOutputs struct {
+	outputs map[common.Hash]*memOutput
+	blocks  map[uint64]*db.IndexedBlock
+	last    uint64
+}
+
+func newMemOutputs() *memOutputs {
+	return &memOutputs{
+		outputs: make(map[common.Hash]*memOutput),
+		blocks:  make(map[uint64]*db.IndexedBlock),
+	}
+}
+
+func (m *memOutputs) SavePoll(outputs *db.PolledOutputs, block *db.IndexedBlock) error {
+	for _, out := range outputs.New {
+		m.outputs[out.ID] = &memOutput{output: out}
+	}
+	for _, id := range outputs.Spent {
+		if out, ok := m.outputs[id]; ok {
+			out.output.IsSpent = true
+			out.spentBlock = block.Number
+		}
+	}
+	for _, id := range outputs.Withdrawn {
+		if out, ok := m.outputs[id]; ok {
+			out.output.IsWithdrawn = true
+		}
+	}
+
+	m.blocks[block.Number] = block
+	m.last = block.Number
+	return nil
+}
+
+func (m *memOutputs) LastPoll() (uint64, error) {
+	return m.last, nil
+}
+
+func (m *memOutputs) FindBlock(number uint64) (*db.IndexedBlock, error) {
+	return m.blocks[number], nil
+}
+
+func (m *memOutputs) Rollback(toBlock uint64) error {
+	for _, out := range m.outputs {
+		if out.output.BlockNumber > toBlock {
+			out.orphaned = true
+		}
+		if out.spentBlock > toBlock {
+			out.output.IsSpent = false
+			out.output.IsWithdrawn = false
+			out.spentBlock = 0
+		}
+	}
+	for number := range m.blocks {
+		if number > toBlock {
+			delete(m.blocks, number)
+		}
+	}
+
+	m.last = toBlock
+	return nil
+}
+
+func (m *memOutputs) FindById(id common.Hash) (*db.ETHOutput, error) {
+	out, ok := m.outputs[id]
+	if !ok || out.orphaned {
+		return nil, nil
+	}
+
+	return out.output, nil
+}
+
+func (m *memOutputs) FindSpendableByOwnerAmount(script *txout.Payment, amount *big.Int) (*db.ETHOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *memOutputs) FindUnspentByType(outputType txout.OutputType) ([]*db.ETHOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+func createLog(t *testing.T, id common.Hash) ethtypes.Log {
+	data, err := lightningABI.Events["Create"].Inputs.Pack(big.NewInt(100), big.NewInt(1), []byte{1}, [32]byte(id))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	return ethtypes.Log{Topics: []common.Hash{CreateSignature}, Data: data, TxHash: id}
+}
+
+func spendLog(id common.Hash) ethtypes.Log {
+	return ethtypes.Log{Topics: []common.Hash{SpendSignature}, Data: id.Bytes(), TxHash: id}
+}
+
+func testChainsaw(chain *simChain, confirmations uint64) (*Chainsaw, *memOutputs) {
+	outputs := newMemOutputs()
+	return &Chainsaw{
+		chain:         chain,
+		outputs:       outputs,
+		confirmations: confirmations,
+	}, outputs
+}
+
+func TestChainsaw_IndexesConfirmedBlocks(t *testing.T) {
+	a := common.HexToHash("0x0a")
+	chain := newSimChain()
+	chain.mine(createLog(t, a))
+	chain.mine(spendLog(a))
+	chain.mine()
+	c, outputs := testChainsaw(chain, 1)
+
+	assert.NoError(t, c.poll())
+	assert.Equal(t, uint64(2), c.lastBlock)
+	out, _ := outputs.FindById(a)
+	assert.True(t, out.IsSpent)
+	assert.Equal(t, uint64(2), outputs.outputs[a].spentBlock)
+}
+
+func TestChainsaw_Reorg(t *testing.T) {
+	a := common.HexToHash("0x0a")
+	b := common.HexToHash("0x0b")
+	orphan := common.HexToHash("0x0c")
+	replacement := common.HexToHash("0x0d")
+
+	chain := newSimChain()
+	chain.mine(createLog(t, a))
+	chain.mine(createLog(t, b), createLog(t, orphan))
+	chain.mine(spendLog(a))
+	c, outputs := testChainsaw(chain, 0)
+	assert.NoError(t, c.poll())
+	assert.Equal(t, uint64(3), c.lastBlock)
+
+	chain.reorg(2)
+	chain.mine(createLog(t, replacement))
+	chain.mine(createLog(t, b))
+	chain.mine()
+	assert.NoError(t, c.poll())
+
+	assert.Equal(t, uint64(4), c.lastBlock)
+	assert.Equal(t, chain.headers[4].Hash(), outputs.blocks[4].Hash)
+	out, _ := outputs.FindById(a)
+	assert.False(t, out.IsSpent)
+	out, _ = outputs.FindById(orphan)
+	assert.Nil(t, out)
+	out, _ = outputs.FindById(replacement)
+	assert.Equal(t, uint64(2), out.BlockNumber)
+	out, _ = outputs.FindById(b)
+	assert.Equal(t, uint64(3), out.BlockNumber)
+}
+
+func TestChainsaw_ReorgToShorterChain(t *testing.T) {
+	a := common.HexToHash("0x0a")
+	chain := newSimChain()
+	chain.mine()
+	chain.mine(createLog(t, a))
+	chain.mine()
+	c, outputs := testChainsaw(chain, 0)
+	assert.NoError(t, c.poll())
+
+	// the fork is only noticed once it grows past the blocks it replaced
+	chain.reorg(3)
+	chain.mine()
+	chain.mine()
+	assert.NoError(t, c.poll())
+	assert.Equal(t, uint64(3), c.lastBlock)
+
+	chain.mine()
+	chain.mine()
+	assert.NoError(t, c.poll())
+	assert.Equal(t, uint64(4), c.lastBlock)
+	assert.Len(t, outputs.blocks, 4)
+	out, _ := outputs.FindById(a)
+	assert.Nil(t, out)
+}
+
+func TestChainsaw_Backfill(t *testing.T) {
+	chain := newSimChain()
+	chain.maxResults = 2
+	var ids []common.Hash
+	for i := 0; i < 1500; i++ {
+		if i%100 == 0 {
+			id := common.BigToHash(big.NewInt(int64(i + 1)))
+			ids = append(ids, id)
+			chain.mine(createLog(t, id))
+			continue
+		}
+		chain.mine()
+	}
+	c, outputs := testChainsaw(chain, 0)
+
+	assert.NoError(t, c.poll())
+	assert.Equal(t, uint64(1500), c.lastBlock)
+	assert.Equal(t, uint64(0), c.Lag())
+	assert.True(t, c.window < initialBackfillWindow)
+	for i, id := range ids {
+		out, _ := outputs.FindById(id)
+		assert.Equal(t, uint64(i*100+1), out.BlockNumber)
+	}
+	assert.Equal(t, chain.headers[1500].Hash(), outputs.blocks[1500].Hash)
+}
+
+func TestIsRangeTooLarge(t *testing.T) {
+	assert.True(t, isRangeTooLarge(errors.New("query returned more than 10000 results")))
+	assert.True(t, isRangeTooLarge(errors.New("Log response size exceeded. You can make eth_getLogs requests with up to a 2K block range")))
+	assert.False(t, isRangeTooLarge(errors.New("connection refused")))
+}
+
+func TestNextResubscribeBackoff(t *testing.T) {
+	assert.Equal(t, resubscribeBackoff*2, nextResubscribeBackoff(resubscribeBackoff))
+	assert.Equal(t, maxResubscribeBackoff, nextResubscribeBackoff(maxResubscribeBackoff/2+time.Second))
+	assert.Equal(t, maxResubscribeBackoff, nextResubscribeBackoff(maxResubscribeBackoff))
+}
@@ -13,55 +13,97 @@ import (
 	"github.com/kyokan/drawbridge/pkg/crypto"
 	"github.com/kyokan/drawbridge/internal/conv"
 	"github.com/kyokan/drawbridge/pkg/txout"
-		)
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"strings"
+	"github.com/kyokan/drawbridge/internal/db"
+)
+
+var erc20ABI abi.ABI
+
+func init() {
+	tAbi, err := abi.JSON(strings.NewReader(contracts.ERC20ABI))
+
+	if err != nil {
+		// can only happen if ABI generation is invalid during compilation
+		panic(err)
+	}
+
+	erc20ABI = tAbi
+}
 
 type DepositResult struct {
 }
 
+// Backend is what the client needs from an Ethereum node: contract calls,
+// plus the nonce and receipt lookups its TxManager relies on.
+type Backend interface {
+	bind.ContractBackend
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*ethtypes.Receipt, error)
+}
+
 type Client struct {
 	keyManager       *wallet.KeyManager
 	rpc              *rpc.Client
-	client           *ethclient.Client
+	backend          bind.ContractBackend
 	lightning        *contracts.LightningERC20
-	token            *contracts.ERC20
 	lightningAddress common.Address
 	erc20Address     common.Address
+	notifier         *OutputNotifier
+	txm              *TxManager
 }
 
-func NewClient(keyManager *wallet.KeyManager, url string, address string) (*Client, error) {
-	lightningAddress := common.HexToAddress(address)
+func NewClient(keyManager *wallet.KeyManager, txs db.Transactions, url string, address string) (*Client, error) {
 	r, err := rpc.DialContext(context.Background(), url)
 	if err != nil {
 		return nil, err
 	}
 
 	conn := ethclient.NewClient(r)
-	lightning, err := contracts.NewLightningERC20(lightningAddress, conn)
+	wrapped, err := NewClientFromBackend(keyManager, txs, conn, address)
 	if err != nil {
 		return nil, err
 	}
 
-	tokenContractAddress, err := lightning.TokenAddress(nil)
+	tokenContractAddress, err := wrapped.lightning.TokenAddress(nil)
 	if err != nil {
 		return nil, err
 	}
 
-	erc20Contract, err := contracts.NewERC20(tokenContractAddress, conn)
+	wrapped.rpc = r
+	wrapped.erc20Address = tokenContractAddress
+	return wrapped, nil
+}
+
+// NewClientFromBackend talks to the lightning contract through an existing
+// backend, such as a simulated one. It does not look up the ERC20 token and
+// has no raw RPC connection, so only calls on the lightning contract itself
+// are available.
+func NewClientFromBackend(keyManager *wallet.KeyManager, txs db.Transactions, backend Backend, address string) (*Client, error) {
+	lightningAddress := common.HexToAddress(address)
+	lightning, err := contracts.NewLightningERC20(lightningAddress, backend)
 	if err != nil {
 		return nil, err
 	}
 
-	wrapped := &Client{
+	return &Client{
 		keyManager:       keyManager,
-		rpc:              r,
-		client:           conn,
+		backend:          backend,
 		lightning:        lightning,
-		token:            erc20Contract,
 		lightningAddress: lightningAddress,
-		erc20Address:     tokenContractAddress,
-	}
+		notifier:         NewOutputNotifier(),
+		txm:              NewTxManager(keyManager, backend, txs),
+	}, nil
+}
 
-	return wrapped, nil
+// TxManager returns the manager every transaction from this client is sent
+// through. Its Start loop must be running for sent transactions to be
+// confirmed or rebroadcast.
+func (c *Client) TxManager() *TxManager {
+	return c.txm
 }
 
 func (c *Client) BlockHeight() (uint64, error) {
@@ -79,6 +121,35 @@ func (c *Client) BlockHeight() (uint64, error) {
 	return blockHeight.Uint64(), err
 }
 
+func (c *Client) HeaderByNumber(number uint64) (*ethtypes.Header, error) {
+	var header *ethtypes.Header
+	err := c.rpc.Call(&header, "eth_getBlockByNumber", hexutil.EncodeUint64(number), false)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, errors.New("block not found")
+	}
+
+	return header, nil
+}
+
+// SubscribeNewHeads delivers every new block header to ch. It fails with
+// rpc.ErrNotificationsUnsupported unless the RPC URL is a websocket or IPC
+// endpoint.
+func (c *Client) SubscribeNewHeads(ctx context.Context, ch chan<- *ethtypes.Header) (ethereum.Subscription, error) {
+	if c.rpc == nil {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+
+	sub, err := c.rpc.EthSubscribe(ctx, ch, "newHeads")
+	if err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
+
 func (c *Client) FilterContract(from uint64, to uint64) ([]ethtypes.Log, error) {
 	q := ethereum.FilterQuery{
 		FromBlock: big.NewInt(int64(from)),
@@ -88,36 +159,93 @@ func (c *Client) FilterContract(from uint64, to uint64) ([]ethtypes.Log, error)
 		},
 	}
 
-	return c.client.FilterLogs(context.Background(), q)
+	return c.backend.FilterLogs(context.Background(), q)
 }
 
 func (c *Client) GetERC20Address() (common.Address) {
 	return c.erc20Address
 }
 
-func (c *Client) ApproveERC20(tokens *big.Int) (*ethtypes.Transaction, error) {
-	return c.token.Approve(c.keyManager.NewTransactor(0), c.lightningAddress, tokens)
+func (c *Client) ApproveERC20(tokens *big.Int) (*PendingTx, error) {
+	data, err := erc20ABI.Pack("approve", c.lightningAddress, tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.txm.Send(c.erc20Address, big.NewInt(0), data, 0)
 }
 
-func (c *Client) Deposit(tokens *big.Int) (*ethtypes.Transaction, error) {
-	tx, err := c.lightning.Deposit(c.keyManager.NewTransactor(500000), tokens)
+func (c *Client) Deposit(tokens *big.Int) (*PendingTx, error) {
+	data, err := lightningABI.Pack("deposit", tokens)
 	if err != nil {
 		return nil, err
 	}
 
-	return tx, err
+	return c.txm.Send(c.lightningAddress, big.NewInt(0), data, 500000)
 }
 
-func (c *Client) DepositMultisig(req *txout.SpendRequest, sig crypto.Signature) (*ethtypes.Transaction, error) {
+func (c *Client) DepositMultisig(req *txout.SpendRequest, sig crypto.Signature) (*PendingTx, error) {
 	inputs, outputs, err := txout.WireData(req, sig)
 	if err != nil {
 		return nil, err
 	}
 
-	tx, err := c.lightning.Spend(c.keyManager.NewTransactor(3000000), inputs, outputs)
+	return c.spend(inputs, outputs)
+}
+
+// SpendMultisig spends a multisig output using both parties' signatures. The
+// signatures must be ordered to match the sorted addresses in the multisig
+// script.
+func (c *Client) SpendMultisig(req *txout.SpendRequest, aliceSig crypto.Signature, bobSig crypto.Signature) (*PendingTx, error) {
+	sigs := make([]byte, 0, len(aliceSig)+len(bobSig))
+	sigs = append(sigs, aliceSig...)
+	sigs = append(sigs, bobSig...)
+
+	inputs, outputs, err := txout.WireData(req, sigs)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.spend(inputs, outputs)
+}
+
+// Spend spends an output that only needs a single signature to unlock.
+func (c *Client) Spend(req *txout.SpendRequest, sig crypto.Signature) (*PendingTx, error) {
+	inputs, outputs, err := txout.WireData(req, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.spend(inputs, outputs)
+}
+
+// RedeemHTLC claims an offered HTLC output with its payment preimage, paying
+// the full amount to us. It must be sent from the HTLC's redemption address.
+func (c *Client) RedeemHTLC(outputId common.Hash, amount *big.Int, preimage [32]byte) (*PendingTx, error) {
+	req := &txout.SpendRequest{
+		InputID: outputId,
+		Witness: txout.NewOfferedHTLCWitness(preimage),
+		Values: []*big.Int{
+			amount,
+		},
+		Outputs: []txout.Output{
+			txout.NewPayment(c.keyManager.PublicKey().ETHAddress()),
+		},
+	}
+
+	inputs, outputs, err := txout.WireData(req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.spend(inputs, outputs)
+}
+
+func (c *Client) spend(inputs []byte, outputs []byte) (*PendingTx, error) {
+	data, err := lightningABI.Pack("spend", inputs, outputs)
 	if err != nil {
 		return nil, err
 	}
 
-	return tx, nil
+	return c.txm.Send(c.lightningAddress, big.NewInt(0), data, 3000000)
 }
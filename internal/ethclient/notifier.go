@@ -0,0 +1,58 @@
+package ethclient
+
+import (
+	"sync"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/internal/db"
+)
+
+// OutputNotifier wakes AwaitOutput callers as soon as the chainsaw indexes
+// the output they are waiting for, instead of leaving them to poll the db.
+type OutputNotifier struct {
+	waiters map[common.Hash]map[chan struct{}]struct{}
+	mtx     sync.Mutex
+}
+
+func NewOutputNotifier() *OutputNotifier {
+	return &OutputNotifier{
+		waiters: make(map[common.Hash]map[chan struct{}]struct{}),
+	}
+}
+
+// subscribe returns a channel that is closed once outputId is indexed, and a
+// function that releases it if the caller stops waiting first.
+func (n *OutputNotifier) subscribe(outputId common.Hash) (<-chan struct{}, func()) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	ch := make(chan struct{})
+	if n.waiters[outputId] == nil {
+		n.waiters[outputId] = make(map[chan struct{}]struct{})
+	}
+	n.waiters[outputId][ch] = struct{}{}
+
+	return ch, func() {
+		n.mtx.Lock()
+		defer n.mtx.Unlock()
+
+		if _, waiting := n.waiters[outputId][ch]; !waiting {
+			return
+		}
+		delete(n.waiters[outputId], ch)
+		if len(n.waiters[outputId]) == 0 {
+			delete(n.waiters, outputId)
+		}
+	}
+}
+
+func (n *OutputNotifier) OnPoll(outputs *db.PolledOutputs) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	for _, out := range outputs.New {
+		for ch := range n.waiters[out.ID] {
+			close(ch)
+		}
+		delete(n.waiters, out.ID)
+	}
+}
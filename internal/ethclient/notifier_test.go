@@ -0,0 +1,33 @@
+package ethclient
+
+import (
+	"testing"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/kyokan/drawbridge/internal/db"
+)
+
+func TestOutputNotifier(t *testing.T) {
+	n := NewOutputNotifier()
+	a := common.HexToHash("0x0a")
+	b := common.HexToHash("0x0b")
+
+	waitA, _ := n.subscribe(a)
+	waitB, cancelB := n.subscribe(b)
+	cancelB()
+	cancelB()
+
+	n.OnPoll(&db.PolledOutputs{New: []*db.ETHOutput{{ID: b}}})
+	select {
+	case <-waitA:
+		t.Fatal("woke for the wrong output")
+	case <-waitB:
+		t.Fatal("woke after cancelling")
+	default:
+	}
+
+	n.OnPoll(&db.PolledOutputs{New: []*db.ETHOutput{{ID: a}}})
+	_, open := <-waitA
+	assert.False(t, open)
+	assert.Empty(t, n.waiters)
+}
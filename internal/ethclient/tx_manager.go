@@ -0,0 +1,468 @@
+package ethclient
+
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/internal/wallet"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+const txRPCTimeout = time.Second * 30
+
+// rebroadcastTimeout is how long a transaction may go unmined before it is
+// sent again, with a higher gas price if we are below maxGasPrice.
+const rebroadcastTimeout = time.Minute * 3
+
+// feeBumpPercent must stay above the 10% geth requires of a replacement.
+const feeBumpPercent = 20
+
+var maxGasPrice = big.NewInt(200000000000)
+
+var ErrTxReverted = errors.New("transaction reverted")
+
+var ErrTxDropped = errors.New("transaction nonce was used by another transaction")
+
+// TxBackend is the part of an Ethereum node the tx manager needs.
+type TxBackend interface {
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error)
+	SendTransaction(ctx context.Context, tx *ethtypes.Transaction) error
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*ethtypes.Receipt, error)
+}
+
+// PendingTx is a handle on a transaction sent through a TxManager.
+type PendingTx struct {
+	nonce   uint64
+	tx      *ethtypes.Transaction
+	receipt *ethtypes.Receipt
+	err     error
+	done    chan struct{}
+	mtx     sync.Mutex
+}
+
+func newPendingTx(tx *ethtypes.Transaction) *PendingTx {
+	return &PendingTx{
+		nonce: tx.Nonce(),
+		tx:    tx,
+		done:  make(chan struct{}),
+	}
+}
+
+func (p *PendingTx) Nonce() uint64 {
+	return p.nonce
+}
+
+// Transaction returns the version of the transaction that was broadcast
+// last. A fee bump can leave an earlier version to be mined; the receipt's
+// TxHash says which one was.
+func (p *PendingTx) Transaction() *ethtypes.Transaction {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.tx
+}
+
+func (p *PendingTx) Hash() common.Hash {
+	return p.Transaction().Hash()
+}
+
+// Wait returns the transaction's receipt once it is mined. It fails with
+// ErrTxReverted if the transaction was mined but reverted, and with
+// ErrTxDropped if another transaction took its nonce.
+func (p *PendingTx) Wait(ctx context.Context) (*ethtypes.Receipt, error) {
+	select {
+	case <-p.done:
+		return p.receipt, p.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *PendingTx) replace(tx *ethtypes.Transaction) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.tx = tx
+}
+
+func (p *PendingTx) resolve(receipt *ethtypes.Receipt, err error) {
+	p.mtx.Lock()
+	p.receipt = receipt
+	p.err = err
+	p.mtx.Unlock()
+	close(p.done)
+}
+
+// TxManager sends every transaction from our key. It assigns nonces itself
+// so that transactions sent in quick succession don't collide, persists
+// them before they are broadcast, and re-sends them with a higher gas price
+// when they aren't mined within rebroadcastTimeout.
+type TxManager struct {
+	km          *wallet.KeyManager
+	backend     TxBackend
+	txs         db.Transactions
+	from        common.Address
+	nonce       uint64
+	nonceLoaded bool
+	handles     map[uint64]*PendingTx
+	sending     map[uint64]bool
+	mtx         sync.Mutex
+}
+
+func NewTxManager(km *wallet.KeyManager, backend TxBackend, txs db.Transactions) *TxManager {
+	return &TxManager{
+		km:      km,
+		backend: backend,
+		txs:     txs,
+		from:    km.PublicKey().ETHAddress(),
+		handles: make(map[uint64]*PendingTx),
+		sending: make(map[uint64]bool),
+	}
+}
+
+// Send signs and broadcasts a call to to. The gas limit is estimated if
+// gasLimit is 0. If the node rejects the transaction its nonce is released
+// and the error returned; once it is accepted, the manager keeps it alive
+// until it is mined.
+func (m *TxManager) Send(to common.Address, value *big.Int, data []byte, gasLimit uint64) (*PendingTx, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), txRPCTimeout)
+	defer cancel()
+
+	if gasLimit == 0 {
+		estimate, err := m.backend.EstimateGas(ctx, ethereum.CallMsg{
+			From:  m.from,
+			To:    &to,
+			Value: value,
+			Data:  data,
+		})
+		if err != nil {
+			return nil, err
+		}
+		gasLimit = estimate
+	}
+
+	gasPrice, err := m.backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := m.reserveNonce(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &db.ETHTransaction{
+		From:     m.from,
+		Nonce:    nonce,
+		To:       to,
+		Value:    value,
+		Data:     data,
+		GasLimit: gasLimit,
+		GasPrice: gasPrice,
+		Status:   db.ETHTransactionPending,
+	}
+	signed, err := m.sign(record)
+	if err != nil {
+		m.releaseNonce(record, err)
+		return nil, err
+	}
+
+	// persisted first so that a crash mid-broadcast can't lose track of a
+	// transaction the network may already have
+	if err := m.txs.Save(record); err != nil {
+		m.releaseNonce(record, err)
+		return nil, err
+	}
+
+	handle := newPendingTx(signed)
+	m.mtx.Lock()
+	m.handles[nonce] = handle
+	m.mtx.Unlock()
+
+	err = m.backend.SendTransaction(ctx, signed)
+	if err != nil && !isKnownTx(err) {
+		m.mtx.Lock()
+		delete(m.handles, nonce)
+		m.mtx.Unlock()
+		m.releaseNonce(record, err)
+		return nil, err
+	}
+
+	m.mtx.Lock()
+	delete(m.sending, nonce)
+	m.mtx.Unlock()
+	csLog.Infow("sent transaction", "txHash", signed.Hash().Hex(), "nonce", nonce, "gasPrice", gasPrice.Text(10))
+	return handle, nil
+}
+
+// reserveNonce takes the next nonce. It is marked as sending until Send is
+// done with it, so that check leaves its record alone in the meantime.
+func (m *TxManager) reserveNonce(ctx context.Context) (uint64, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if !m.nonceLoaded {
+		if err := m.loadNonce(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	nonce := m.nonce
+	m.nonce++
+	m.sending[nonce] = true
+	return nonce, nil
+}
+
+// releaseNonce gives back the nonce of a transaction that was never
+// broadcast. If another Send has taken a later nonce in the meantime, the
+// gap is filled with an empty transfer to ourselves instead, since it would
+// otherwise stall every later transaction.
+func (m *TxManager) releaseNonce(record *db.ETHTransaction, sendErr error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	defer delete(m.sending, record.Nonce)
+
+	// something else is sending from our key; resync with the node
+	if isNonceTooLow(sendErr) {
+		m.nonceLoaded = false
+	}
+
+	// Save doesn't overwrite the call itself, so the record goes either way
+	if err := m.txs.Delete(m.from, record.Nonce); err != nil {
+		csLog.Errorw("failed to release nonce", "nonce", record.Nonce, "err", err.Error())
+		return
+	}
+	if isNonceTooLow(sendErr) {
+		return
+	}
+	if m.nonce == record.Nonce+1 {
+		m.nonce--
+		return
+	}
+
+	filler := &db.ETHTransaction{
+		From:     m.from,
+		Nonce:    record.Nonce,
+		To:       m.from,
+		Value:    big.NewInt(0),
+		GasLimit: 21000,
+		GasPrice: record.GasPrice,
+		Status:   db.ETHTransactionPending,
+	}
+	if _, err := m.sign(filler); err != nil {
+		csLog.Errorw("failed to fill nonce gap", "nonce", record.Nonce, "err", err.Error())
+		return
+	}
+	// left for the next check to broadcast
+	filler.BroadcastAt = 0
+	if err := m.txs.Save(filler); err != nil {
+		csLog.Errorw("failed to fill nonce gap", "nonce", record.Nonce, "err", err.Error())
+		return
+	}
+	csLog.Warnw("filling nonce gap", "nonce", record.Nonce)
+}
+
+// Start checks on pending transactions every pollInterval.
+func (m *TxManager) Start() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.check(); err != nil {
+			csLog.Errorw("failed to check pending transactions", "err", err.Error())
+		}
+
+		<-ticker.C
+	}
+}
+
+func (m *TxManager) loadNonce(ctx context.Context) error {
+	pending, err := m.backend.PendingNonceAt(ctx, m.from)
+	if err != nil {
+		return err
+	}
+	next, err := m.txs.NextNonce(m.from)
+	if err != nil {
+		return err
+	}
+
+	m.nonce = pending
+	if next > pending {
+		m.nonce = next
+	}
+	m.nonceLoaded = true
+	return nil
+}
+
+func (m *TxManager) check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), txRPCTimeout)
+	defer cancel()
+
+	pending, err := m.txs.FindPending(m.from)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// read before the receipts, so that a block arriving in between can't
+	// make one of our own transactions look replaced
+	confirmed, err := m.backend.NonceAt(ctx, m.from, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range pending {
+		m.mtx.Lock()
+		sending := m.sending[record.Nonce]
+		m.mtx.Unlock()
+		if sending {
+			continue
+		}
+
+		if err := m.checkTx(ctx, record, confirmed); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *TxManager) checkTx(ctx context.Context, record *db.ETHTransaction, confirmed uint64) error {
+	for _, hash := range record.Hashes {
+		receipt, err := m.backend.TransactionReceipt(ctx, hash)
+		if err == ethereum.NotFound || (err == nil && receipt == nil) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		record.Hash = hash
+		record.Status = db.ETHTransactionMined
+		var txErr error
+		if receipt.Status == ethtypes.ReceiptStatusFailed {
+			record.Status = db.ETHTransactionReverted
+			txErr = ErrTxReverted
+		}
+		if err := m.txs.Save(record); err != nil {
+			return err
+		}
+
+		csLog.Infow("transaction mined", "txHash", hash.Hex(), "nonce", record.Nonce, "status", string(record.Status))
+		m.resolve(record, receipt, txErr)
+		return nil
+	}
+
+	if confirmed > record.Nonce {
+		record.Status = db.ETHTransactionDropped
+		if err := m.txs.Save(record); err != nil {
+			return err
+		}
+
+		csLog.Warnw("transaction dropped", "txHash", record.Hash.Hex(), "nonce", record.Nonce)
+		m.resolve(record, nil, ErrTxDropped)
+		return nil
+	}
+
+	if time.Since(time.Unix(record.BroadcastAt, 0)) < rebroadcastTimeout {
+		return nil
+	}
+
+	return m.rebroadcast(ctx, record)
+}
+
+// rebroadcast sends a stuck transaction again. It is re-signed with a higher
+// gas price unless we are already paying maxGasPrice.
+func (m *TxManager) rebroadcast(ctx context.Context, record *db.ETHTransaction) error {
+	suggested, err := m.backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return err
+	}
+
+	gasPrice := new(big.Int).Mul(record.GasPrice, big.NewInt(100+feeBumpPercent))
+	gasPrice.Div(gasPrice, big.NewInt(100))
+	if suggested.Cmp(gasPrice) > 0 {
+		gasPrice = suggested
+	}
+	if gasPrice.Cmp(maxGasPrice) > 0 {
+		gasPrice = maxGasPrice
+	}
+	if gasPrice.Cmp(record.GasPrice) < 0 {
+		gasPrice = record.GasPrice
+	}
+
+	record.GasPrice = gasPrice
+	signed, err := m.sign(record)
+	if err != nil {
+		return err
+	}
+	if err := m.txs.Save(record); err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	if handle, ok := m.handles[record.Nonce]; ok {
+		handle.replace(signed)
+	}
+	m.mtx.Unlock()
+
+	err = m.backend.SendTransaction(ctx, signed)
+	if err != nil && !isKnownTx(err) && !isNonceTooLow(err) {
+		// one failed broadcast shouldn't hold up the rest; this one is
+		// retried after another rebroadcastTimeout
+		csLog.Warnw("failed to rebroadcast transaction", "txHash", signed.Hash().Hex(), "nonce", record.Nonce, "err", err.Error())
+		return nil
+	}
+
+	csLog.Infow("rebroadcast transaction", "txHash", signed.Hash().Hex(), "nonce", record.Nonce, "gasPrice", gasPrice.Text(10))
+	return nil
+}
+
+// sign signs record at its current gas price, and records the new hash and
+// broadcast time on it.
+func (m *TxManager) sign(record *db.ETHTransaction) (*ethtypes.Transaction, error) {
+	tx := ethtypes.NewTransaction(record.Nonce, record.To, record.Value, record.GasLimit, record.GasPrice, record.Data)
+	signed, err := m.km.SignTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := signed.Hash()
+	if hash != record.Hash {
+		record.Hashes = append(record.Hashes, hash)
+	}
+	record.Hash = hash
+	record.BroadcastAt = time.Now().Unix()
+	return signed, nil
+}
+
+func (m *TxManager) resolve(record *db.ETHTransaction, receipt *ethtypes.Receipt, err error) {
+	m.mtx.Lock()
+	handle, ok := m.handles[record.Nonce]
+	delete(m.handles, record.Nonce)
+	m.mtx.Unlock()
+
+	if ok {
+		handle.resolve(receipt, err)
+	}
+}
+
+// isKnownTx reports whether a broadcast failed only because the node already
+// has the transaction.
+func isKnownTx(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "known transaction") || strings.Contains(msg, "already known")
+}
+
+func isNonceTooLow(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "nonce too low")
+}
@@ -0,0 +1,257 @@
+package ethclient
+
+import (
+	"testing"
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/internal/wallet"
+	"github.com/stretchr/testify/assert"
+)
+
+type simTxBackend struct {
+	pendingNonce   uint64
+	confirmedNonce uint64
+	gasPrice       *big.Int
+	sendErr        error
+	sent           []*ethtypes.Transaction
+	receipts       map[common.Hash]*ethtypes.Receipt
+}
+
+func newSimTxBackend() *simTxBackend {
+	return &simTxBackend{
+		gasPrice: big.NewInt(10),
+		receipts: make(map[common.Hash]*ethtypes.Receipt),
+	}
+}
+
+func (s *simTxBackend) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	return s.confirmedNonce, nil
+}
+
+func (s *simTxBackend) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return s.pendingNonce, nil
+}
+
+func (s *simTxBackend) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return s.gasPrice, nil
+}
+
+func (s *simTxBackend) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	return 21000, nil
+}
+
+func (s *simTxBackend) SendTransaction(ctx context.Context, tx *ethtypes.Transaction) error {
+	if s.sendErr != nil {
+		return s.sendErr
+	}
+	s.sent = append(s.sent, tx)
+	return nil
+}
+
+func (s *simTxBackend) TransactionReceipt(ctx context.Context, txHash common.Hash) (*ethtypes.Receipt, error) {
+	receipt, ok := s.receipts[txHash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+func (s *simTxBackend) mine(tx *ethtypes.Transaction, status uint64) {
+	s.receipts[tx.Hash()] = &ethtypes.Receipt{
+		Status: status,
+		TxHash: tx.Hash(),
+	}
+	if tx.Nonce() >= s.confirmedNonce {
+		s.confirmedNonce = tx.Nonce() + 1
+	}
+}
+
+type memTransactions struct {
+	txs map[uint64]*db.ETHTransaction
+}
+
+func newMemTransactions() *memTransactions {
+	return &memTransactions{
+		txs: make(map[uint64]*db.ETHTransaction),
+	}
+}
+
+func (m *memTransactions) Save(tx *db.ETHTransaction) error {
+	m.txs[tx.Nonce] = tx
+	return nil
+}
+
+func (m *memTransactions) Delete(from common.Address, nonce uint64) error {
+	delete(m.txs, nonce)
+	return nil
+}
+
+func (m *memTransactions) FindPending(from common.Address) ([]*db.ETHTransaction, error) {
+	var out []*db.ETHTransaction
+	for _, tx := range m.txs {
+		if tx.Status == db.ETHTransactionPending {
+			out = append(out, tx)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Nonce < out[j].Nonce
+	})
+	return out, nil
+}
+
+func (m *memTransactions) FindByHash(from common.Address, hash common.Hash) (*db.ETHTransaction, error) {
+	for _, tx := range m.txs {
+		for _, h := range tx.Hashes {
+			if h == hash {
+				return tx, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (m *memTransactions) NextNonce(from common.Address) (uint64, error) {
+	var next uint64
+	for nonce := range m.txs {
+		if nonce+1 > next {
+			next = nonce + 1
+		}
+	}
+	return next, nil
+}
+
+func testTxManager(t *testing.T) (*TxManager, *simTxBackend, *memTransactions) {
+	km, err := wallet.NewKeyManager("c87509a1c067bbde78beb793e6fa76530b6382a4c0241e5e4a9ec0a0f44dc0d3", big.NewInt(1337))
+	assert.Nil(t, err)
+	backend := newSimTxBackend()
+	txs := newMemTransactions()
+	return NewTxManager(km, backend, txs), backend, txs
+}
+
+var testRecipient = common.HexToAddress("0x345ca3e014aaf5dca488057592ee47305d9b3e10")
+
+func TestTxManager_AssignsNonces(t *testing.T) {
+	m, backend, txs := testTxManager(t)
+	backend.pendingNonce = 3
+
+	a, err := m.Send(testRecipient, big.NewInt(0), nil, 0)
+	assert.Nil(t, err)
+	b, err := m.Send(testRecipient, big.NewInt(0), nil, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), a.Nonce())
+	assert.Equal(t, uint64(4), b.Nonce())
+	assert.Len(t, backend.sent, 2)
+	assert.Equal(t, a.Hash(), txs.txs[3].Hash)
+
+	// a node that hasn't seen our pending transactions doesn't cause reuse
+	restarted := NewTxManager(m.km, backend, txs)
+	c, err := restarted.Send(testRecipient, big.NewInt(0), nil, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), c.Nonce())
+}
+
+func TestTxManager_ReleasesRejectedNonce(t *testing.T) {
+	m, backend, txs := testTxManager(t)
+	backend.sendErr = errors.New("insufficient funds for gas * price + value")
+
+	_, err := m.Send(testRecipient, big.NewInt(0), nil, 0)
+	assert.Equal(t, backend.sendErr, err)
+	assert.Empty(t, txs.txs)
+
+	backend.sendErr = nil
+	tx, err := m.Send(testRecipient, big.NewInt(0), nil, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), tx.Nonce())
+}
+
+func TestTxManager_FillsRejectedNonceGap(t *testing.T) {
+	m, backend, txs := testTxManager(t)
+	ctx := context.Background()
+
+	first, err := m.reserveNonce(ctx)
+	assert.Nil(t, err)
+	second, err := m.reserveNonce(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), second)
+
+	// the first send is rejected after the second has taken its nonce
+	rejected := &db.ETHTransaction{
+		From:     m.from,
+		Nonce:    first,
+		To:       testRecipient,
+		Value:    big.NewInt(0),
+		GasLimit: 21000,
+		GasPrice: big.NewInt(10),
+		Status:   db.ETHTransactionPending,
+	}
+	m.releaseNonce(rejected, errors.New("insufficient funds for gas * price + value"))
+	assert.Equal(t, uint64(2), m.nonce)
+	assert.Equal(t, m.from, txs.txs[first].To)
+
+	assert.Nil(t, m.check())
+	assert.Len(t, backend.sent, 1)
+	assert.Equal(t, first, backend.sent[0].Nonce())
+	assert.Equal(t, m.from, *backend.sent[0].To())
+}
+
+func TestTxManager_BumpsStuckTransaction(t *testing.T) {
+	m, backend, txs := testTxManager(t)
+
+	handle, err := m.Send(testRecipient, big.NewInt(0), nil, 0)
+	assert.Nil(t, err)
+	original := backend.sent[0]
+
+	assert.Nil(t, m.check())
+	assert.Len(t, backend.sent, 1)
+
+	txs.txs[0].BroadcastAt = time.Now().Add(-rebroadcastTimeout).Unix()
+	assert.Nil(t, m.check())
+	assert.Len(t, backend.sent, 2)
+	bumped := backend.sent[1]
+	assert.Equal(t, uint64(0), bumped.Nonce())
+	assert.Equal(t, big.NewInt(12), bumped.GasPrice())
+	assert.Equal(t, bumped.Hash(), handle.Hash())
+	assert.Equal(t, []common.Hash{original.Hash(), bumped.Hash()}, txs.txs[0].Hashes)
+
+	// the version that was replaced can still be the one that gets mined
+	backend.mine(original, ethtypes.ReceiptStatusSuccessful)
+	assert.Nil(t, m.check())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	receipt, err := handle.Wait(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, original.Hash(), receipt.TxHash)
+	assert.Equal(t, db.ETHTransactionMined, txs.txs[0].Status)
+	assert.Equal(t, original.Hash(), txs.txs[0].Hash)
+}
+
+func TestTxManager_RevertedAndDropped(t *testing.T) {
+	m, backend, txs := testTxManager(t)
+
+	reverted, err := m.Send(testRecipient, big.NewInt(0), nil, 0)
+	assert.Nil(t, err)
+	dropped, err := m.Send(testRecipient, big.NewInt(0), nil, 0)
+	assert.Nil(t, err)
+
+	backend.mine(backend.sent[0], ethtypes.ReceiptStatusFailed)
+	backend.confirmedNonce = 2
+	assert.Nil(t, m.check())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = reverted.Wait(ctx)
+	assert.Equal(t, ErrTxReverted, err)
+	_, err = dropped.Wait(ctx)
+	assert.Equal(t, ErrTxDropped, err)
+	assert.Equal(t, db.ETHTransactionReverted, txs.txs[0].Status)
+	assert.Equal(t, db.ETHTransactionDropped, txs.txs[1].Status)
+	assert.Empty(t, m.handles)
+}
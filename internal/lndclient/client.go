@@ -15,6 +15,7 @@ import (
 	"github.com/kyokan/drawbridge/pkg/crypto"
 	"github.com/kyokan/drawbridge/internal/conv"
 	"github.com/go-errors/errors"
+	"encoding/hex"
 )
 
 type Client struct {
@@ -190,3 +191,32 @@ func (c *Client) PayInvoice(paymentRequest string) (*lnrpc.SendResponse, error)
 	}
 	return c.client.SendPaymentSync(ctx, req)
 }
+
+func (c *Client) DecodePayReq(paymentRequest string) (*lnrpc.PayReq, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, time.Second*10)
+	defer cancel()
+	req := &lnrpc.PayReqString{
+		PayReq: paymentRequest,
+	}
+	return c.client.DecodePayReq(ctx, req)
+}
+
+// FindPayment returns our completed payment of paymentHash, or nil if we have
+// not paid it.
+func (c *Client) FindPayment(paymentHash [32]byte) (*lnrpc.Payment, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, time.Second*10)
+	defer cancel()
+	res, err := c.client.ListPayments(ctx, &lnrpc.ListPaymentsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	strHash := hex.EncodeToString(paymentHash[:])
+	for _, payment := range res.Payments {
+		if payment.PaymentHash == strHash {
+			return payment, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,105 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"errors"
+)
+
+const (
+	// ScoreMinor is for violations an honest but buggy peer could commit.
+	ScoreMinor uint32 = 10
+	// ScoreSevere is for violations only a malicious peer commits.
+	ScoreSevere uint32 = 50
+)
+
+const banThreshold = 100
+const banDuration = time.Hour * 24
+
+// scores lose a point every scoreDecayInterval, so that occasional mistakes
+// from a long-lived peer never add up to a ban.
+const scoreDecayInterval = time.Minute
+
+// BanManager keeps a misbehavior score for every peer and bans peers whose
+// score reaches banThreshold. Scores live in memory; bans are persisted.
+type BanManager struct {
+	bans   db.Bans
+	scores map[string]*banScore
+	mtx    sync.Mutex
+}
+
+type banScore struct {
+	points  uint32
+	updated time.Time
+}
+
+func NewBanManager(bans db.Bans) *BanManager {
+	return &BanManager{
+		bans:   bans,
+		scores: make(map[string]*banScore),
+	}
+}
+
+// Misbehaving adds points to the peer's score. Once the score reaches
+// banThreshold the peer is banned for banDuration and disconnected.
+func (b *BanManager) Misbehaving(peer *Peer, points uint32, reason string) {
+	score, banned := b.addScore(peer.Identity, points, time.Now())
+	pLog.Warnw("peer misbehaved", "peer", peer, "points", points, "score", score, "reason", reason)
+	if !banned {
+		return
+	}
+
+	err := b.bans.Save(&db.Ban{
+		IdentityKey: peer.Identity,
+		Reason:      reason,
+		ExpiresAt:   time.Now().Add(banDuration).Unix(),
+	})
+	if err != nil {
+		pLog.Errorw("failed to persist ban", "peer", peer, "err", err.Error())
+	}
+
+	peer.Disconnect(errors.New("banned: " + reason))
+}
+
+// IsBanned reports whether the peer has a ban that has not yet expired.
+func (b *BanManager) IsBanned(pub *crypto.PublicKey) (bool, error) {
+	ban, err := b.bans.FindActive(pub)
+	if err != nil {
+		return false, err
+	}
+
+	return ban != nil, nil
+}
+
+// addScore returns the peer's decayed score plus points, and whether that
+// crosses banThreshold. Crossing it resets the score.
+func (b *BanManager) addScore(pub *crypto.PublicKey, points uint32, now time.Time) (uint32, bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	key := pub.CompressedHex()
+	score, exists := b.scores[key]
+	if !exists {
+		score = &banScore{updated: now}
+		b.scores[key] = score
+	}
+
+	decay := uint32(now.Sub(score.updated) / scoreDecayInterval)
+	if decay > score.points {
+		score.points = 0
+	} else {
+		score.points -= decay
+	}
+	score.points += points
+	score.updated = now
+
+	total := score.points
+	if total < banThreshold {
+		return total, false
+	}
+
+	delete(b.scores, key)
+	return total, true
+}
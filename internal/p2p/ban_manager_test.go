@@ -0,0 +1,46 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+)
+
+func TestBanManager_AddScore(t *testing.T) {
+	b := NewBanManager(nil)
+	pub, err := crypto.RandomPublicKey()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	now := time.Now()
+
+	score, banned := b.addScore(pub, ScoreSevere, now)
+	assert.Equal(t, ScoreSevere, score)
+	assert.False(t, banned)
+
+	score, banned = b.addScore(pub, ScoreSevere, now.Add(scoreDecayInterval*10))
+	assert.Equal(t, ScoreSevere*2-10, score)
+	assert.False(t, banned)
+
+	score, banned = b.addScore(pub, ScoreSevere, now.Add(scoreDecayInterval*10))
+	assert.Equal(t, ScoreSevere*3-10, score)
+	assert.True(t, banned)
+
+	score, banned = b.addScore(pub, ScoreMinor, now.Add(scoreDecayInterval*10))
+	assert.Equal(t, ScoreMinor, score)
+	assert.False(t, banned)
+}
+
+func TestBanManager_AddScoreDecaysToZero(t *testing.T) {
+	b := NewBanManager(nil)
+	pub, err := crypto.RandomPublicKey()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	now := time.Now()
+
+	b.addScore(pub, ScoreMinor, now)
+	score, _ := b.addScore(pub, ScoreMinor, now.Add(time.Hour))
+	assert.Equal(t, ScoreMinor, score)
+}
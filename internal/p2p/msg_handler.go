@@ -1,8 +1,60 @@
 package p2p
 
-import "github.com/lightningnetwork/lnd/lnwire"
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+	"context"
+)
 
+// MsgHandler processes messages routed to it by the Reactor. Replies returned
+// from Accept are sent in order before the peer's next message is handled.
+// Handlers that need to reply later, or that block for long, should use
+// Envelope.Go rather than stall the peer's dispatcher.
 type MsgHandler interface {
 	CanAccept(msg lnwire.Message) bool
-	Accept(enveloper *Envelope) (lnwire.Message, error)
-}
\ No newline at end of file
+	Accept(envelope *Envelope) ([]lnwire.Message, error)
+}
+
+// Sender delivers messages to a single peer.
+type Sender interface {
+	Send(ctx context.Context, msg lnwire.Message) error
+}
+
+// Reply adapts a handler returning at most one message to the MsgHandler
+// contract.
+func Reply(msg lnwire.Message, err error) ([]lnwire.Message, error) {
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, nil
+	}
+
+	return []lnwire.Message{msg}, nil
+}
+
+type warning struct {
+	error
+}
+
+// Warn marks a handler error as non-fatal, so the reactor answers it with a
+// warning rather than an error that fails the channel or swap.
+func Warn(err error) error {
+	return &warning{err}
+}
+
+type misbehavior struct {
+	error
+	points uint32
+}
+
+// Misbehaving marks a handler error as a protocol violation. The reactor adds
+// points to the peer's ban score before replying with the error.
+func Misbehaving(points uint32, err error) error {
+	return &misbehavior{err, points}
+}
+
+// Go runs fn off the reactor. ctx is cancelled once the envelope's peer
+// disconnects, and follow-up messages are sent through sender.
+func (e *Envelope) Go(fn func(ctx context.Context, sender Sender)) {
+	go fn(e.Peer.Context(), e.Peer)
+}
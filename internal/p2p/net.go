@@ -12,34 +12,50 @@ func ResolveAddrs(addrs []string) ([]*lnwire.NetAddress, error) {
 	var out []*lnwire.NetAddress
 
 	for _, a := range addrs {
-		splits := strings.Split(a, "|")
-
-		if len(splits) != 2 {
-			return nil, errors.New("invalid peer: " + a)
-		}
-
-		host := splits[0]
-		pub := splits[1]
-
-		resolved, err := net.ResolveTCPAddr("tcp", host)
+		host, identityKey, err := ParsePeerAddr(a)
 
 		if err != nil {
 			return nil, err
 		}
 
-		identityKey, err := crypto.PublicFromCompressedHex(pub)
+		addr, err := ResolveAddr(host, identityKey)
 
 		if err != nil {
 			return nil, err
 		}
 
-		addr := &lnwire.NetAddress{
-			IdentityKey: identityKey.BTCEC(),
-			Address: resolved,
-		}
-
 		out = append(out, addr)
 	}
 
 	return out, nil
-}
\ No newline at end of file
+}
+
+// ParsePeerAddr splits a host:port|pubkey peer string.
+func ParsePeerAddr(a string) (string, *crypto.PublicKey, error) {
+	splits := strings.Split(a, "|")
+
+	if len(splits) != 2 {
+		return "", nil, errors.New("invalid peer: " + a)
+	}
+
+	identityKey, err := crypto.PublicFromCompressedHex(splits[1])
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return splits[0], identityKey, nil
+}
+
+func ResolveAddr(host string, identityKey *crypto.PublicKey) (*lnwire.NetAddress, error) {
+	resolved, err := net.ResolveTCPAddr("tcp", host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &lnwire.NetAddress{
+		IdentityKey: identityKey.BTCEC(),
+		Address:     resolved,
+	}, nil
+}
@@ -13,10 +13,15 @@ import (
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/kyokan/drawbridge/pkg/crypto"
+	"github.com/kyokan/drawbridge/internal/db"
+	"context"
 )
 
 var nLog *zap.SugaredLogger
 
+const minReconnectBackoff = time.Second
+const maxReconnectBackoff = time.Minute * 5
+
 func init() {
 	nLog = logger.Logger.Named("node")
 }
@@ -30,11 +35,20 @@ type Node struct {
 	port           string
 	lndIdentity    *crypto.PublicKey
 	lndHost        string
+	peers          db.Peers
+	bans           *BanManager
+	peerConfig     *PeerConfig
+	identityKey    *btcec.PrivateKey
+	reconnecting   map[string]context.CancelFunc
+	mtx            sync.Mutex
 }
 
 type NodeConfig struct {
 	Reactor        *Reactor
 	PeerBook       *PeerBook
+	Peers          db.Peers
+	Bans           *BanManager
+	PeerConfig     *PeerConfig
 	P2PAddr        string
 	P2PPort        string
 	BootstrapPeers []string
@@ -43,7 +57,12 @@ type NodeConfig struct {
 }
 
 func NewNode(config *NodeConfig) (*Node, error) {
-	return &Node{
+	peerConfig := config.PeerConfig
+	if peerConfig == nil {
+		peerConfig = DefaultPeerConfig()
+	}
+
+	n := &Node{
 		reactor:        config.Reactor,
 		peerBook:       config.PeerBook,
 		bootstrapPeers: config.BootstrapPeers,
@@ -51,7 +70,13 @@ func NewNode(config *NodeConfig) (*Node, error) {
 		port:           config.P2PPort,
 		lndIdentity:    config.LNDIdentity,
 		lndHost:        config.LNDHost,
-	}, nil
+		peers:          config.Peers,
+		bans:           config.Bans,
+		peerConfig:     peerConfig,
+		reconnecting:   make(map[string]context.CancelFunc),
+	}
+	config.Reactor.AddDisconnectListener(n)
+	return n, nil
 }
 
 func (n *Node) Start(identityKey *btcec.PrivateKey) error {
@@ -73,6 +98,10 @@ func (n *Node) Start(identityKey *btcec.PrivateKey) error {
 		nLog.Panicw("failed to listen to TCP address", "err", err, "addr", listenAddr.String())
 	}
 
+	n.mtx.Lock()
+	n.identityKey = identityKey
+	n.mtx.Unlock()
+
 	cmgr, err := connmgr.New(&connmgr.Config{
 		Listeners: []net.Listener{
 			listener,
@@ -80,17 +109,8 @@ func (n *Node) Start(identityKey *btcec.PrivateKey) error {
 		OnAccept:       n.onAccept,
 		RetryDuration:  time.Second * 5,
 		TargetOutbound: 100,
-		Dial: func(a net.Addr) (net.Conn, error) {
-			if a == nil || a == (*lnwire.NetAddress)(nil) {
-				return nil, errors.New("addr is nil")
-			}
-
-			return brontide.Dial(identityKey, a.(*lnwire.NetAddress), func(network string, address string) (net.Conn, error) {
-				return net.Dial(network, address)
-			})
-		},
-		OnConnection:    n.onConnection,
-		OnDisconnection: n.onDisconnection,
+		Dial:           n.dial,
+		OnConnection:   n.onConnection,
 	})
 
 	if err != nil {
@@ -101,94 +121,239 @@ func (n *Node) Start(identityKey *btcec.PrivateKey) error {
 
 	cmgr.Start()
 
-	if len(n.bootstrapPeers) > 0 {
-		addrs, err := ResolveAddrs(n.bootstrapPeers)
+	for _, a := range n.bootstrapPeers {
+		host, pub, err := ParsePeerAddr(a)
 
 		if err != nil {
-			nLog.Errorw("failed to resolve bootstrap peers", "err", err, "bootstrapPeers", n.bootstrapPeers)
+			nLog.Errorw("failed to parse bootstrap peer", "err", err, "bootstrapPeer", a)
+			continue
+		}
+
+		if err := n.peers.Save(&db.Peer{IdentityKey: pub, Addresses: []string{host}}); err != nil {
+			return err
 		}
+	}
+
+	stored, err := n.peers.FindAll()
+
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range stored {
+		n.startReconnect(peer.IdentityKey, peer.Addresses)
+	}
+
+	return nil
+}
+
+// AddPersistentPeer stores a peer so that it is reconnected to on every
+// startup, and starts connecting to it now.
+func (n *Node) AddPersistentPeer(pub *crypto.PublicKey, addrs []string) error {
+	if len(addrs) == 0 {
+		return errors.New("at least one address is required")
+	}
+
+	for _, a := range addrs {
+		if _, err := net.ResolveTCPAddr("tcp", a); err != nil {
+			return err
+		}
+	}
 
-		go n.bootstrap(addrs)
+	if err := n.peers.Save(&db.Peer{IdentityKey: pub, Addresses: addrs}); err != nil {
+		return err
 	}
 
+	n.startReconnect(pub, addrs)
 	return nil
 }
 
+// RemovePersistentPeer forgets a stored peer. An open connection to it is
+// left alone.
+func (n *Node) RemovePersistentPeer(pub *crypto.PublicKey) error {
+	n.mtx.Lock()
+	if cancel, exists := n.reconnecting[pub.CompressedHex()]; exists {
+		cancel()
+		delete(n.reconnecting, pub.CompressedHex())
+	}
+	n.mtx.Unlock()
+
+	return n.peers.Delete(pub)
+}
+
+func (n *Node) startReconnect(pub *crypto.PublicKey, addrs []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	n.mtx.Lock()
+	// peers added before Start are picked up from the store once it runs.
+	if n.identityKey == nil {
+		n.mtx.Unlock()
+		cancel()
+		return
+	}
+	if existing, exists := n.reconnecting[pub.CompressedHex()]; exists {
+		existing()
+	}
+	n.reconnecting[pub.CompressedHex()] = cancel
+	n.mtx.Unlock()
+
+	go n.reconnect(ctx, pub, addrs)
+}
+
+// reconnect keeps a connection to a stored peer open for as long as ctx is
+// live. It dials each of the peer's addresses in turn, doubling the wait
+// between rounds up to maxReconnectBackoff, and starts over whenever an
+// established connection goes away. A banned peer is not dialled until its
+// ban expires.
+func (n *Node) reconnect(ctx context.Context, pub *crypto.PublicKey, addrs []string) {
+	backoff := minReconnectBackoff
+
+	for {
+		if peer := n.peerBook.FindPeer(pub); peer != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-peer.Context().Done():
+			}
+
+			n.peerBook.RemovePeerInstance(peer)
+			backoff = minReconnectBackoff
+			continue
+		}
+
+		if n.isBanned(pub) {
+			nLog.Infow("not reconnecting to banned peer", "peer", pub.CompressedHex(), "retryIn", backoff.String())
+		} else {
+			n.dialAny(pub, addrs, backoff)
+		}
+
+		if n.peerBook.FindPeer(pub) != nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// dialAny connects to the first of addrs that accepts.
+func (n *Node) dialAny(pub *crypto.PublicKey, addrs []string, backoff time.Duration) {
+	for _, a := range addrs {
+		addr, err := ResolveAddr(a, pub)
+
+		if err != nil {
+			nLog.Warnw("failed to resolve peer address", "peer", pub.CompressedHex(), "addr", a, "err", err)
+			continue
+		}
+
+		conn, err := n.dial(addr)
+
+		if err != nil {
+			nLog.Infow("failed to connect to peer", "peer", pub.CompressedHex(), "addr", a, "err", err,
+				"retryIn", backoff.String())
+			continue
+		}
+
+		n.addPeer(conn, true)
+		return
+	}
+}
+
+func (n *Node) dial(a net.Addr) (net.Conn, error) {
+	if a == nil || a == (*lnwire.NetAddress)(nil) {
+		return nil, errors.New("addr is nil")
+	}
+
+	return brontide.Dial(n.identityKey, a.(*lnwire.NetAddress), func(network string, address string) (net.Conn, error) {
+		return net.DialTimeout(network, address, time.Second*10)
+	})
+}
+
 func (n *Node) FindPeer(pub *crypto.PublicKey) *Peer {
 	return n.peerBook.FindPeer(pub)
 }
 
-func (n *Node) SendPeer(pub *crypto.PublicKey, msg lnwire.Message) error {
+func (n *Node) SendPeer(ctx context.Context, pub *crypto.PublicKey, msg lnwire.Message) error {
 	peer := n.peerBook.FindPeer(pub)
 
 	if peer == nil {
 		return errors.New("no peer with id " + pub.CompressedHex() + " found")
 	}
 
-	return peer.Send(msg)
+	return peer.Send(ctx, msg)
 }
 
 func (n *Node) onConnection(req *connmgr.ConnReq, conn net.Conn) {
-	noiseConn := conn.(*brontide.Conn)
-	peer, err := NewPeer(n.reactor, noiseConn, true)
+	n.addPeer(conn, true)
+}
+
+func (n *Node) onAccept(conn net.Conn) {
+	pub, err := crypto.PublicFromBTCEC(conn.(*brontide.Conn).RemotePub())
 
 	if err != nil {
-		nLog.Errorw("failed to create peer", "err", err.Error())
+		nLog.Errorw("failed to wrap public key", "err", err.Error())
+		conn.Close()
 		return
 	}
 
-	nLog.Infow("established outbound peer connection", "conn", peer.Identity.CompressedHex())
+	if n.isBanned(pub) {
+		nLog.Infow("rejecting banned peer", "conn", pub.CompressedHex())
+		conn.Close()
+		return
+	}
 
-	if n.peerBook.AddPeer(peer) {
-		peer.Start(n.lndIdentity, n.lndHost)
+	n.addPeer(conn, false)
+}
+
+// isBanned reports whether pub is banned. Without a BanManager nobody is.
+func (n *Node) isBanned(pub *crypto.PublicKey) bool {
+	if n.bans == nil {
+		return false
+	}
+
+	banned, err := n.bans.IsBanned(pub)
+
+	if err != nil {
+		nLog.Errorw("failed to look up ban", "conn", pub.CompressedHex(), "err", err.Error())
 	}
+
+	return banned
 }
 
-func (n *Node) onAccept(conn net.Conn) {
+func (n *Node) addPeer(conn net.Conn, selfOriginated bool) {
 	noiseConn := conn.(*brontide.Conn)
-	peer, err := NewPeer(n.reactor, noiseConn, false)
+	peer, err := NewPeer(n.reactor, noiseConn, selfOriginated, n.peerConfig)
 
 	if err != nil {
 		nLog.Errorw("failed to create peer", "err", err.Error())
 		return
 	}
 
-	nLog.Infow("established inbound peer connection", "conn", peer.Identity.CompressedHex())
+	if selfOriginated {
+		nLog.Infow("established outbound peer connection", "conn", peer.Identity.CompressedHex())
+	} else {
+		nLog.Infow("established inbound peer connection", "conn", peer.Identity.CompressedHex())
+	}
 
 	if n.peerBook.AddPeer(peer) {
 		peer.Start(n.lndIdentity, n.lndHost)
+	} else {
+		noiseConn.Close()
 	}
 }
 
-func (n *Node) onDisconnection(req *connmgr.ConnReq) {
-	addr := req.Addr.(*lnwire.NetAddress)
-
-	pub, err := crypto.PublicFromBTCEC(addr.IdentityKey)
-
-	if err != nil {
-		nLog.Errorw("failed to wrap public key", "err", err.Error())
-		return
-	}
-
-	nLog.Infow("peer disconnected", "conn", pub.CompressedHex())
-	n.peerBook.RemovePeer(pub)
-}
-
-func (n *Node) bootstrap(addrs []*lnwire.NetAddress) {
-	var wg sync.WaitGroup
-
-	for _, addr := range addrs {
-		wg.Add(1)
-		go (func() {
-			req := &connmgr.ConnReq{
-				Addr:      addr,
-				Permanent: true,
-			}
-
-			n.connMgr.Connect(req)
-			wg.Done()
-		})()
+// OnDisconnect forgets a peer once it has been torn down, whichever side
+// opened the connection.
+func (n *Node) OnDisconnect(peer *Peer, reason error) {
+	if n.peerBook.RemovePeerInstance(peer) {
+		nLog.Infow("peer disconnected", "conn", peer.Identity.CompressedHex(), "reason", reason)
 	}
-
-	wg.Wait()
 }
@@ -12,12 +12,23 @@ import (
 	"github.com/lightningnetwork/lnd/brontide"
 	"github.com/kyokan/drawbridge/pkg/crypto"
 	"github.com/kyokan/drawbridge/pkg/wire"
+	"context"
+	"errors"
+	"net"
 )
 
 var pLog *zap.SugaredLogger
 
+var ErrPeerDisconnected = errors.New("peer disconnected")
+
+var ErrSendQueueFull = errors.New("send queue full")
+
+var ErrDuplicateInit = errors.New("init already received")
+
 const idleTimeout = time.Minute * 5
 const pingInterval = time.Minute * 1
+const handshakeTimeout = time.Second * 30
+const pongTimeout = time.Second * 30
 
 func init() {
 	pLog = logger.Logger.Named("peer")
@@ -25,152 +36,292 @@ func init() {
 
 type Peer struct {
 	reactor        *Reactor
+	reactorID      uint64
 	conn           *brontide.Conn
 	selfOriginated bool
 	writeBuf       *[65535]byte
 	incomingQueue  chan *Envelope
 	outgoingQueue  chan *Envelope
-	errChan        chan error
+	pongChan       chan struct{}
+	config         *PeerConfig
+	limiters       map[lnwire.MessageType]*tokenBucket
 	disconnected   uint32
+	initialized    uint32
+	handshaked     uint32
 	wg             *sync.WaitGroup
+	ctx            context.Context
+	cancel         context.CancelFunc
 
-	Identity *crypto.PublicKey
-	LNDIdentity *crypto.PublicKey
+	Identity       *crypto.PublicKey
+	LNDIdentity    *crypto.PublicKey
+	GlobalFeatures *lnwire.FeatureVector
+	LocalFeatures  *lnwire.FeatureVector
 }
 
-func NewPeer(reactor *Reactor, conn *brontide.Conn, selfOriginated bool) (*Peer, error) {
+func NewPeer(reactor *Reactor, conn *brontide.Conn, selfOriginated bool, config *PeerConfig) (*Peer, error) {
 	identity, err := crypto.PublicFromBTCEC(conn.RemotePub())
 
 	if err != nil {
 		return nil, err
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Peer{
 		reactor:        reactor,
 		conn:           conn,
 		selfOriginated: selfOriginated,
 		writeBuf:       new([65535]byte),
-		incomingQueue:  make(chan *Envelope),
-		outgoingQueue:  make(chan *Envelope),
-		errChan:        make(chan error),
+		incomingQueue:  make(chan *Envelope, config.IncomingQueueSize),
+		outgoingQueue:  make(chan *Envelope, config.OutgoingQueueSize),
+		pongChan:       make(chan struct{}, 1),
+		config:         config,
+		limiters:       make(map[lnwire.MessageType]*tokenBucket),
 		disconnected:   0,
 		wg:             new(sync.WaitGroup),
+		ctx:            ctx,
+		cancel:         cancel,
 		Identity:       identity,
 	}, nil
 }
 
 func (p *Peer) Start(lndIdent *crypto.PublicKey, lndHost string) {
-	p.reactor.AddEnvelopeChan(p.incomingQueue, p.outgoingQueue)
+	p.reactorID = p.reactor.AddEnvelopeChan(p.incomingQueue, p.outgoingQueue)
 
+	p.wg.Add(3)
 	go p.readHandler()
 	go p.writeHandler()
 	go p.pingHandler()
 
-	msg := wire.NewInit(lndIdent, lndHost)
-	p.outgoingQueue <- NewEnvelope(p, msg)
+	time.AfterFunc(handshakeTimeout, func() {
+		if !p.HandshakeComplete() {
+			p.Disconnect(errors.New("handshake timed out"))
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(p.ctx, handshakeTimeout)
+	defer cancel()
+	if err := p.Send(ctx, wire.NewInit(lndIdent, lndHost)); err != nil {
+		p.Disconnect(err)
+	}
 }
 
-func (p *Peer) Stop() (error) {
-	atomic.StoreUint32(&p.disconnected, 1)
+// Stop disconnects the peer and waits for its goroutines to exit. It must not
+// be called from a message handler; use Disconnect there instead.
+func (p *Peer) Stop() error {
+	p.Disconnect(errors.New("peer stopped"))
 	p.wg.Wait()
-	close(p.incomingQueue)
-	close(p.outgoingQueue)
-	return p.conn.Close()
+	return nil
 }
 
-func (p *Peer) Send(msg lnwire.Message) error {
-	p.outgoingQueue <- NewEnvelope(p, msg)
+// Disconnect tears the peer down. Every fatal error ends up here, so it is
+// safe to call more than once and from any goroutine. Only the first call
+// has an effect; the reactor's disconnect listeners are notified with its
+// reason once the peer's goroutines have exited.
+func (p *Peer) Disconnect(reason error) {
+	if !atomic.CompareAndSwapUint32(&p.disconnected, 0, 1) {
+		return
+	}
+
+	pLog.Infow("disconnecting peer", "peer", p, "reason", reason)
+	p.cancel()
+	p.reactor.RemoveEnvelopeChan(p.reactorID)
+	if err := p.conn.Close(); err != nil {
+		pLog.Warnw("failed to close peer connection", "peer", p, "err", err)
+	}
+
+	go func() {
+		p.wg.Wait()
+		p.reactor.notifyDisconnect(p, reason)
+	}()
+}
+
+// ReceiveInit records what the peer told us in its init. It may only be called
+// once per connection. The fields are written before CompleteHandshake, so
+// anything that waits for the handshake can read them without locking.
+func (p *Peer) ReceiveInit(lndIdentity *crypto.PublicKey, globalFeatures *lnwire.FeatureVector, localFeatures *lnwire.FeatureVector) error {
+	if !atomic.CompareAndSwapUint32(&p.initialized, 0, 1) {
+		return ErrDuplicateInit
+	}
+
+	p.LNDIdentity = lndIdentity
+	p.GlobalFeatures = globalFeatures
+	p.LocalFeatures = localFeatures
 	return nil
 }
 
-func (p *Peer) readHandler() {
-	p.wg.Add(1)
+// CompleteHandshake marks the peer's init as processed. Until then the reactor
+// rejects every other message from it. The reactor's connect listeners are
+// notified the first time it is called.
+func (p *Peer) CompleteHandshake() {
+	if atomic.CompareAndSwapUint32(&p.handshaked, 0, 1) && p.reactor != nil {
+		p.reactor.notifyConnect(p)
+	}
+}
 
-	idleTimer := time.AfterFunc(idleTimeout, func() {
-		pLog.Errorf("peer timed out", "peer", p)
-	})
+func (p *Peer) HandshakeComplete() bool {
+	return atomic.LoadUint32(&p.handshaked) == 1
+}
 
-	for {
-		if atomic.LoadUint32(&p.disconnected) == 1 {
-			p.wg.Done()
-			return
-		}
+// HasFeature reports whether the peer advertised either bit of the pair that
+// bit belongs to. It is always false before the handshake completes.
+func (p *Peer) HasFeature(bit lnwire.FeatureBit) bool {
+	if !p.HandshakeComplete() {
+		return false
+	}
+
+	return p.GlobalFeatures.HasFeature(bit) || p.LocalFeatures.HasFeature(bit)
+}
+
+// Context is cancelled when the peer disconnects.
+func (p *Peer) Context() context.Context {
+	return p.ctx
+}
+
+// Send queues msg for the peer. If the outgoing queue is full it waits until
+// ctx is done and then gives up with ErrSendQueueFull.
+func (p *Peer) Send(ctx context.Context, msg lnwire.Message) error {
+	envelope := NewEnvelope(p, msg)
+
+	select {
+	case p.outgoingQueue <- envelope:
+		return nil
+	case <-p.ctx.Done():
+		return ErrPeerDisconnected
+	default:
+	}
 
-		idleTimer.Stop()
-		nextMessage, err := p.readMessage()
+	select {
+	case p.outgoingQueue <- envelope:
+		return nil
+	case <-p.ctx.Done():
+		return ErrPeerDisconnected
+	case <-ctx.Done():
+		return ErrSendQueueFull
+	}
+}
+
+// allow applies the rate limit for msgType. It is only called from
+// readHandler, so the limiters need no lock.
+func (p *Peer) allow(msgType lnwire.MessageType) bool {
+	limiter, exists := p.limiters[msgType]
+	if !exists {
+		limiter = newTokenBucket(p.config.rateLimit(msgType), time.Now())
+		p.limiters[msgType] = limiter
+	}
+
+	return limiter.allow(time.Now())
+}
+
+func (p *Peer) readHandler() {
+	defer p.wg.Done()
+
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		rawMsg, err := p.conn.ReadNextMessage()
 
 		if err != nil {
 			if err == io.EOF {
-				pLog.Infow("remote end hung up", "peer", p, "err", err)
-				p.Stop()
+				p.Disconnect(errors.New("remote end hung up"))
+			} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				p.Disconnect(errors.New("peer timed out"))
 			} else {
-				pLog.Infow("failed to read message", "peer", p, "err", err)
+				p.Disconnect(err)
 			}
 
+			return
+		}
+
+		nextMessage, err := wire.ReadMessage(bytes.NewReader(rawMsg), 0)
+
+		if err == wire.ErrUnknownMessage {
+			pLog.Infow("ignoring unknown message", "peer", p)
 			continue
 		}
 
+		if err != nil {
+			p.Disconnect(err)
+			return
+		}
+
 		pLog.Infow("received message", "peer", p, "wireMsg", wire.MessageName(nextMessage.MsgType()))
 
-		p.incomingQueue <- NewEnvelope(p, nextMessage)
-		idleTimer.Reset(idleTimeout)
+		if !p.allow(nextMessage.MsgType()) {
+			pLog.Warnw("dropping rate limited message", "peer", p, "wireMsg", wire.MessageName(nextMessage.MsgType()))
+			p.reactor.misbehaving(p, ScoreMinor, "rate limit exceeded")
+			continue
+		}
+
+		if nextMessage.MsgType() == lnwire.MsgPong {
+			select {
+			case p.pongChan <- struct{}{}:
+			default:
+			}
+		}
+
+		select {
+		case p.incomingQueue <- NewEnvelope(p, nextMessage):
+		case <-p.ctx.Done():
+			return
+		}
 	}
 }
 
 func (p *Peer) writeHandler() {
-	p.wg.Add(1)
+	defer p.wg.Done()
 
 	for {
-		if atomic.LoadUint32(&p.disconnected) == 1 {
-			p.wg.Done()
-			return
-		}
-
 		select {
 		case envelope := <-p.outgoingQueue:
 			pLog.Infow("writing lnwire message", "peer", p, "wireMsg", wire.MessageName(envelope.Msg.MsgType()))
 			err := p.writeMessage(envelope.Msg)
 
 			if err != nil {
-				pLog.Errorw("failed to write message", "peer", p, "err", err)
+				p.Disconnect(err)
+				return
 			}
+		case <-p.ctx.Done():
+			return
 		}
 	}
 }
 
+// pingHandler pings the peer every pingInterval and disconnects it if no pong
+// arrives within pongTimeout.
 func (p *Peer) pingHandler() {
-	p.wg.Add(1)
+	defer p.wg.Done()
 
 	tick := time.NewTicker(pingInterval)
 	defer tick.Stop()
 
 	for {
-		if atomic.LoadUint32(&p.disconnected) == 1 {
-			p.wg.Done()
+		select {
+		case <-tick.C:
+		case <-p.ctx.Done():
 			return
 		}
 
 		select {
-		case <-tick.C:
-			p.outgoingQueue <- NewEnvelope(p, lnwire.NewPing(16))
+		case <-p.pongChan:
+		default:
 		}
-	}
-}
-
-func (p *Peer) readMessage() (lnwire.Message, error) {
-	rawMsg, err := p.conn.ReadNextMessage()
-
-	if err != nil {
-		return nil, err
-	}
 
-	msg, err := wire.ReadMessage(bytes.NewReader(rawMsg), 0)
+		ctx, cancel := context.WithTimeout(p.ctx, pongTimeout)
+		err := p.Send(ctx, lnwire.NewPing(16))
+		cancel()
+		if err != nil {
+			p.Disconnect(err)
+			return
+		}
 
-	if err != nil {
-		return nil, err
+		select {
+		case <-p.pongChan:
+		case <-time.After(pongTimeout):
+			p.Disconnect(errors.New("pong timed out"))
+			return
+		case <-p.ctx.Done():
+			return
+		}
 	}
-
-	return msg, nil
 }
 
 func (p *Peer) writeMessage(msg lnwire.Message) error {
@@ -64,3 +64,21 @@ func (p *PeerBook) RemovePeer(pub *crypto.PublicKey) bool {
 	delete(p.peers, peerIdx)
 	return true
 }
+
+// RemovePeerInstance removes peer only if it is still the one stored for its
+// identity, so that a stale disconnect cannot evict a newer connection.
+func (p *PeerBook) RemovePeerInstance(peer *Peer) bool {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	keyStr := peer.Identity.CompressedHex()
+	peerIdx, exists := p.peerIndices[keyStr]
+
+	if !exists || p.peers[peerIdx] != peer {
+		return false
+	}
+
+	delete(p.peerIndices, keyStr)
+	delete(p.peers, peerIdx)
+	return true
+}
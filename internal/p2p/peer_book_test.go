@@ -0,0 +1,18 @@
+package p2p
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerBook_RemovePeerInstance(t *testing.T) {
+	book := NewPeerBook()
+	stale := testPeer(t, true)
+	current := &Peer{Identity: stale.Identity}
+
+	assert.True(t, book.AddPeer(current))
+	assert.False(t, book.RemovePeerInstance(stale))
+	assert.Equal(t, current, book.FindPeer(stale.Identity))
+	assert.True(t, book.RemovePeerInstance(current))
+	assert.Nil(t, book.FindPeer(stale.Identity))
+}
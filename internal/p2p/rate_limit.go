@@ -0,0 +1,83 @@
+package p2p
+
+import (
+	"time"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/kyokan/drawbridge/pkg/wire"
+)
+
+// RateLimit allows Burst messages at once, refilling at PerSecond. A zero
+// PerSecond means unlimited.
+type RateLimit struct {
+	PerSecond float64
+	Burst     int
+}
+
+// PeerConfig bounds the resources a single peer can use.
+type PeerConfig struct {
+	IncomingQueueSize int
+	OutgoingQueueSize int
+	// RateLimits caps how fast the peer may send each message type. Types
+	// without an entry use DefaultRateLimit.
+	RateLimits       map[lnwire.MessageType]RateLimit
+	DefaultRateLimit RateLimit
+}
+
+func DefaultPeerConfig() *PeerConfig {
+	return &PeerConfig{
+		IncomingQueueSize: 32,
+		OutgoingQueueSize: 32,
+		RateLimits: map[lnwire.MessageType]RateLimit{
+			lnwire.MsgPing:              {PerSecond: 1, Burst: 2},
+			wire.MsgOpenChannel:         {PerSecond: 0.2, Burst: 5},
+			wire.MsgRequestQuote:        {PerSecond: 1, Burst: 10},
+			wire.MsgInitiateSwap:        {PerSecond: 0.2, Burst: 5},
+			wire.MsgInitiateReverseSwap: {PerSecond: 0.2, Burst: 5},
+		},
+		DefaultRateLimit: RateLimit{PerSecond: 20, Burst: 50},
+	}
+}
+
+func (c *PeerConfig) rateLimit(msgType lnwire.MessageType) RateLimit {
+	if limit, ok := c.RateLimits[msgType]; ok {
+		return limit
+	}
+
+	return c.DefaultRateLimit
+}
+
+type tokenBucket struct {
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		perSecond: limit.PerSecond,
+		burst:     float64(limit.Burst),
+		tokens:    float64(limit.Burst),
+		last:      now,
+	}
+}
+
+// allow takes a token if one is available.
+func (b *tokenBucket) allow(now time.Time) bool {
+	if b.perSecond == 0 {
+		return true
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.perSecond
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
@@ -0,0 +1,45 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/kyokan/drawbridge/pkg/wire"
+)
+
+func TestTokenBucket_Allow(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(RateLimit{PerSecond: 2, Burst: 3}, now)
+
+	assert.True(t, b.allow(now))
+	assert.True(t, b.allow(now))
+	assert.True(t, b.allow(now))
+	assert.False(t, b.allow(now))
+
+	now = now.Add(time.Millisecond * 500)
+	assert.True(t, b.allow(now))
+	assert.False(t, b.allow(now))
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.allow(now))
+	}
+	assert.False(t, b.allow(now))
+}
+
+func TestTokenBucket_Unlimited(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(RateLimit{}, now)
+
+	for i := 0; i < 1000; i++ {
+		assert.True(t, b.allow(now))
+	}
+}
+
+func TestPeerConfig_RateLimit(t *testing.T) {
+	c := DefaultPeerConfig()
+
+	assert.Equal(t, c.RateLimits[wire.MsgOpenChannel], c.rateLimit(wire.MsgOpenChannel))
+	assert.Equal(t, c.DefaultRateLimit, c.rateLimit(lnwire.MsgPong))
+}
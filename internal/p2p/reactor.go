@@ -5,22 +5,41 @@ import (
 	"github.com/lightningnetwork/lnd/lnwire"
 	"go.uber.org/zap"
 	"github.com/kyokan/drawbridge/internal/logger"
-	"time"
 	"github.com/kyokan/drawbridge/pkg/wire"
+	"errors"
 )
 
+// Reactor routes incoming envelopes to the first MsgHandler that accepts
+// them. Every registered channel gets its own dispatcher, so messages from one
+// peer are handled in order while different peers are handled concurrently.
 type Reactor struct {
-	chans    map[uint64]*reactorChannel
-	toAdd    map[uint64]*reactorChannel
-	toRemove []uint64
-	id       uint64
-	mut      *sync.Mutex
+	chans       map[uint64]*reactorChannel
+	id          uint64
+	mut         *sync.Mutex
 	msgHandlers []MsgHandler
+	listeners   []DisconnectListener
+	connected   []ConnectListener
+	bans        *BanManager
+}
+
+// DisconnectListener is told about every peer that goes away, after the
+// peer's goroutines have exited. Message handlers that implement it are
+// subscribed automatically.
+type DisconnectListener interface {
+	OnDisconnect(peer *Peer, reason error)
+}
+
+// ConnectListener is told about every peer that completes its handshake. It
+// is called from the peer's dispatcher, so it must not block. Message
+// handlers that implement it are subscribed automatically.
+type ConnectListener interface {
+	OnConnect(peer *Peer)
 }
 
 type reactorChannel struct {
-	in  chan *Envelope
-	out chan *Envelope
+	in   chan *Envelope
+	out  chan *Envelope
+	quit chan struct{}
 }
 
 var rLog *zap.SugaredLogger
@@ -30,71 +49,141 @@ func init() {
 }
 
 func NewReactor(msgHandlers []MsgHandler) *Reactor {
+	var listeners []DisconnectListener
+	var connected []ConnectListener
+	for _, handler := range msgHandlers {
+		if l, ok := handler.(DisconnectListener); ok {
+			listeners = append(listeners, l)
+		}
+		if l, ok := handler.(ConnectListener); ok {
+			connected = append(connected, l)
+		}
+	}
+
 	return &Reactor{
 		chans:       make(map[uint64]*reactorChannel),
-		toAdd:       make(map[uint64]*reactorChannel),
-		toRemove:    make([]uint64, 10),
 		id:          0,
 		mut:         new(sync.Mutex),
 		msgHandlers: msgHandlers,
+		listeners:   listeners,
+		connected:   connected,
 	}
 }
 
-func (r *Reactor) AddEnvelopeChan(in chan *Envelope, out chan *Envelope) uint64 {
+// SetBanManager makes the reactor score misbehaving peers. Without one,
+// violations are only logged.
+func (r *Reactor) SetBanManager(bans *BanManager) {
 	r.mut.Lock()
 	defer r.mut.Unlock()
-	r.id += 1
-	r.toAdd[r.id] = &reactorChannel{in: in, out: out}
-	return r.id
+	r.bans = bans
 }
 
-func (r *Reactor) RemoveEnvelopeChan(id uint64) {
+func (r *Reactor) AddDisconnectListener(l DisconnectListener) {
 	r.mut.Lock()
 	defer r.mut.Unlock()
-	r.toRemove = append(r.toRemove, id)
+	r.listeners = append(r.listeners, l)
 }
 
-func (r *Reactor) Run() {
-	for {
-		r.manageMembership()
+func (r *Reactor) notifyDisconnect(peer *Peer, reason error) {
+	r.mut.Lock()
+	listeners := make([]DisconnectListener, len(r.listeners))
+	copy(listeners, r.listeners)
+	r.mut.Unlock()
 
-		for _, ch := range r.chans {
-			select {
-			case in := <-ch.in:
-				res := r.handle(in)
+	for _, l := range listeners {
+		l.OnDisconnect(peer, reason)
+	}
+}
 
-				if res != nil {
-					ch.out <- NewEnvelope(in.Peer, res)
-				}
-			}
-		}
+func (r *Reactor) notifyConnect(peer *Peer) {
+	r.mut.Lock()
+	listeners := make([]ConnectListener, len(r.connected))
+	copy(listeners, r.connected)
+	r.mut.Unlock()
 
-		time.Sleep(1 * time.Second)
+	for _, l := range listeners {
+		l.OnConnect(peer)
 	}
 }
 
-func (r *Reactor) manageMembership() {
+func (r *Reactor) AddEnvelopeChan(in chan *Envelope, out chan *Envelope) uint64 {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.id += 1
+	ch := &reactorChannel{
+		in:   in,
+		out:  out,
+		quit: make(chan struct{}),
+	}
+	r.chans[r.id] = ch
+	go r.dispatch(ch)
+	return r.id
+}
+
+// RemoveEnvelopeChan stops the dispatcher for id. Removing a channel that is
+// already gone is a no-op.
+func (r *Reactor) RemoveEnvelopeChan(id uint64) {
 	r.mut.Lock()
 	defer r.mut.Unlock()
 
-	for id, ch := range r.toAdd {
-		r.chans[id] = ch
+	ch, exists := r.chans[id]
+	if !exists {
+		return
 	}
 
-	r.toAdd = make(map[uint64]*reactorChannel)
+	close(ch.quit)
+	delete(r.chans, id)
+}
+
+// Stop removes every registered channel.
+func (r *Reactor) Stop() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
 
-	for _, id := range r.toRemove {
+	for id, ch := range r.chans {
+		close(ch.quit)
 		delete(r.chans, id)
 	}
+}
 
-	r.toRemove = make([]uint64, 10)
+func (r *Reactor) dispatch(ch *reactorChannel) {
+	for {
+		select {
+		case <-ch.quit:
+			return
+		case in, ok := <-ch.in:
+			if !ok {
+				return
+			}
+			select {
+			case <-ch.quit:
+				return
+			default:
+			}
+
+			for _, res := range r.handle(in) {
+				select {
+				case ch.out <- NewEnvelope(in.Peer, res):
+				case <-ch.quit:
+					return
+				}
+			}
+		}
+	}
 }
 
-func (r *Reactor) handle(envelope *Envelope) lnwire.Message {
+func (r *Reactor) handle(envelope *Envelope) []lnwire.Message {
 	msg := envelope.Msg
-	var res lnwire.Message
+	var res []lnwire.Message
 	var err error
 
+	if msg.MsgType() != wire.MsgInit && !envelope.Peer.HandshakeComplete() {
+		rLog.Warnw("dropping message received before init", "peer", envelope.Peer,
+			"msgType", wire.MessageName(msg.MsgType()))
+		r.misbehaving(envelope.Peer, ScoreMinor, "message received before init")
+		return errorReply(msg, errors.New("handshake not complete"))
+	}
+
 	for _, handler := range r.msgHandlers {
 		if handler.CanAccept(msg) {
 			res, err = handler.Accept(envelope)
@@ -105,8 +194,38 @@ func (r *Reactor) handle(envelope *Envelope) lnwire.Message {
 	if err != nil {
 		rLog.Warnw("caught error processing message", "msgType", wire.MessageName(msg.MsgType()),
 			"err", err.Error())
-		return nil
+		if m, ok := err.(*misbehavior); ok {
+			r.misbehaving(envelope.Peer, m.points, m.Error())
+			err = m.error
+		}
+		return errorReply(msg, err)
 	}
 
 	return res
 }
+
+func (r *Reactor) misbehaving(peer *Peer, points uint32, reason string) {
+	r.mut.Lock()
+	bans := r.bans
+	r.mut.Unlock()
+
+	if bans != nil {
+		bans.Misbehaving(peer, points, reason)
+	}
+}
+
+// errorReply tells the peer why msg failed. Errors about errors are only
+// logged so that two nodes cannot bounce them back and forth.
+func errorReply(msg lnwire.Message, err error) []lnwire.Message {
+	switch msg.MsgType() {
+	case wire.MsgError, wire.MsgWarning:
+		return nil
+	}
+
+	id := wire.TargetID(msg)
+	if w, ok := err.(*warning); ok {
+		return []lnwire.Message{wire.NewWarning(id, w.error)}
+	}
+
+	return []lnwire.Message{wire.NewError(id, err)}
+}
@@ -0,0 +1,253 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/stretchr/testify/assert"
+	"github.com/kyokan/drawbridge/pkg/wire"
+	"errors"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+)
+
+type echoHandler struct {
+	block chan struct{}
+}
+
+func (h *echoHandler) CanAccept(msg lnwire.Message) bool {
+	return msg.MsgType() == lnwire.MsgPing
+}
+
+func (h *echoHandler) Accept(envelope *Envelope) ([]lnwire.Message, error) {
+	ping := envelope.Msg.(*lnwire.Ping)
+	if ping.NumPongBytes == 0 {
+		<-h.block
+	}
+	return Reply(lnwire.NewPong(make([]byte, ping.NumPongBytes)), nil)
+}
+
+func testPeer(t *testing.T, handshaked bool) *Peer {
+	identity, err := crypto.RandomPublicKey()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	p := &Peer{Identity: identity}
+	if handshaked {
+		p.CompleteHandshake()
+	}
+	return p
+}
+
+func awaitPong(t *testing.T, out chan *Envelope) *lnwire.Pong {
+	select {
+	case envelope := <-out:
+		return envelope.Msg.(*lnwire.Pong)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for reply")
+		return nil
+	}
+}
+
+func TestReactor_OrderedPerPeer(t *testing.T) {
+	r := NewReactor([]MsgHandler{&echoHandler{}})
+	peer := testPeer(t, true)
+	defer r.Stop()
+
+	in := make(chan *Envelope)
+	out := make(chan *Envelope, 10)
+	r.AddEnvelopeChan(in, out)
+
+	for i := 1; i <= 10; i++ {
+		in <- NewEnvelope(peer, lnwire.NewPing(uint16(i)))
+	}
+	for i := 1; i <= 10; i++ {
+		assert.Equal(t, i, len(awaitPong(t, out).PongBytes))
+	}
+}
+
+func TestReactor_ConcurrentPeers(t *testing.T) {
+	handler := &echoHandler{block: make(chan struct{})}
+	r := NewReactor([]MsgHandler{handler})
+	peer := testPeer(t, true)
+	defer r.Stop()
+
+	slowIn, slowOut := make(chan *Envelope), make(chan *Envelope, 1)
+	fastIn, fastOut := make(chan *Envelope), make(chan *Envelope, 1)
+	r.AddEnvelopeChan(slowIn, slowOut)
+	r.AddEnvelopeChan(fastIn, fastOut)
+
+	slowIn <- NewEnvelope(peer, lnwire.NewPing(0))
+	fastIn <- NewEnvelope(peer, lnwire.NewPing(1))
+	assert.Equal(t, 1, len(awaitPong(t, fastOut).PongBytes))
+
+	close(handler.block)
+	assert.Equal(t, 0, len(awaitPong(t, slowOut).PongBytes))
+}
+
+func TestReactor_RemoveEnvelopeChan(t *testing.T) {
+	r := NewReactor([]MsgHandler{&echoHandler{}})
+	peer := testPeer(t, true)
+
+	in := make(chan *Envelope, 1)
+	out := make(chan *Envelope, 1)
+	id := r.AddEnvelopeChan(in, out)
+	r.RemoveEnvelopeChan(id)
+	r.RemoveEnvelopeChan(id)
+
+	in <- NewEnvelope(peer, lnwire.NewPing(1))
+	select {
+	case <-out:
+		t.Fatal("removed channel is still being handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+type splitHandler struct{}
+
+func (h *splitHandler) CanAccept(msg lnwire.Message) bool {
+	return msg.MsgType() == lnwire.MsgPing
+}
+
+func (h *splitHandler) Accept(envelope *Envelope) ([]lnwire.Message, error) {
+	ping := envelope.Msg.(*lnwire.Ping)
+	var res []lnwire.Message
+	for i := uint16(1); i <= ping.NumPongBytes; i++ {
+		res = append(res, lnwire.NewPong(make([]byte, i)))
+	}
+	return res, nil
+}
+
+func TestReactor_MultipleReplies(t *testing.T) {
+	r := NewReactor([]MsgHandler{&splitHandler{}})
+	peer := testPeer(t, true)
+	defer r.Stop()
+
+	in := make(chan *Envelope)
+	out := make(chan *Envelope, 3)
+	r.AddEnvelopeChan(in, out)
+
+	in <- NewEnvelope(peer, lnwire.NewPing(3))
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, i, len(awaitPong(t, out).PongBytes))
+	}
+
+	in <- NewEnvelope(peer, lnwire.NewPing(0))
+	select {
+	case <-out:
+		t.Fatal("expected no reply")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+type failingHandler struct {
+	err error
+}
+
+func (h *failingHandler) CanAccept(msg lnwire.Message) bool {
+	return true
+}
+
+func (h *failingHandler) Accept(envelope *Envelope) ([]lnwire.Message, error) {
+	return nil, h.err
+}
+
+func TestReactor_ErrorReplies(t *testing.T) {
+	handler := &failingHandler{err: errors.New("no swap with that ID found")}
+	r := NewReactor([]MsgHandler{handler})
+	peer := testPeer(t, true)
+	defer r.Stop()
+
+	in := make(chan *Envelope)
+	out := make(chan *Envelope, 1)
+	r.AddEnvelopeChan(in, out)
+
+	swapId := [32]byte{0x01}
+	in <- NewEnvelope(peer, &wire.InvoiceExecuted{SwapID: swapId})
+	res := (<-out).Msg.(*wire.Error)
+	assert.Equal(t, swapId, res.ID)
+	assert.Equal(t, "no swap with that ID found", res.Data)
+
+	handler.err = Warn(errors.New("quote is outside max slippage"))
+	in <- NewEnvelope(peer, &wire.SwapQuote{QuoteID: swapId})
+	warning := (<-out).Msg.(*wire.Warning)
+	assert.Equal(t, swapId, warning.ID)
+	assert.Equal(t, "quote is outside max slippage", warning.Data)
+
+	in <- NewEnvelope(peer, &wire.Error{ID: swapId})
+	select {
+	case <-out:
+		t.Fatal("replied to an error")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReactor_RequiresHandshake(t *testing.T) {
+	r := NewReactor([]MsgHandler{&echoHandler{}})
+	defer r.Stop()
+
+	in := make(chan *Envelope)
+	out := make(chan *Envelope, 1)
+	r.AddEnvelopeChan(in, out)
+
+	peer := testPeer(t, false)
+	in <- NewEnvelope(peer, lnwire.NewPing(1))
+	res := (<-out).Msg.(*wire.Error)
+	assert.Equal(t, "handshake not complete", res.Data)
+
+	peer.CompleteHandshake()
+	in <- NewEnvelope(peer, lnwire.NewPing(1))
+	assert.Equal(t, 1, len(awaitPong(t, out).PongBytes))
+}
+
+type disconnectRecorder struct {
+	echoHandler
+	peers chan *Peer
+}
+
+func (d *disconnectRecorder) OnDisconnect(peer *Peer, reason error) {
+	d.peers <- peer
+}
+
+func TestReactor_NotifyDisconnect(t *testing.T) {
+	handler := &disconnectRecorder{peers: make(chan *Peer, 2)}
+	extra := &disconnectRecorder{peers: make(chan *Peer, 2)}
+	r := NewReactor([]MsgHandler{handler})
+	r.AddDisconnectListener(extra)
+	peer := testPeer(t, true)
+
+	r.notifyDisconnect(peer, errors.New("gone"))
+	assert.Equal(t, peer, <-handler.peers)
+	assert.Equal(t, peer, <-extra.peers)
+}
+
+type connectRecorder struct {
+	echoHandler
+	peers chan *Peer
+}
+
+func (c *connectRecorder) OnConnect(peer *Peer) {
+	c.peers <- peer
+}
+
+func TestReactor_NotifyConnectOnce(t *testing.T) {
+	handler := &connectRecorder{peers: make(chan *Peer, 2)}
+	r := NewReactor([]MsgHandler{handler})
+	peer := testPeer(t, false)
+	peer.reactor = r
+
+	peer.CompleteHandshake()
+	peer.CompleteHandshake()
+	assert.Equal(t, peer, <-handler.peers)
+	assert.Len(t, handler.peers, 0)
+}
+
+func TestPeer_ReceiveInitOnce(t *testing.T) {
+	peer := testPeer(t, false)
+	lndIdentity, err := crypto.RandomPublicKey()
+	assert.Nil(t, err)
+
+	assert.Nil(t, peer.ReceiveInit(lndIdentity, nil, nil))
+	assert.Equal(t, ErrDuplicateInit, peer.ReceiveInit(nil, nil, nil))
+	assert.Equal(t, lndIdentity, peer.LNDIdentity)
+}
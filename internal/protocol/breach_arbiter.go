@@ -0,0 +1,243 @@
+package protocol
+
+import (
+	"github.com/kyokan/drawbridge/internal/wallet"
+	"github.com/kyokan/drawbridge/internal/ethclient"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/pkg/txout"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"bytes"
+	"errors"
+	"sync"
+	"context"
+)
+
+// BreachArbiter watches for channels whose funding output is spent by a
+// commitment the counterparty has already revoked, and sweeps their balance to
+// us through the revocation path before the commitment's delay runs out.
+// Pending punishments are persisted and retried on every poll until the
+// output is spent. HTLCs on the revoked commitment are left to the
+// HTLCSweeper, which redeems the ones paying us whether or not the breached
+// commitment left the counterparty any balance to punish.
+type BreachArbiter struct {
+	km       *wallet.KeyManager
+	client   *ethclient.Client
+	db       *db.DB
+	inFlight map[common.Hash]bool
+	mtx      sync.Mutex
+}
+
+func NewBreachArbiter(km *wallet.KeyManager, client *ethclient.Client, db *db.DB) *BreachArbiter {
+	return &BreachArbiter{
+		km:       km,
+		client:   client,
+		db:       db,
+		inFlight: make(map[common.Hash]bool),
+	}
+}
+
+func (b *BreachArbiter) OnPoll(outputs *db.PolledOutputs) {
+	for _, id := range outputs.Spent {
+		channel, err := b.db.Channels.FindByFundingOutput(id)
+		if err != nil {
+			log.Errorw("failed to look up channel by funding output", "outputId", id.Hex(), "err", err.Error())
+			continue
+		}
+		if channel == nil {
+			continue
+		}
+
+		if err := b.checkSpend(channel, outputs.SpentIn[id], outputs.New); err != nil {
+			log.Errorw("failed to check funding spend for breach", "chanId", channel.ID.Hex(), "err", err.Error())
+		}
+	}
+
+	b.punishPending()
+}
+
+// checkSpend records a punishment if the transaction that spent the channel's
+// funding output created a commitment output we hold the revocation secret
+// for. polled holds the outputs indexed alongside the spend.
+func (b *BreachArbiter) checkSpend(channel *db.ETHChannel, txHash common.Hash, polled []*db.ETHOutput) error {
+	for _, out := range polled {
+		if out.TxHash != txHash || out.Type != uint8(txout.OutputCommitmentLocal) {
+			continue
+		}
+
+		secret, err := b.findSecret(channel, out)
+		if err != nil {
+			return err
+		}
+		if secret == nil {
+			return nil
+		}
+
+		log.Warnw("found breached commitment", "chanId", channel.ID.Hex(), "outputId", out.ID.Hex())
+		return b.db.Punishments.Save(&db.Punishment{
+			OutputID:  out.ID,
+			ChannelID: channel.ID,
+		})
+	}
+
+	return nil
+}
+
+// punishPending sweeps the revoked outputs that haven't been punished yet. It
+// runs on every poll, so that sweeps that fail or get dropped are retried.
+func (b *BreachArbiter) punishPending() {
+	punishments, err := b.db.Punishments.FindUnpunished()
+	if err != nil {
+		log.Errorw("failed to find pending punishments", "err", err.Error())
+		return
+	}
+
+	for _, punishment := range punishments {
+		b.mtx.Lock()
+		inFlight := b.inFlight[punishment.OutputID]
+		b.mtx.Unlock()
+		if inFlight {
+			continue
+		}
+
+		if err := b.checkPunishment(punishment); err != nil {
+			log.Errorw("failed to punish breached commitment", "chanId", punishment.ChannelID.Hex(),
+				"outputId", punishment.OutputID.Hex(), "err", err.Error())
+		}
+	}
+}
+
+func (b *BreachArbiter) checkPunishment(punishment *db.Punishment) error {
+	out, err := b.db.Outputs.FindById(punishment.OutputID)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return errors.New("breached output not found")
+	}
+	if out.IsSpent {
+		// either our sweep made it or the counterparty got their delayed
+		// sweep in first; there is nothing left to try
+		punishment.Punished = true
+		return b.db.Punishments.Save(punishment)
+	}
+
+	channel, err := b.db.Channels.FindById(punishment.ChannelID)
+	if err != nil {
+		return err
+	}
+	if channel == nil {
+		return errors.New("no channel with that id found")
+	}
+	secret, err := b.findSecret(channel, out)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return errors.New("no revocation secret for breached output")
+	}
+
+	tx, err := b.punish(channel, out, secret)
+	if err != nil {
+		return err
+	}
+
+	log.Infow("broadcast punishment", "chanId", channel.ID.Hex(), "outputId", out.ID.Hex(), "txHash", tx.Hash().Hex())
+	b.mtx.Lock()
+	b.inFlight[out.ID] = true
+	b.mtx.Unlock()
+	go b.awaitPunishment(punishment, tx)
+	return nil
+}
+
+// awaitPunishment records a punishment once it is mined. One that fails or
+// takes longer than sweepTimeout is retried on a later poll.
+func (b *BreachArbiter) awaitPunishment(punishment *db.Punishment, tx *ethclient.PendingTx) {
+	defer func() {
+		b.mtx.Lock()
+		delete(b.inFlight, punishment.OutputID)
+		b.mtx.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sweepTimeout)
+	defer cancel()
+	receipt, err := tx.Wait(ctx)
+	if err != nil {
+		log.Warnw("punishment did not go through", "chanId", punishment.ChannelID.Hex(), "txHash", tx.Hash().Hex(), "err", err.Error())
+		return
+	}
+
+	log.Infow("punished breached commitment", "chanId", punishment.ChannelID.Hex(), "txHash", receipt.TxHash.Hex())
+	punishment.TxHash = receipt.TxHash
+	punishment.Punished = true
+	if err := b.db.Punishments.Save(punishment); err != nil {
+		log.Errorw("failed to save punishment", "chanId", punishment.ChannelID.Hex(), "err", err.Error())
+	}
+}
+
+// findSecret returns the revocation secret for a commitment output of the
+// channel, or nil if the commitment has not been revoked.
+func (b *BreachArbiter) findSecret(channel *db.ETHChannel, out *db.ETHOutput) ([]byte, error) {
+	if len(out.Script) == 0 {
+		return nil, errors.New("output has no script")
+	}
+
+	script := &txout.CommitmentLocal{}
+	if err := script.Decode(bytes.NewReader(out.Script[1:]), 0); err != nil {
+		return nil, err
+	}
+	if script.DelayedAddress != channel.Counterparty {
+		return nil, nil
+	}
+
+	return findRevocation(channel, script.RevocationAddress, b.km.PublicKey()), nil
+}
+
+func (b *BreachArbiter) punish(channel *db.ETHChannel, out *db.ETHOutput, secret []byte) (*ethclient.PendingTx, error) {
+	req := &txout.SpendRequest{
+		InputID: out.ID,
+		Witness: txout.NewCommitmentLocalWitness(txout.CommitmentLocalRevocation),
+		Values: []*big.Int{
+			out.Amount,
+		},
+		Outputs: []txout.Output{
+			txout.NewPayment(b.km.PublicKey().ETHAddress()),
+		},
+	}
+
+	sigHash, err := txout.SigData(req)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := b.km.SignWithRevocation(secret, sigHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.client.Spend(req, sig)
+}
+
+// findRevocation looks through the secrets the counterparty has revealed for
+// the one behind the given revocation address, which is derived from our
+// revocation basepoint. It returns nil if the address belongs to a commitment
+// that has not been revoked.
+func findRevocation(channel *db.ETHChannel, revocation common.Address, basepoint *crypto.PublicKey) []byte {
+	if len(channel.TheirRevocationStore) == 0 {
+		return nil
+	}
+
+	for i := uint64(0); i <= channel.CommitmentNumber; i++ {
+		secret, err := wallet.LookupRevocation(channel.TheirRevocationStore, i)
+		if err != nil {
+			// the store holds no secrets past the last revoked commitment
+			break
+		}
+
+		if wallet.RevocationAddress(basepoint.BTCEC(), wallet.CommitmentPoint(secret)) == revocation {
+			return secret
+		}
+	}
+
+	return nil
+}
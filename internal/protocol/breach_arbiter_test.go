@@ -0,0 +1,498 @@
+package protocol
+
+import (
+	"testing"
+	"math/big"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/internal/wallet"
+	"github.com/kyokan/drawbridge/internal/ethclient"
+	"github.com/kyokan/drawbridge/pkg/contracts"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"github.com/kyokan/drawbridge/pkg/txout"
+	"github.com/kyokan/drawbridge/pkg/eth"
+	"github.com/stretchr/testify/assert"
+)
+
+type memChannels struct {
+	channels []*db.ETHChannel
+	htlcs    []*db.ETHHTLC
+	closed   []common.Hash
+}
+
+func (m *memChannels) Save(channel *db.ETHChannel) error {
+	m.channels = append(m.channels, channel)
+	return nil
+}
+
+func (m *memChannels) UpdateState(channel *db.ETHChannel, htlcs []*db.ETHHTLC) error {
+	return nil
+}
+
+func (m *memChannels) MarkClosed(chanId common.Hash) error {
+	m.closed = append(m.closed, chanId)
+	return nil
+}
+
+func (m *memChannels) FindById(chanId common.Hash) (*db.ETHChannel, error) {
+	for _, channel := range m.channels {
+		if channel.ID == chanId {
+			return channel, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memChannels) FindByFundingOutput(outputId common.Hash) (*db.ETHChannel, error) {
+	for _, channel := range m.channels {
+		if channel.FundingOutput == outputId {
+			return channel, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memChannels) FindByAmount(amount *big.Int) (*db.ETHChannel, error) {
+	return nil, nil
+}
+
+func (m *memChannels) FindByCounterparty(counterparty common.Address) ([]*db.ETHChannel, error) {
+	var out []*db.ETHChannel
+	for _, channel := range m.channels {
+		if channel.Counterparty == counterparty {
+			out = append(out, channel)
+		}
+	}
+	return out, nil
+}
+
+func (m *memChannels) FindHTLCs(chanId common.Hash) ([]*db.ETHHTLC, error) {
+	var out []*db.ETHHTLC
+	for _, htlc := range m.htlcs {
+		if htlc.ChannelID == chanId {
+			out = append(out, htlc)
+		}
+	}
+	return out, nil
+}
+
+func (m *memChannels) FindFulfilledHTLC(paymentHash common.Hash) (*db.ETHHTLC, error) {
+	for _, htlc := range m.htlcs {
+		if htlc.Fulfilled && htlc.PaymentHash == paymentHash {
+			return htlc, nil
+		}
+	}
+	return nil, nil
+}
+
+type memTransactions struct {
+	txs map[uint64]*db.ETHTransaction
+}
+
+func (m *memTransactions) Save(tx *db.ETHTransaction) error {
+	if m.txs == nil {
+		m.txs = make(map[uint64]*db.ETHTransaction)
+	}
+	m.txs[tx.Nonce] = tx
+	return nil
+}
+
+func (m *memTransactions) Delete(from common.Address, nonce uint64) error {
+	delete(m.txs, nonce)
+	return nil
+}
+
+func (m *memTransactions) FindPending(from common.Address) ([]*db.ETHTransaction, error) {
+	return nil, nil
+}
+
+func (m *memTransactions) FindByHash(from common.Address, hash common.Hash) (*db.ETHTransaction, error) {
+	for _, tx := range m.txs {
+		for _, h := range tx.Hashes {
+			if h == hash {
+				return tx, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (m *memTransactions) NextNonce(from common.Address) (uint64, error) {
+	return uint64(len(m.txs)), nil
+}
+
+type memPunishments struct {
+	punishments map[common.Hash]*db.Punishment
+}
+
+func (m *memPunishments) Save(punishment *db.Punishment) error {
+	if m.punishments == nil {
+		m.punishments = make(map[common.Hash]*db.Punishment)
+	}
+	m.punishments[punishment.OutputID] = punishment
+	return nil
+}
+
+func (m *memPunishments) FindUnpunished() ([]*db.Punishment, error) {
+	var out []*db.Punishment
+	for _, punishment := range m.punishments {
+		if !punishment.Punished {
+			out = append(out, punishment)
+		}
+	}
+	return out, nil
+}
+
+func TestBreachArbiter_PunishesRevokedCommitment(t *testing.T) {
+	km, err := wallet.NewKeyManager("c87509a1c067bbde78beb793e6fa76530b6382a4c0241e5e4a9ec0a0f44dc0d3", big.NewInt(1337))
+	assert.Nil(t, err)
+	themKm, err := wallet.NewKeyManager("ae6ae8e5ccbfb04590405997ee2d52d2b330726137b875053c36d94e974d162f", big.NewInt(1337))
+	assert.Nil(t, err)
+	us := km.PublicKey().ETHAddress()
+	them := themKm.PublicKey().ETHAddress()
+
+	ether := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{
+		us:   {Balance: ether},
+		them: {Balance: ether},
+	})
+	auth := km.NewTransactor(0)
+	themAuth := themKm.NewTransactor(0)
+
+	tokenAddress, _, token, err := contracts.DeployTestToken(auth, sim)
+	assert.Nil(t, err)
+	sim.Commit()
+	lightningAddress, _, lightning, err := contracts.DeployLightningERC20(auth, sim, tokenAddress)
+	assert.Nil(t, err)
+	sim.Commit()
+
+	// they fund a channel with us out of a fresh deposit
+	amount := big.NewInt(1000)
+	_, err = token.Mint(auth, them, amount)
+	assert.Nil(t, err)
+	sim.Commit()
+	_, err = token.Approve(themAuth, lightningAddress, amount)
+	assert.Nil(t, err)
+	sim.Commit()
+	depositTx, err := lightning.Deposit(themAuth, amount)
+	assert.Nil(t, err)
+	sim.Commit()
+	deposit := createdOutputs(t, lightning, depositTx.Hash())
+	assert.Len(t, deposit, 1)
+
+	themClient, err := ethclient.NewClientFromBackend(themKm, &memTransactions{}, sim, lightningAddress.Hex())
+	assert.Nil(t, err)
+	fundingReq := genSpendRequest(deposit[0].ID, amount, them, us)
+	sigHash, err := txout.SigData(fundingReq)
+	assert.Nil(t, err)
+	sig, err := themKm.SignData(sigHash)
+	assert.Nil(t, err)
+	fundingTx, err := themClient.DepositMultisig(fundingReq, sig)
+	assert.Nil(t, err)
+	sim.Commit()
+	funding := createdOutputs(t, lightning, fundingTx.Hash())
+	assert.Len(t, funding, 1)
+
+	// they broadcast their commitment 0, which still holds an htlc to us that
+	// a later commitment fulfilled
+	theirRoot := common.HexToHash("0x9a3af8416df249e86d4e508718ab492a00471ca8fb23992a5dc56a158e885cc3")
+	preimage := common.HexToHash("0x05")
+	paymentHash := common.Hash(sha256.Sum256(preimage[:]))
+	revokedState := &db.ETHChannel{
+		FundingOutput:            funding[0].ID,
+		Counterparty:             us,
+		LocalBalance:             big.NewInt(900),
+		RemoteBalance:            big.NewInt(0),
+		RevocationRoot:           theirRoot,
+		TheirRevocationBasepoint: km.PublicKey(),
+	}
+	offered := &db.ETHHTLC{
+		Offered:     true,
+		Amount:      big.NewInt(100),
+		PaymentHash: paymentHash,
+		Delay:       csvDelay,
+	}
+	commitment, err := ourCommitment(revokedState, []*db.ETHHTLC{offered}, them)
+	assert.Nil(t, err)
+	sigHash, err = txout.SigData(commitment)
+	assert.Nil(t, err)
+	ourSig, err := km.SignData(sigHash)
+	assert.Nil(t, err)
+	theirSig, err := themKm.SignData(sigHash)
+	assert.Nil(t, err)
+	aliceSig, bobSig := ourSig, theirSig
+	if alice, _ := eth.SortAddresses(us, them); alice != us {
+		aliceSig, bobSig = theirSig, ourSig
+	}
+	breachTx, err := themClient.SpendMultisig(commitment, aliceSig, bobSig)
+	assert.Nil(t, err)
+	sim.Commit()
+	breached := createdOutputs(t, lightning, breachTx.Hash())
+	assert.Len(t, breached, 2)
+
+	secret, err := wallet.RevocationSecretAtIndex(theirRoot[:], 0)
+	assert.Nil(t, err)
+	store, err := wallet.AddRevocation(nil, secret)
+	assert.Nil(t, err)
+	chanId := common.HexToHash("0x01")
+	channels := &memChannels{
+		channels: []*db.ETHChannel{
+			{
+				ID:                   chanId,
+				FundingOutput:        funding[0].ID,
+				Counterparty:         them,
+				CommitmentNumber:     1,
+				TheirRevocationStore: store,
+			},
+		},
+		htlcs: []*db.ETHHTLC{
+			{ChannelID: chanId, Amount: big.NewInt(100), PaymentHash: paymentHash, Preimage: preimage, Fulfilled: true},
+		},
+	}
+	client, err := ethclient.NewClientFromBackend(km, &memTransactions{}, sim, lightningAddress.Hex())
+	assert.Nil(t, err)
+	outputs := &unspentOutputs{outputs: breached}
+	punishments := &memPunishments{}
+	d := &db.DB{Outputs: outputs, Channels: channels, Swaps: &memSwaps{}, Punishments: punishments}
+	arbiter := NewBreachArbiter(km, client, d)
+
+	var local *db.ETHOutput
+	for _, out := range breached {
+		if out.Type == uint8(txout.OutputCommitmentLocal) {
+			local = out
+		}
+	}
+	assert.NotNil(t, local)
+
+	// the breach is found from the spend of the funding output, and the
+	// htlc it left is redeemed by the sweeper. The sweeps go in a block
+	// each, since the simulated chain can't fit two spends' gas limits into
+	// one.
+	arbiter.OnPoll(&db.PolledOutputs{
+		New:     breached,
+		Spent:   []common.Hash{funding[0].ID},
+		SpentIn: map[common.Hash]common.Hash{funding[0].ID: breachTx.Hash()},
+	})
+	assert.Len(t, punishments.punishments, 1)
+	assert.NotNil(t, punishments.punishments[local.ID])
+	sim.Commit()
+
+	NewHTLCSweeper(km, client, d).OnPoll(&db.PolledOutputs{})
+	sim.Commit()
+
+	before, err := token.BalanceOf(nil, us)
+	assert.Nil(t, err)
+	var swept []*db.ETHOutput
+	for _, out := range createdOutputs(t, lightning, common.Hash{}) {
+		if out.Type == uint8(txout.OutputPayment) && bytes.Equal(out.Script[1:], us.Bytes()) {
+			swept = append(swept, out)
+		}
+	}
+	assert.Len(t, swept, 2)
+	for _, out := range swept {
+		withdraw(t, km, lightning, out)
+		sim.Commit()
+	}
+	after, err := token.BalanceOf(nil, us)
+	assert.Nil(t, err)
+	assert.Equal(t, amount, new(big.Int).Sub(after, before))
+}
+
+func TestBreachArbiter_IgnoresCurrentCommitment(t *testing.T) {
+	km, err := wallet.NewKeyManager("c87509a1c067bbde78beb793e6fa76530b6382a4c0241e5e4a9ec0a0f44dc0d3", big.NewInt(1337))
+	assert.Nil(t, err)
+	us := km.PublicKey().ETHAddress()
+	them := common.HexToAddress("0xf17f52151ebef6c7334fad080c5704d77216b732")
+
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{})
+	client, err := ethclient.NewClientFromBackend(km, &memTransactions{}, sim, "0x345ca3e014aaf5dca488057592ee47305d9b3e10")
+	assert.Nil(t, err)
+
+	root, err := hexutil.Decode("0x9a3af8416df249e86d4e508718ab492a00471ca8fb23992a5dc56a158e885cc3")
+	assert.Nil(t, err)
+	secret, err := wallet.RevocationSecretAtIndex(root, 0)
+	assert.Nil(t, err)
+	store, err := wallet.AddRevocation(nil, secret)
+	assert.Nil(t, err)
+
+	funding := common.HexToHash("0x20")
+	channels := &memChannels{
+		channels: []*db.ETHChannel{
+			{
+				ID:                   common.HexToHash("0x01"),
+				FundingOutput:        funding,
+				Counterparty:         them,
+				CommitmentNumber:     1,
+				TheirRevocationStore: store,
+			},
+		},
+	}
+	punishments := &memPunishments{}
+	arbiter := NewBreachArbiter(km, client, &db.DB{Channels: channels, Punishments: punishments})
+
+	current := commitmentOutput(t, root, 1, them, km.PublicKey())
+	current.TxHash = common.HexToHash("0xaa")
+	assert.Nil(t, arbiter.checkSpend(channels.channels[0], current.TxHash, []*db.ETHOutput{current}))
+	assert.Empty(t, punishments.punishments)
+
+	// a revoked commitment that left them nothing has nothing to punish
+	htlc := htlcOutput(t, common.HexToHash("0x10"), us, them, common.HexToHash("0x06"))
+	htlc.TxHash = common.HexToHash("0xbb")
+	arbiter.OnPoll(&db.PolledOutputs{
+		New:     []*db.ETHOutput{htlc},
+		Spent:   []common.Hash{funding},
+		SpentIn: map[common.Hash]common.Hash{funding: htlc.TxHash},
+	})
+	assert.Empty(t, punishments.punishments)
+}
+
+func TestBreachArbiter_RetriesPunishments(t *testing.T) {
+	km, err := wallet.NewKeyManager("c87509a1c067bbde78beb793e6fa76530b6382a4c0241e5e4a9ec0a0f44dc0d3", big.NewInt(1337))
+	assert.Nil(t, err)
+	us := km.PublicKey().ETHAddress()
+	them := common.HexToAddress("0xf17f52151ebef6c7334fad080c5704d77216b732")
+
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{
+		us: {Balance: new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)},
+	})
+	txs := &memTransactions{}
+	client, err := ethclient.NewClientFromBackend(km, txs, sim, "0x345ca3e014aaf5dca488057592ee47305d9b3e10")
+	assert.Nil(t, err)
+
+	root, err := hexutil.Decode("0x9a3af8416df249e86d4e508718ab492a00471ca8fb23992a5dc56a158e885cc3")
+	assert.Nil(t, err)
+	secret, err := wallet.RevocationSecretAtIndex(root, 0)
+	assert.Nil(t, err)
+	store, err := wallet.AddRevocation(nil, secret)
+	assert.Nil(t, err)
+
+	chanId := common.HexToHash("0x01")
+	channels := &memChannels{
+		channels: []*db.ETHChannel{
+			{
+				ID:                   chanId,
+				Counterparty:         them,
+				CommitmentNumber:     1,
+				TheirRevocationStore: store,
+			},
+		},
+	}
+	revoked := commitmentOutput(t, root, 0, them, km.PublicKey())
+	outputs := &unspentOutputs{}
+	punishments := &memPunishments{}
+	punishments.Save(&db.Punishment{OutputID: revoked.ID, ChannelID: chanId})
+	arbiter := NewBreachArbiter(km, client, &db.DB{Outputs: outputs, Channels: channels, Punishments: punishments})
+
+	// a punishment whose output isn't indexed yet waits for a later poll
+	arbiter.OnPoll(&db.PolledOutputs{})
+	assert.Empty(t, txs.txs)
+
+	outputs.outputs = []*db.ETHOutput{revoked}
+	arbiter.OnPoll(&db.PolledOutputs{})
+	assert.Len(t, txs.txs, 1)
+
+	// sweeps in flight aren't sent again
+	arbiter.OnPoll(&db.PolledOutputs{})
+	assert.Len(t, txs.txs, 1)
+
+	// once the output is spent there is nothing left to retry
+	revoked.IsSpent = true
+	arbiter.mtx.Lock()
+	delete(arbiter.inFlight, revoked.ID)
+	arbiter.mtx.Unlock()
+	arbiter.OnPoll(&db.PolledOutputs{})
+	assert.Len(t, txs.txs, 1)
+	assert.True(t, punishments.punishments[revoked.ID].Punished)
+}
+
+// createdOutputs returns the outputs the lightning contract created in the
+// given transaction, or in every transaction if txHash is zero.
+func createdOutputs(t *testing.T, lightning *contracts.LightningERC20, txHash common.Hash) []*db.ETHOutput {
+	it, err := lightning.FilterCreate(&bind.FilterOpts{Start: 0})
+	assert.Nil(t, err)
+	defer it.Close()
+
+	var out []*db.ETHOutput
+	for it.Next() {
+		ev := it.Event
+		if txHash != (common.Hash{}) && ev.Raw.TxHash != txHash {
+			continue
+		}
+
+		out = append(out, &db.ETHOutput{
+			ID:          ev.Id,
+			Amount:      ev.Value,
+			BlockNumber: ev.BlockNum.Uint64(),
+			TxHash:      ev.Raw.TxHash,
+			Script:      ev.Script,
+			Type:        ev.Script[0],
+		})
+	}
+	assert.Nil(t, it.Error())
+	return out
+}
+
+// withdraw moves a payment output out of the lightning contract and into its
+// owner's token balance.
+func withdraw(t *testing.T, km *wallet.KeyManager, lightning *contracts.LightningERC20, out *db.ETHOutput) {
+	var witness bytes.Buffer
+	assert.Nil(t, txout.NewPaymentWitness().Encode(&witness))
+	sig, err := km.SignData(ethcrypto.Keccak256(out.ID[:], witness.Bytes()))
+	assert.Nil(t, err)
+
+	var l [2]byte
+	binary.BigEndian.PutUint16(l[:], uint16(witness.Len()+len(sig)))
+	var data bytes.Buffer
+	data.Write(out.ID[:])
+	data.Write(make([]byte, 14))
+	data.Write(l[:])
+	data.Write(witness.Bytes())
+	data.Write(sig)
+
+	_, err = lightning.Withdraw(km.NewTransactor(0), data.Bytes(), km.PublicKey().ETHAddress())
+	assert.Nil(t, err)
+}
+
+func htlcOutput(t *testing.T, id common.Hash, redeemer common.Address, timeout common.Address, paymentHash common.Hash) *db.ETHOutput {
+	var script bytes.Buffer
+	htlc := &txout.OfferedHTLC{
+		Delay:             big.NewInt(csvDelay),
+		RedemptionAddress: redeemer,
+		TimeoutAddress:    timeout,
+		PaymentHash:       paymentHash,
+	}
+	err := htlc.Encode(&script, 0)
+	assert.Nil(t, err)
+
+	return &db.ETHOutput{
+		ID:     id,
+		Amount: big.NewInt(100),
+		Script: script.Bytes(),
+		Type:   uint8(txout.OutputOfferedHTLC),
+	}
+}
+
+func commitmentOutput(t *testing.T, root []byte, index uint64, owner common.Address, basepoint *crypto.PublicKey) *db.ETHOutput {
+	point, err := wallet.CommitmentAtIndex(root, index)
+	assert.Nil(t, err)
+
+	var script bytes.Buffer
+	revocation := wallet.RevocationAddress(basepoint.BTCEC(), point)
+	err = txout.NewCommitmentLocal(big.NewInt(csvDelay), owner, revocation).Encode(&script, 0)
+	assert.Nil(t, err)
+
+	return &db.ETHOutput{
+		ID:     common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e"),
+		Amount: big.NewInt(900),
+		Script: script.Bytes(),
+		Type:   uint8(txout.OutputCommitmentLocal),
+	}
+}
@@ -0,0 +1,506 @@
+package protocol
+
+import (
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"github.com/kyokan/drawbridge/pkg/eth"
+	"github.com/kyokan/drawbridge/pkg/txout"
+	"github.com/kyokan/drawbridge/pkg/wire"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/internal/ethclient"
+	"github.com/kyokan/drawbridge/internal/p2p"
+	"math/big"
+	"bytes"
+	"errors"
+	"context"
+	"time"
+)
+
+// sweepTimeout bounds how long a delayed sweep may stay unmined before it is
+// retried on a later poll.
+const sweepTimeout = time.Minute * 30
+
+// closingChannelExpiry is how long a cooperative close may wait on the peer
+// before it is abandoned and the channel can be updated or closed again.
+const closingChannelExpiry = time.Minute * 10
+
+type closingChannel struct {
+	ChannelID    common.Hash
+	IsInitiator  bool
+	Counterparty *crypto.PublicKey
+	CreatedAt    time.Time
+}
+
+// CloseChannel starts a cooperative close. The peer answers our shutdown with
+// its signature over the closing spend, after which we co-sign, broadcast it
+// and send our own signature back.
+func (c *ChannelHandler) CloseChannel(ctx context.Context, pub *crypto.PublicKey, chanId common.Hash) error {
+	peer := c.peerBook.FindPeer(pub)
+	if peer == nil {
+		return errors.New("peer not found")
+	}
+
+	_, err := c.closableChannel(chanId)
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	c.expireClosing(time.Now())
+	if _, exists := c.closingChannels[chanId]; exists {
+		c.mtx.Unlock()
+		return errors.New("channel is already closing")
+	}
+	c.closingChannels[chanId] = &closingChannel{
+		ChannelID:    chanId,
+		IsInitiator:  true,
+		Counterparty: pub,
+		CreatedAt:    time.Now(),
+	}
+	c.mtx.Unlock()
+
+	return peer.Send(ctx, &wire.Shutdown{
+		ChannelID: chanId,
+	})
+}
+
+func (c *ChannelHandler) onShutdown(msg *wire.Shutdown, peer *p2p.Peer) (lnwire.Message, error) {
+	channel, err := c.closableChannel(msg.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	c.expireClosing(time.Now())
+	if _, exists := c.closingChannels[msg.ChannelID]; exists {
+		c.mtx.Unlock()
+		return nil, errors.New("channel is already closing")
+	}
+	c.closingChannels[msg.ChannelID] = &closingChannel{
+		ChannelID:    msg.ChannelID,
+		IsInitiator:  false,
+		Counterparty: peer.Identity,
+		CreatedAt:    time.Now(),
+	}
+	c.mtx.Unlock()
+
+	sigHash, err := txout.SigData(genClosingSpendRequest(channel, c.km.PublicKey().ETHAddress()))
+	if err != nil {
+		return nil, err
+	}
+	sig, err := c.km.SignData(sigHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wire.ClosingSigned{
+		ChannelID: msg.ChannelID,
+		Sig:       sig,
+	}, nil
+}
+
+func (c *ChannelHandler) onClosingSigned(msg *wire.ClosingSigned) (lnwire.Message, error) {
+	c.mtx.Lock()
+	closing, exists := c.closingChannels[msg.ChannelID]
+	if !exists {
+		defer c.mtx.Unlock()
+		return nil, errors.New("no closing channel with that id found")
+	}
+	c.mtx.Unlock()
+
+	channel, err := c.closableChannel(msg.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+
+	us := c.km.PublicKey().ETHAddress()
+	req := genClosingSpendRequest(channel, us)
+	sigHash, err := txout.SigData(req)
+	if err != nil {
+		return nil, err
+	}
+	if !msg.Sig.VerifyAddress(sigHash, channel.Counterparty) {
+		return nil, p2p.Misbehaving(p2p.ScoreSevere, errors.New("closing signature verification failed"))
+	}
+
+	var res lnwire.Message
+	if closing.IsInitiator {
+		ourSig, err := c.km.SignData(sigHash)
+		if err != nil {
+			return nil, err
+		}
+
+		aliceSig, bobSig := ourSig, msg.Sig
+		if alice, _ := eth.SortAddresses(us, channel.Counterparty); alice != us {
+			aliceSig, bobSig = msg.Sig, ourSig
+		}
+
+		tx, err := c.client.SpendMultisig(req, aliceSig, bobSig)
+		if err != nil {
+			return nil, err
+		}
+		log.Infow("broadcast cooperative close", "chanId", msg.ChannelID.Hex(), "txHash", tx.Hash().Hex())
+
+		res = &wire.ClosingSigned{
+			ChannelID: msg.ChannelID,
+			Sig:       ourSig,
+		}
+	}
+
+	// the channel is marked closed by OnPoll once the close is on-chain
+	c.mtx.Lock()
+	delete(c.closingChannels, msg.ChannelID)
+	c.mtx.Unlock()
+
+	return res, nil
+}
+
+// IsClosing reports whether a cooperative close of the channel is under way.
+// Its commitments must not be updated until the close completes or is
+// abandoned.
+func (c *ChannelHandler) IsClosing(chanId common.Hash) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.expireClosing(time.Now())
+	_, exists := c.closingChannels[chanId]
+	return exists
+}
+
+// OnDisconnect abandons the cooperative closes the peer was part of, since
+// neither side resumes a close after reconnecting.
+func (c *ChannelHandler) OnDisconnect(peer *p2p.Peer, reason error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for id, closing := range c.closingChannels {
+		if closing.Counterparty.Equal(peer.Identity) {
+			delete(c.closingChannels, id)
+		}
+	}
+}
+
+// expireClosing abandons cooperative closes the peer hasn't finished within
+// closingChannelExpiry. c.mtx must be held.
+func (c *ChannelHandler) expireClosing(now time.Time) {
+	for id, closing := range c.closingChannels {
+		if now.Sub(closing.CreatedAt) >= closingChannelExpiry {
+			log.Infow("abandoning cooperative close", "chanId", id.Hex())
+			delete(c.closingChannels, id)
+		}
+	}
+}
+
+// OnPoll marks channels closed once the chainsaw sees their funding output
+// spent, whether by a cooperative close or a broadcast commitment.
+func (c *ChannelHandler) OnPoll(outputs *db.PolledOutputs) {
+	for _, id := range outputs.Spent {
+		channel, err := c.db.Channels.FindByFundingOutput(id)
+		if err != nil {
+			log.Errorw("failed to look up channel by funding output", "outputId", id.Hex(), "err", err.Error())
+			continue
+		}
+		if channel == nil || channel.IsClosed {
+			continue
+		}
+
+		if err := c.db.Channels.MarkClosed(channel.ID); err != nil {
+			log.Errorw("failed to mark channel closed", "chanId", channel.ID.Hex(), "err", err.Error())
+			continue
+		}
+		log.Infow("channel closed on-chain", "chanId", channel.ID.Hex())
+	}
+
+	c.sweepDelayed()
+}
+
+// ForceCloseChannel broadcasts our latest commitment without the peer's
+// cooperation. Our balance is swept by sweepDelayed once its delay has passed,
+// and the channel is marked closed by OnPoll once the commitment is on-chain.
+func (c *ChannelHandler) ForceCloseChannel(chanId common.Hash) error {
+	channel, err := c.db.Channels.FindById(chanId)
+	if err != nil {
+		return err
+	}
+	if channel == nil {
+		return errors.New("no channel with that id found")
+	}
+	if channel.IsClosed {
+		return errors.New("channel is already closed")
+	}
+	if len(channel.TheirCommitSig) == 0 {
+		return errors.New("no signed commitment to broadcast")
+	}
+
+	htlcs, err := c.db.Channels.FindHTLCs(chanId)
+	if err != nil {
+		return err
+	}
+
+	us := c.km.PublicKey().ETHAddress()
+	req, err := ourCommitment(channel, htlcs, us)
+	if err != nil {
+		return err
+	}
+	sigHash, err := txout.SigData(req)
+	if err != nil {
+		return err
+	}
+	ourSig, err := c.km.SignData(sigHash)
+	if err != nil {
+		return err
+	}
+
+	aliceSig, bobSig := ourSig, channel.TheirCommitSig
+	if alice, _ := eth.SortAddresses(us, channel.Counterparty); alice != us {
+		aliceSig, bobSig = channel.TheirCommitSig, ourSig
+	}
+
+	tx, err := c.client.SpendMultisig(req, aliceSig, bobSig)
+	if err != nil {
+		return err
+	}
+	log.Infow("broadcast commitment", "chanId", chanId.Hex(), "commitmentNumber", channel.CommitmentNumber, "txHash", tx.Hash().Hex())
+
+	if err := c.saveDelayedSweep(chanId, req); err != nil {
+		return err
+	}
+	go c.awaitCommitment(chanId, req, tx)
+	return nil
+}
+
+// awaitCommitment drops the delayed sweep of a commitment we broadcast if the
+// commitment reverts or is dropped, since its output will never exist. The
+// sweep is saved before the commitment is mined so that it survives a
+// restart while the commitment is pending.
+func (c *ChannelHandler) awaitCommitment(chanId common.Hash, commitment *txout.SpendRequest, tx *ethclient.PendingTx) {
+	_, err := tx.Wait(context.Background())
+	if err == nil {
+		return
+	}
+
+	log.Warnw("commitment did not go through", "chanId", chanId.Hex(), "txHash", tx.Hash().Hex(), "err", err.Error())
+	outputId, err := delayedOutput(commitment)
+	if err != nil {
+		log.Errorw("failed to find delayed output", "chanId", chanId.Hex(), "err", err.Error())
+		return
+	}
+	if outputId == nil {
+		return
+	}
+	if err := c.db.DelayedSweeps.Delete(*outputId); err != nil {
+		log.Errorw("failed to delete delayed sweep", "chanId", chanId.Hex(), "err", err.Error())
+	}
+}
+
+// saveDelayedSweep records our balance on a commitment we broadcast, so that
+// sweepDelayed can claim it once the commitment is on-chain and its delay has
+// passed, even across restarts.
+func (c *ChannelHandler) saveDelayedSweep(chanId common.Hash, commitment *txout.SpendRequest) error {
+	outputId, err := delayedOutput(commitment)
+	if err != nil {
+		return err
+	}
+	if outputId == nil {
+		log.Infow("no delayed output to sweep", "chanId", chanId.Hex())
+		return nil
+	}
+
+	return c.db.DelayedSweeps.Save(&db.DelayedSweep{
+		OutputID:  *outputId,
+		ChannelID: chanId,
+	})
+}
+
+// delayedOutput returns the ID of our delayed output on a commitment, or nil
+// if the commitment leaves us no balance.
+func delayedOutput(commitment *txout.SpendRequest) (*common.Hash, error) {
+	outputIds, err := txout.GenOutputIDs(commitment)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, out := range commitment.Outputs {
+		if _, ok := out.(*txout.CommitmentLocal); ok {
+			return &outputIds[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// sweepDelayed claims the delayed outputs of commitments we broadcast once
+// their delay has passed. It runs on every poll, since outputs become
+// sweepable as blocks pass.
+func (c *ChannelHandler) sweepDelayed() {
+	sweeps, err := c.db.DelayedSweeps.FindUnswept()
+	if err != nil {
+		log.Errorw("failed to find delayed sweeps", "err", err.Error())
+		return
+	}
+	if len(sweeps) == 0 {
+		return
+	}
+
+	height, err := c.client.BlockHeight()
+	if err != nil {
+		log.Errorw("failed to get block height", "err", err.Error())
+		return
+	}
+
+	for _, sweep := range sweeps {
+		c.mtx.Lock()
+		inFlight := c.sweeping[sweep.OutputID]
+		c.mtx.Unlock()
+		if inFlight {
+			continue
+		}
+
+		if err := c.checkDelayedSweep(sweep, height); err != nil {
+			log.Errorw("failed to sweep delayed output", "chanId", sweep.ChannelID.Hex(), "outputId", sweep.OutputID.Hex(),
+				"err", err.Error())
+		}
+	}
+}
+
+func (c *ChannelHandler) checkDelayedSweep(sweep *db.DelayedSweep, height uint64) error {
+	output, err := c.db.Outputs.FindById(sweep.OutputID)
+	if err != nil {
+		return err
+	}
+	if output == nil {
+		channel, err := c.db.Channels.FindById(sweep.ChannelID)
+		if err != nil {
+			return err
+		}
+		// the funding output went to some other spend, so our commitment
+		// will never make it on-chain
+		if channel != nil && channel.IsClosed {
+			log.Infow("channel closed without our commitment, dropping sweep", "chanId", sweep.ChannelID.Hex())
+			return c.db.DelayedSweeps.Delete(sweep.OutputID)
+		}
+		return nil
+	}
+	if output.IsSpent {
+		sweep.Swept = true
+		return c.db.DelayedSweeps.Save(sweep)
+	}
+
+	local := &txout.CommitmentLocal{}
+	if err := local.Decode(bytes.NewReader(output.Script[1:]), 0); err != nil {
+		return err
+	}
+	// the contract refuses the sweep until a block after the delay
+	if height <= output.BlockNumber+local.Delay.Uint64() {
+		return nil
+	}
+
+	req := &txout.SpendRequest{
+		InputID: output.ID,
+		Witness: txout.NewCommitmentLocalWitness(txout.CommitmentLocalDelayed),
+		Values: []*big.Int{
+			output.Amount,
+		},
+		Outputs: []txout.Output{
+			txout.NewPayment(local.DelayedAddress),
+		},
+	}
+	sigHash, err := txout.SigData(req)
+	if err != nil {
+		return err
+	}
+	sig, err := c.km.SignData(sigHash)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.client.Spend(req, sig)
+	if err != nil {
+		return err
+	}
+
+	log.Infow("broadcast delayed sweep", "chanId", sweep.ChannelID.Hex(), "txHash", tx.Hash().Hex())
+	c.mtx.Lock()
+	c.sweeping[sweep.OutputID] = true
+	c.mtx.Unlock()
+	go c.awaitDelayedSweep(sweep, tx)
+	return nil
+}
+
+// awaitDelayedSweep records a sweep once it is mined. One that fails or takes
+// longer than sweepTimeout is retried on a later poll.
+func (c *ChannelHandler) awaitDelayedSweep(sweep *db.DelayedSweep, tx *ethclient.PendingTx) {
+	defer func() {
+		c.mtx.Lock()
+		delete(c.sweeping, sweep.OutputID)
+		c.mtx.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sweepTimeout)
+	defer cancel()
+	receipt, err := tx.Wait(ctx)
+	if err != nil {
+		log.Warnw("delayed sweep did not go through", "chanId", sweep.ChannelID.Hex(), "txHash", tx.Hash().Hex(), "err", err.Error())
+		return
+	}
+
+	log.Infow("swept delayed output", "chanId", sweep.ChannelID.Hex(), "txHash", receipt.TxHash.Hex())
+	sweep.TxHash = receipt.TxHash
+	sweep.Swept = true
+	if err := c.db.DelayedSweeps.Save(sweep); err != nil {
+		log.Errorw("failed to save delayed sweep", "chanId", sweep.ChannelID.Hex(), "err", err.Error())
+	}
+}
+
+func (c *ChannelHandler) closableChannel(chanId common.Hash) (*db.ETHChannel, error) {
+	channel, err := c.db.Channels.FindById(chanId)
+	if err != nil {
+		return nil, err
+	}
+	if channel == nil {
+		return nil, errors.New("no channel with that id found")
+	}
+	if channel.IsClosed {
+		return nil, errors.New("channel is already closed")
+	}
+
+	htlcs, err := c.db.Channels.FindHTLCs(chanId)
+	if err != nil {
+		return nil, err
+	}
+	for _, htlc := range htlcs {
+		if !htlc.Fulfilled && !htlc.Failed {
+			return nil, errors.New("channel has pending htlcs")
+		}
+	}
+
+	return channel, nil
+}
+
+// genClosingSpendRequest pays each party its latest balance out of the
+// funding multisig, ordered by address so both sides sign the same data. A
+// party with nothing left gets no output.
+func genClosingSpendRequest(channel *db.ETHChannel, us common.Address) *txout.SpendRequest {
+	alice, bob := eth.SortAddresses(us, channel.Counterparty)
+	balances := map[common.Address]*big.Int{
+		us:                   channel.LocalBalance,
+		channel.Counterparty: channel.RemoteBalance,
+	}
+
+	var values []*big.Int
+	var outputs []txout.Output
+	for _, addr := range []common.Address{alice, bob} {
+		if balances[addr].Sign() <= 0 {
+			continue
+		}
+
+		values = append(values, balances[addr])
+		outputs = append(outputs, txout.NewPayment(addr))
+	}
+
+	return &txout.SpendRequest{
+		InputID: channel.FundingOutput,
+		Witness: txout.NewMultisigWitness(),
+		Values:  values,
+		Outputs: outputs,
+	}
+}
@@ -17,6 +17,13 @@ import (
 	"time"
 	)
 
+// maxPendingChannelsPerPeer caps the channel negotiations a single peer can
+// have open with us before either side has signed the funding.
+const maxPendingChannelsPerPeer = 5
+
+// pendingChannelExpiry is how long an unsigned negotiation is kept around.
+const pendingChannelExpiry = time.Minute * 10
+
 type ChannelHandler struct {
 	peerBook           *p2p.PeerBook
 	km                 *wallet.KeyManager
@@ -24,6 +31,8 @@ type ChannelHandler struct {
 	db                 *db.DB
 	pendingChannels    map[common.Hash]*pendingChannel
 	finalizingChannels map[common.Hash]*pendingChannel
+	closingChannels    map[common.Hash]*closingChannel
+	sweeping           map[common.Hash]bool
 	mtx                sync.Mutex
 }
 
@@ -35,9 +44,16 @@ type pendingChannel struct {
 	OurFundingKey    *crypto.PublicKey
 	TheirFundingKey  *crypto.PublicKey
 	OurSignature     crypto.Signature
+	TheirSignature   crypto.Signature
 	SentLocked       bool
 	ReceivedLocked   bool
 	FundingOutput common.Hash
+	Counterparty     *crypto.PublicKey
+	CreatedAt        time.Time
+	RevocationRoot   common.Hash
+	TheirFirstPoint  *crypto.PublicKey
+	TheirCommitSig   crypto.Signature
+	FundingTx        common.Hash
 }
 
 func NewChannelHandler(peerBook *p2p.PeerBook, km *wallet.KeyManager, client *ethclient.Client, db *db.DB) *ChannelHandler {
@@ -48,10 +64,12 @@ func NewChannelHandler(peerBook *p2p.PeerBook, km *wallet.KeyManager, client *et
 		db:                 db,
 		pendingChannels:    make(map[common.Hash]*pendingChannel),
 		finalizingChannels: make(map[common.Hash]*pendingChannel),
+		closingChannels:    make(map[common.Hash]*closingChannel),
+		sweeping:           make(map[common.Hash]bool),
 	}
 }
 
-func (c *ChannelHandler) InitChannel(pub *crypto.PublicKey, amount *big.Int) error {
+func (c *ChannelHandler) InitChannel(ctx context.Context, pub *crypto.PublicKey, amount *big.Int) error {
 	peer := c.peerBook.FindPeer(pub)
 	if peer == nil {
 		return errors.New("peer not found")
@@ -61,75 +79,128 @@ func (c *ChannelHandler) InitChannel(pub *crypto.PublicKey, amount *big.Int) err
 	if err != nil {
 		return err
 	}
+	root, err := crypto.Rand32()
+	if err != nil {
+		return err
+	}
+	point, err := commitmentPoint(root, 0)
+	if err != nil {
+		return err
+	}
 
 	msg := &wire.OpenChannel{
-		PendingChannelID: cId,
-		FundingAmount:    amount,
-		CsvDelay:         7,
-		MaxAcceptedHTLCs: 2,
-		FundingKey:       c.km.PublicKey(),
+		PendingChannelID:     cId,
+		FundingAmount:        amount,
+		CsvDelay:             csvDelay,
+		MaxAcceptedHTLCs:     2,
+		FundingKey:           c.km.PublicKey(),
+		FirstCommitmentPoint: point,
 	}
 
-	c.mtx.Lock()
-	c.pendingChannels[msg.PendingChannelID] = &pendingChannel{
+	pending := &pendingChannel{
 		PendingChannelID: msg.PendingChannelID,
 		FundingAmount:    amount,
 		OurFundingKey:    msg.FundingKey,
+		Counterparty:     pub,
+		CreatedAt:        time.Now(),
+		RevocationRoot:   root,
+	}
+	err = c.persist(pending)
+	if err != nil {
+		return err
 	}
+
+	c.mtx.Lock()
+	c.pendingChannels[msg.PendingChannelID] = pending
 	c.mtx.Unlock()
 
-	return peer.Send(msg)
+	return peer.Send(ctx, msg)
 }
 
 func (c *ChannelHandler) CanAccept(msg lnwire.Message) bool {
 	switch msg.MsgType() {
-	case wire.MsgOpenChannel, wire.MsgAcceptChannel, wire.MsgFundingCreated, wire.MsgFundingSigned, wire.MsgFundingLocked:
+	case wire.MsgOpenChannel, wire.MsgAcceptChannel, wire.MsgFundingCreated, wire.MsgFundingSigned, wire.MsgFundingLocked,
+		wire.MsgShutdown, wire.MsgClosingSigned:
 		return true
 	default:
 		return false
 	}
 }
 
-func (c *ChannelHandler) Accept(envelope *p2p.Envelope) (lnwire.Message, error) {
+func (c *ChannelHandler) Accept(envelope *p2p.Envelope) ([]lnwire.Message, error) {
 	msg := envelope.Msg
 	switch msg.MsgType() {
 	case wire.MsgOpenChannel:
-		return c.onOpenChannel(msg.(*wire.OpenChannel))
+		return p2p.Reply(c.onOpenChannel(msg.(*wire.OpenChannel), envelope.Peer))
 	case wire.MsgAcceptChannel:
-		return c.onAcceptChannel(msg.(*wire.AcceptChannel))
+		return p2p.Reply(c.onAcceptChannel(msg.(*wire.AcceptChannel)))
 	case wire.MsgFundingCreated:
-		return c.onFundingCreated(msg.(*wire.FundingCreated))
+		return p2p.Reply(c.onFundingCreated(msg.(*wire.FundingCreated)))
 	case wire.MsgFundingSigned:
-		return c.onFundingSigned(msg.(*wire.FundingSigned))
+		return p2p.Reply(c.onFundingSigned(msg.(*wire.FundingSigned), envelope))
 	case wire.MsgFundingLocked:
-		return c.onFundingLocked(msg.(*wire.FundingLocked))
+		return p2p.Reply(c.onFundingLocked(msg.(*wire.FundingLocked)))
+	case wire.MsgShutdown:
+		return p2p.Reply(c.onShutdown(msg.(*wire.Shutdown), envelope.Peer))
+	case wire.MsgClosingSigned:
+		return p2p.Reply(c.onClosingSigned(msg.(*wire.ClosingSigned)))
 	default:
 		return nil, errors.New("unknown message type")
 	}
 }
 
-func (c *ChannelHandler) onOpenChannel(msg *wire.OpenChannel) (lnwire.Message, error) {
+func (c *ChannelHandler) onOpenChannel(msg *wire.OpenChannel, peer *p2p.Peer) (lnwire.Message, error) {
 	c.mtx.Lock()
 	_, exists := c.pendingChannels[msg.PendingChannelID]
 	if exists {
 		defer c.mtx.Unlock()
-		return nil, errors.New("duplicate pending channel id")
+		return nil, p2p.Misbehaving(p2p.ScoreMinor, errors.New("duplicate pending channel id"))
+	}
+
+	expired := c.expirePending(time.Now())
+	if c.countUnsigned(peer.Identity) >= maxPendingChannelsPerPeer {
+		c.mtx.Unlock()
+		c.abandonAll(expired)
+		return nil, p2p.Misbehaving(p2p.ScoreMinor, errors.New("too many pending channels"))
+	}
+
+	root, err := crypto.Rand32()
+	if err != nil {
+		c.mtx.Unlock()
+		return nil, err
+	}
+	point, err := commitmentPoint(root, 0)
+	if err != nil {
+		c.mtx.Unlock()
+		return nil, err
 	}
 
 	ourKey := c.km.PublicKey()
-	c.pendingChannels[msg.PendingChannelID] = &pendingChannel{
+	pending := &pendingChannel{
 		PendingChannelID: msg.PendingChannelID,
 		FundingAmount:    msg.FundingAmount,
 		TheirFundingKey:  msg.FundingKey,
 		OurFundingKey:    ourKey,
+		Counterparty:     peer.Identity,
+		CreatedAt:        time.Now(),
+		RevocationRoot:   root,
+		TheirFirstPoint:  msg.FirstCommitmentPoint,
 	}
+	c.pendingChannels[msg.PendingChannelID] = pending
 	c.mtx.Unlock()
+	c.abandonAll(expired)
+
+	err = c.persist(pending)
+	if err != nil {
+		return nil, err
+	}
 
 	res := &wire.AcceptChannel{
-		PendingChannelID: msg.PendingChannelID,
-		CsvDelay:         msg.CsvDelay,
-		MaxAcceptedHTLCs: msg.MaxAcceptedHTLCs,
-		FundingKey:       ourKey,
+		PendingChannelID:     msg.PendingChannelID,
+		CsvDelay:             msg.CsvDelay,
+		MaxAcceptedHTLCs:     msg.MaxAcceptedHTLCs,
+		FundingKey:           ourKey,
+		FirstCommitmentPoint: point,
 	}
 
 	return res, nil
@@ -143,6 +214,7 @@ func (c *ChannelHandler) onAcceptChannel(msg *wire.AcceptChannel) (lnwire.Messag
 		return nil, errors.New("no channel with that pending id found")
 	}
 	pending.TheirFundingKey = msg.FundingKey
+	pending.TheirFirstPoint = msg.FirstCommitmentPoint
 	c.mtx.Unlock()
 
 	paymentScript := txout.NewPayment(c.km.PublicKey().ETHAddress())
@@ -170,15 +242,27 @@ func (c *ChannelHandler) onAcceptChannel(msg *wire.AcceptChannel) (lnwire.Messag
 		return nil, err
 	}
 	chanId := finalizeChannelID(outputId)
+	pending.FundingOutput = outputId
 	c.finalizingChannels[chanId] = pending
 	pending.ChannelID = chanId
 	pending.OurSignature = sig
 	c.mtx.Unlock()
 
+	commitSig, err := c.signInitialCommitment(pending)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.persist(pending)
+	if err != nil {
+		return nil, err
+	}
+
 	return &wire.FundingCreated{
 		PendingChannelID: pending.PendingChannelID,
 		InputID:          input.ID,
 		Sig:              sig,
+		CommitSig:        commitSig,
 	}, nil
 }
 
@@ -202,12 +286,9 @@ func (c *ChannelHandler) onFundingCreated(msg *wire.FundingCreated) (lnwire.Mess
 	}
 	ok := msg.Sig.Verify(sigHash, pending.TheirFundingKey)
 	if !ok {
-		return nil, errors.New("signature verification failed")
+		return nil, p2p.Misbehaving(p2p.ScoreSevere, errors.New("signature verification failed"))
 	}
 
-	// TODO: set up commitment transaction so allow for non-cooperative exit
-	// for now just sign the sigHash myself and generate the chan ID
-
 	sig, err := c.km.SignData(sigHash)
 	if err != nil {
 		return nil, err
@@ -222,17 +303,39 @@ func (c *ChannelHandler) onFundingCreated(msg *wire.FundingCreated) (lnwire.Mess
 	}
 	chanId := finalizeChannelID(outputId)
 	pending.FundingOutput = outputId
-	c.finalizingChannels[chanId] = pending
 	pending.ChannelID = chanId
 	c.mtx.Unlock()
 
+	// both commitment 0s are signed before the funder can broadcast, so
+	// neither side ever has funds in the multisig it can't force close on
+	err = c.verifyInitialCommitment(pending, msg.CommitSig)
+	if err != nil {
+		return nil, err
+	}
+	commitSig, err := c.signInitialCommitment(pending)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	pending.TheirSignature = msg.Sig
+	pending.TheirCommitSig = msg.CommitSig
+	c.finalizingChannels[chanId] = pending
+	c.mtx.Unlock()
+
+	err = c.persist(pending)
+	if err != nil {
+		return nil, err
+	}
+
 	return &wire.FundingSigned{
 		ChannelID: chanId,
 		Sig:       sig,
+		CommitSig: commitSig,
 	}, nil
 }
 
-func (c *ChannelHandler) onFundingSigned(msg *wire.FundingSigned) (lnwire.Message, error) {
+func (c *ChannelHandler) onFundingSigned(msg *wire.FundingSigned, envelope *p2p.Envelope) (lnwire.Message, error) {
 	c.mtx.Lock()
 	finalizing, exists := c.finalizingChannels[msg.ChannelID]
 	if !exists {
@@ -252,7 +355,20 @@ func (c *ChannelHandler) onFundingSigned(msg *wire.FundingSigned) (lnwire.Messag
 	}
 	ok := msg.Sig.Verify(sigHash, finalizing.TheirFundingKey)
 	if !ok {
-		return nil, errors.New("signature verification failed")
+		return nil, p2p.Misbehaving(p2p.ScoreSevere, errors.New("signature verification failed"))
+	}
+	err = c.verifyInitialCommitment(finalizing, msg.CommitSig)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	finalizing.TheirSignature = msg.Sig
+	finalizing.TheirCommitSig = msg.CommitSig
+	c.mtx.Unlock()
+	err = c.persist(finalizing)
+	if err != nil {
+		return nil, err
 	}
 
 	spendReq := genSpendRequest(
@@ -261,36 +377,71 @@ func (c *ChannelHandler) onFundingSigned(msg *wire.FundingSigned) (lnwire.Messag
 		finalizing.OurFundingKey.ETHAddress(),
 		finalizing.TheirFundingKey.ETHAddress(),
 	)
-	_, err = c.client.DepositMultisig(spendReq, finalizing.OurSignature)
-
 	outputIds, err := txout.GenOutputIDs(spendReq)
 	if err != nil {
 		return nil, err
 	}
 	outputId := outputIds[0]
-	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute*5)
-	defer cancel()
-	_, err = ethclient.AwaitOutput(ctx, c.db, outputId)
+
+	funding, err := c.client.DepositMultisig(spendReq, finalizing.OurSignature)
 	if err != nil {
 		return nil, err
 	}
+	log.Infow("broadcast channel funding", "chanId", finalizing.ChannelID.Hex(), "txHash", funding.Hash().Hex())
 
 	c.mtx.Lock()
-	finalizing.SentLocked = true
+	finalizing.FundingTx = funding.Hash()
 	c.mtx.Unlock()
+	if err := c.persist(finalizing); err != nil {
+		return nil, err
+	}
 
-	err = c.db.Channels.Save(&db.ETHChannel{
-		ID: finalizing.ChannelID,
-		FundingOutput: outputId,
-		Counterparty: finalizing.TheirFundingKey.ETHAddress(),
+	envelope.Go(func(ctx context.Context, sender p2p.Sender) {
+		if err := c.lockFunding(ctx, sender, finalizing, funding, outputId); err != nil {
+			log.Errorw("failed to lock funding", "chanId", finalizing.ChannelID.Hex(), "err", err.Error())
+		}
 	})
+
+	return nil, nil
+}
+
+// lockFunding sends funding_locked once our deposit into the multisig is
+// mined and indexed. This can take minutes, so it runs off the reactor.
+func (c *ChannelHandler) lockFunding(ctx context.Context, sender p2p.Sender, finalizing *pendingChannel, funding *ethclient.PendingTx, outputId common.Hash) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+	_, err := funding.Wait(ctx)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	_, err = ethclient.AwaitOutput(ctx, c.client, c.db, outputId)
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	finalizing.SentLocked = true
+	c.mtx.Unlock()
+
+	channel, err := c.saveChannel(finalizing, nil)
+	if err != nil {
+		return err
+	}
+
+	err = c.persist(finalizing)
+	if err != nil {
+		return err
 	}
 
-	return &wire.FundingLocked{
-		ChannelID: finalizing.ChannelID,
-	}, err
+	point, err := commitmentPoint(channel.RevocationRoot, 1)
+	if err != nil {
+		return err
+	}
+
+	return sender.Send(ctx, &wire.FundingLocked{
+		ChannelID:              finalizing.ChannelID,
+		NextPerCommitmentPoint: point,
+	})
 }
 
 func (c *ChannelHandler) onFundingLocked(msg *wire.FundingLocked) (lnwire.Message, error) {
@@ -305,25 +456,415 @@ func (c *ChannelHandler) onFundingLocked(msg *wire.FundingLocked) (lnwire.Messag
 	var res lnwire.Message
 
 	if !finalizing.SentLocked {
+		channel, err := c.saveChannel(finalizing, msg.NextPerCommitmentPoint)
+		if err != nil {
+			return nil, err
+		}
+
+		point, err := commitmentPoint(channel.RevocationRoot, 1)
+		if err != nil {
+			return nil, err
+		}
+
 		res = &wire.FundingLocked{
-			ChannelID: finalizing.ChannelID,
+			ChannelID:              finalizing.ChannelID,
+			NextPerCommitmentPoint: point,
+		}
+	} else {
+		channel, err := c.db.Channels.FindById(finalizing.ChannelID)
+		if err != nil {
+			return nil, err
+		}
+		if channel == nil {
+			return nil, errors.New("locked channel not found")
 		}
 
-		c.db.Channels.Save(&db.ETHChannel{
-			ID: finalizing.ChannelID,
-			FundingOutput: finalizing.FundingOutput,
-			Counterparty: finalizing.TheirFundingKey.ETHAddress(),
-		})
+		channel.TheirNextRevocationPoint = msg.NextPerCommitmentPoint
+		err = c.db.Channels.UpdateState(channel, nil)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	c.mtx.Lock()
+	finalizing.ReceivedLocked = true
 	delete(c.pendingChannels, finalizing.PendingChannelID)
 	delete(c.finalizingChannels, finalizing.ChannelID)
 	c.mtx.Unlock()
 
+	err := c.db.PendingChannels.Delete(finalizing.PendingChannelID)
+	if err != nil {
+		return nil, err
+	}
+
 	return res, nil
 }
 
+// Resume reloads channel negotiations that were in flight when the node last
+// shut down and reconciles each of them against the indexed outputs.
+func (c *ChannelHandler) Resume() error {
+	records, err := c.db.PendingChannels.FindAll()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		pending := pendingChannelFromRecord(record)
+		if err := c.reconcile(pending); err != nil {
+			log.Errorw("failed to reconcile pending channel", "pendingChanId", pending.PendingChannelID.Hex(), "err", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// reconcile decides whether a persisted negotiation can be finished or must be
+// abandoned:
+//
+// - negotiations that never reached FundingCreated/FundingSigned have nothing
+//   on-chain and are abandoned.
+// - if the multisig funding output has been indexed, the channel is finished.
+// - if the funding input is still unspent, the funder rebroadcasts once it holds
+//   the counterparty's signature, unless the funding it sent before is still
+//   pending or already mined, and the counterparty waits for that broadcast.
+// - anything else is abandoned.
+func (c *ChannelHandler) reconcile(pending *pendingChannel) error {
+	var zero common.Hash
+	if pending.TheirFundingKey == nil || pending.ChannelID == zero {
+		return c.abandon(pending)
+	}
+
+	outputId, err := genMultisigId(pending)
+	if err != nil {
+		return err
+	}
+	pending.FundingOutput = outputId
+
+	output, err := c.db.Outputs.FindById(outputId)
+	if err != nil {
+		return err
+	}
+	if output != nil {
+		return c.finish(pending)
+	}
+
+	input, err := c.db.Outputs.FindById(pending.InputID)
+	if err != nil {
+		return err
+	}
+	if input == nil || input.IsSpent || len(pending.TheirSignature) == 0 {
+		return c.abandon(pending)
+	}
+
+	c.mtx.Lock()
+	c.pendingChannels[pending.PendingChannelID] = pending
+	c.finalizingChannels[pending.ChannelID] = pending
+	c.mtx.Unlock()
+
+	isFunder := len(pending.OurSignature) > 0
+	if isFunder {
+		rebroadcast, err := c.needsRebroadcast(pending)
+		if err != nil {
+			return err
+		}
+		if rebroadcast {
+			spendReq := genSpendRequest(
+				pending.InputID,
+				pending.FundingAmount,
+				pending.OurFundingKey.ETHAddress(),
+				pending.TheirFundingKey.ETHAddress(),
+			)
+			funding, err := c.client.DepositMultisig(spendReq, pending.OurSignature)
+			if err != nil {
+				return err
+			}
+			log.Infow("rebroadcast channel funding", "chanId", pending.ChannelID.Hex(), "txHash", funding.Hash().Hex())
+
+			c.mtx.Lock()
+			pending.FundingTx = funding.Hash()
+			c.mtx.Unlock()
+			if err := c.persist(pending); err != nil {
+				return err
+			}
+		}
+	}
+
+	go c.awaitFunding(pending)
+	return nil
+}
+
+// needsRebroadcast reports whether the funding we sent before restarting is
+// gone. A pending one is rebroadcast by the TxManager, and a mined one only
+// has to be indexed.
+func (c *ChannelHandler) needsRebroadcast(pending *pendingChannel) (bool, error) {
+	if pending.FundingTx == (common.Hash{}) {
+		return true, nil
+	}
+
+	record, err := c.db.Transactions.FindByHash(c.km.PublicKey().ETHAddress(), pending.FundingTx)
+	if err != nil {
+		return false, err
+	}
+	if record == nil {
+		return true, nil
+	}
+
+	switch record.Status {
+	case db.ETHTransactionPending, db.ETHTransactionMined:
+		log.Infow("funding already sent, not rebroadcasting", "chanId", pending.ChannelID.Hex(),
+			"txHash", record.Hash.Hex(), "status", string(record.Status))
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+func (c *ChannelHandler) awaitFunding(pending *pendingChannel) {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute*5)
+	defer cancel()
+
+	_, err := ethclient.AwaitOutput(ctx, c.client, c.db, pending.FundingOutput)
+	if err == nil {
+		err = c.finish(pending)
+	} else {
+		c.mtx.Lock()
+		delete(c.pendingChannels, pending.PendingChannelID)
+		delete(c.finalizingChannels, pending.ChannelID)
+		c.mtx.Unlock()
+		err = c.abandon(pending)
+	}
+
+	if err != nil {
+		log.Errorw("failed to reconcile pending channel", "pendingChanId", pending.PendingChannelID.Hex(), "err", err.Error())
+	}
+}
+
+// finish records the channel and keeps the negotiation in memory with
+// SentLocked set, so that a FundingLocked from the peer still completes it.
+func (c *ChannelHandler) finish(pending *pendingChannel) error {
+	existing, err := c.db.Channels.FindById(pending.ChannelID)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		_, err = c.saveChannel(pending, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.mtx.Lock()
+	pending.SentLocked = true
+	c.pendingChannels[pending.PendingChannelID] = pending
+	c.finalizingChannels[pending.ChannelID] = pending
+	c.mtx.Unlock()
+
+	log.Infow("finished pending channel", "chanId", pending.ChannelID.Hex())
+	return c.db.PendingChannels.Delete(pending.PendingChannelID)
+}
+
+// Fail abandons a channel negotiation the peer gave up on. Once either funding
+// signature has been sent the deposit can still be broadcast, so from then on
+// we keep waiting for it instead. Errors from anyone but the channel's
+// counterparty are ignored.
+func (c *ChannelHandler) Fail(peer *crypto.PublicKey, id common.Hash, reason string) {
+	c.mtx.Lock()
+	if closing, exists := c.closingChannels[id]; exists && closing.Counterparty.Equal(peer) {
+		delete(c.closingChannels, id)
+	}
+	pending, exists := c.pendingChannels[id]
+	if !exists {
+		pending, exists = c.finalizingChannels[id]
+	}
+	if !exists || pending.Counterparty == nil || !pending.Counterparty.Equal(peer) {
+		c.mtx.Unlock()
+		return
+	}
+	if isSigned(pending) {
+		c.mtx.Unlock()
+		log.Warnw("peer failed channel after funding was signed, continuing", "pendingChanId", pending.PendingChannelID.Hex(),
+			"reason", reason)
+		return
+	}
+	delete(c.pendingChannels, pending.PendingChannelID)
+	delete(c.finalizingChannels, pending.ChannelID)
+	c.mtx.Unlock()
+
+	if err := c.abandon(pending); err != nil {
+		log.Errorw("failed to abandon pending channel", "pendingChanId", pending.PendingChannelID.Hex(), "err", err.Error())
+	}
+}
+
+// expirePending removes negotiations nobody has signed within
+// pendingChannelExpiry and returns them for abandoning. c.mtx must be held.
+func (c *ChannelHandler) expirePending(now time.Time) []*pendingChannel {
+	var expired []*pendingChannel
+	for id, pending := range c.pendingChannels {
+		if isSigned(pending) || now.Sub(pending.CreatedAt) < pendingChannelExpiry {
+			continue
+		}
+
+		delete(c.pendingChannels, id)
+		delete(c.finalizingChannels, pending.ChannelID)
+		expired = append(expired, pending)
+	}
+
+	return expired
+}
+
+// countUnsigned returns how many unsigned negotiations peer has open with us.
+// c.mtx must be held.
+func (c *ChannelHandler) countUnsigned(peer *crypto.PublicKey) int {
+	count := 0
+	for _, pending := range c.pendingChannels {
+		if !isSigned(pending) && pending.Counterparty != nil && pending.Counterparty.Equal(peer) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (c *ChannelHandler) abandonAll(pending []*pendingChannel) {
+	for _, p := range pending {
+		if err := c.abandon(p); err != nil {
+			log.Errorw("failed to abandon pending channel", "pendingChanId", p.PendingChannelID.Hex(), "err", err.Error())
+		}
+	}
+}
+
+func isSigned(pending *pendingChannel) bool {
+	return len(pending.OurSignature) > 0 || len(pending.TheirSignature) > 0
+}
+
+func (c *ChannelHandler) abandon(pending *pendingChannel) error {
+	log.Infow("abandoning pending channel", "pendingChanId", pending.PendingChannelID.Hex())
+	return c.db.PendingChannels.Delete(pending.PendingChannelID)
+}
+
+// saveChannel records a newly funded channel at commitment zero, along with
+// the point the counterparty sent for their next commitment.
+func (c *ChannelHandler) saveChannel(pending *pendingChannel, theirPoint *crypto.PublicKey) (*db.ETHChannel, error) {
+	channel := initialChannel(pending)
+	channel.TheirNextRevocationPoint = theirPoint
+	return channel, c.db.Channels.Save(channel)
+}
+
+// initialChannel returns the channel's state at commitment zero, where the
+// funder holds the entire funding amount. Each side's funding key doubles as
+// its revocation basepoint.
+func initialChannel(pending *pendingChannel) *db.ETHChannel {
+	localBalance := big.NewInt(0)
+	remoteBalance := big.NewInt(0)
+	if len(pending.OurSignature) > 0 {
+		localBalance.Set(pending.FundingAmount)
+	} else {
+		remoteBalance.Set(pending.FundingAmount)
+	}
+
+	return &db.ETHChannel{
+		ID:                       pending.ChannelID,
+		FundingOutput:            pending.FundingOutput,
+		Counterparty:             pending.TheirFundingKey.ETHAddress(),
+		CommitmentNumber:         0,
+		LocalBalance:             localBalance,
+		RemoteBalance:            remoteBalance,
+		RevocationRoot:           pending.RevocationRoot,
+		TheirCommitSig:           pending.TheirCommitSig,
+		TheirRevocationPoint:     pending.TheirFirstPoint,
+		TheirRevocationBasepoint: pending.TheirFundingKey,
+	}
+}
+
+// signInitialCommitment signs the counterparty's commitment zero.
+func (c *ChannelHandler) signInitialCommitment(pending *pendingChannel) (crypto.Signature, error) {
+	ourKey := c.km.PublicKey()
+	theirs, err := theirCommitment(initialChannel(pending), nil, ourKey.ETHAddress(), ourKey, pending.TheirFirstPoint)
+	if err != nil {
+		return nil, err
+	}
+	sigHash, err := txout.SigData(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.km.SignData(sigHash)
+}
+
+// verifyInitialCommitment checks the counterparty's signature over our
+// commitment zero.
+func (c *ChannelHandler) verifyInitialCommitment(pending *pendingChannel, sig crypto.Signature) error {
+	ours, err := ourCommitment(initialChannel(pending), nil, c.km.PublicKey().ETHAddress())
+	if err != nil {
+		return err
+	}
+	sigHash, err := txout.SigData(ours)
+	if err != nil {
+		return err
+	}
+	if !sig.Verify(sigHash, pending.TheirFundingKey) {
+		return p2p.Misbehaving(p2p.ScoreSevere, errors.New("commitment signature verification failed"))
+	}
+
+	return nil
+}
+
+// commitmentPoint returns our point for the commitment at index. The point
+// for commitment 0 is sent in open_channel or accept_channel, and the one for
+// commitment 1 in funding_locked.
+func commitmentPoint(root common.Hash, index uint64) (*crypto.PublicKey, error) {
+	point, err := wallet.CommitmentAtIndex(root[:], index)
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.PublicFromBTCEC(point)
+}
+
+func (c *ChannelHandler) persist(pending *pendingChannel) error {
+	c.mtx.Lock()
+	record := &db.PendingChannel{
+		PendingChannelID: pending.PendingChannelID,
+		ChannelID:        pending.ChannelID,
+		InputID:          pending.InputID,
+		FundingAmount:    pending.FundingAmount,
+		OurFundingKey:    pending.OurFundingKey,
+		TheirFundingKey:  pending.TheirFundingKey,
+		OurSignature:     pending.OurSignature,
+		TheirSignature:   pending.TheirSignature,
+		FundingOutput:    pending.FundingOutput,
+		SentLocked:       pending.SentLocked,
+		ReceivedLocked:   pending.ReceivedLocked,
+		RevocationRoot:   pending.RevocationRoot,
+		TheirFirstPoint:  pending.TheirFirstPoint,
+		TheirCommitSig:   pending.TheirCommitSig,
+		FundingTx:        pending.FundingTx,
+	}
+	c.mtx.Unlock()
+
+	return c.db.PendingChannels.Save(record)
+}
+
+func pendingChannelFromRecord(record *db.PendingChannel) *pendingChannel {
+	return &pendingChannel{
+		InputID:          record.InputID,
+		ChannelID:        record.ChannelID,
+		PendingChannelID: record.PendingChannelID,
+		FundingAmount:    record.FundingAmount,
+		OurFundingKey:    record.OurFundingKey,
+		TheirFundingKey:  record.TheirFundingKey,
+		OurSignature:     record.OurSignature,
+		TheirSignature:   record.TheirSignature,
+		SentLocked:       record.SentLocked,
+		ReceivedLocked:   record.ReceivedLocked,
+		FundingOutput:    record.FundingOutput,
+		RevocationRoot:   record.RevocationRoot,
+		TheirFirstPoint:  record.TheirFirstPoint,
+		TheirCommitSig:   record.TheirCommitSig,
+		FundingTx:        record.FundingTx,
+	}
+}
+
 func genMultisigId(finalizing *pendingChannel) (common.Hash, error) {
 	var res common.Hash
 	spendReq := genSpendRequest(
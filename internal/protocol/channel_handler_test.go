@@ -0,0 +1,246 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/internal/wallet"
+	"math/big"
+	"github.com/kyokan/drawbridge/pkg/txout"
+	"github.com/kyokan/drawbridge/internal/p2p"
+)
+
+type memDelayedSweeps struct {
+	sweeps map[common.Hash]*db.DelayedSweep
+}
+
+func (m *memDelayedSweeps) Save(sweep *db.DelayedSweep) error {
+	m.sweeps[sweep.OutputID] = sweep
+	return nil
+}
+
+func (m *memDelayedSweeps) Delete(outputId common.Hash) error {
+	delete(m.sweeps, outputId)
+	return nil
+}
+
+func (m *memDelayedSweeps) FindUnswept() ([]*db.DelayedSweep, error) {
+	var out []*db.DelayedSweep
+	for _, sweep := range m.sweeps {
+		if !sweep.Swept {
+			out = append(out, sweep)
+		}
+	}
+	return out, nil
+}
+
+// unindexedOutputs has indexed nothing.
+type unindexedOutputs struct {
+	db.Outputs
+}
+
+func (u *unindexedOutputs) FindById(id common.Hash) (*db.ETHOutput, error) {
+	return nil, nil
+}
+
+func TestChannelHandler_ExpirePending(t *testing.T) {
+	peer, err := crypto.RandomPublicKey()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	now := time.Now()
+	c := NewChannelHandler(nil, nil, nil, nil)
+	stale := &pendingChannel{PendingChannelID: common.Hash{1}, Counterparty: peer, CreatedAt: now.Add(-pendingChannelExpiry)}
+	fresh := &pendingChannel{PendingChannelID: common.Hash{2}, Counterparty: peer, CreatedAt: now}
+	signed := &pendingChannel{PendingChannelID: common.Hash{3}, Counterparty: peer, TheirSignature: crypto.Signature{1}}
+	for _, p := range []*pendingChannel{stale, fresh, signed} {
+		c.pendingChannels[p.PendingChannelID] = p
+	}
+
+	assert.Equal(t, 2, c.countUnsigned(peer))
+	assert.Equal(t, []*pendingChannel{stale}, c.expirePending(now))
+	assert.Equal(t, 1, c.countUnsigned(peer))
+	assert.Len(t, c.pendingChannels, 2)
+}
+
+func TestChannelHandler_FailIgnoresOtherPeers(t *testing.T) {
+	peer, err := crypto.RandomPublicKey()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	other, err := crypto.RandomPublicKey()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	c := NewChannelHandler(nil, nil, nil, nil)
+	id := common.Hash{1}
+	c.pendingChannels[id] = &pendingChannel{PendingChannelID: id, Counterparty: peer, CreatedAt: time.Now()}
+	c.closingChannels[id] = &closingChannel{ChannelID: id, Counterparty: peer}
+
+	c.Fail(other, id, "not yours")
+	assert.Len(t, c.pendingChannels, 1)
+	assert.Len(t, c.closingChannels, 1)
+
+	c.pendingChannels[id].TheirSignature = crypto.Signature{1}
+	c.Fail(peer, id, "giving up")
+	assert.Len(t, c.pendingChannels, 1)
+	assert.Empty(t, c.closingChannels)
+}
+
+func TestChannelHandler_ClosingChannelsExpire(t *testing.T) {
+	peer, err := crypto.RandomPublicKey()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	other, err := crypto.RandomPublicKey()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	c := NewChannelHandler(nil, nil, nil, nil)
+	stale := common.Hash{1}
+	fresh := common.Hash{2}
+	theirs := common.Hash{3}
+	c.closingChannels[stale] = &closingChannel{ChannelID: stale, Counterparty: peer, CreatedAt: time.Now().Add(-closingChannelExpiry)}
+	c.closingChannels[fresh] = &closingChannel{ChannelID: fresh, Counterparty: peer, CreatedAt: time.Now()}
+	c.closingChannels[theirs] = &closingChannel{ChannelID: theirs, Counterparty: other, CreatedAt: time.Now()}
+
+	assert.False(t, c.IsClosing(stale))
+	assert.True(t, c.IsClosing(fresh))
+
+	c.OnDisconnect(&p2p.Peer{Identity: peer}, nil)
+	assert.False(t, c.IsClosing(fresh))
+	assert.True(t, c.IsClosing(theirs))
+}
+
+func TestChannelHandler_NeedsRebroadcast(t *testing.T) {
+	km, err := wallet.NewKeyManager("c87509a1c067bbde78beb793e6fa76530b6382a4c0241e5e4a9ec0a0f44dc0d3", big.NewInt(1337))
+	assert.Nil(t, err)
+	txs := &memTransactions{}
+	c := NewChannelHandler(nil, km, nil, &db.DB{Transactions: txs})
+
+	pending := &pendingChannel{}
+	rebroadcast, err := c.needsRebroadcast(pending)
+	assert.Nil(t, err)
+	assert.True(t, rebroadcast)
+
+	// the funding was fee bumped since we recorded its hash
+	pending.FundingTx = common.HexToHash("0xaa")
+	tx := &db.ETHTransaction{
+		Hash:   common.HexToHash("0xbb"),
+		Hashes: []common.Hash{pending.FundingTx, common.HexToHash("0xbb")},
+		Status: db.ETHTransactionPending,
+	}
+	txs.Save(tx)
+	for status, expected := range map[db.ETHTransactionStatus]bool{
+		db.ETHTransactionPending:  false,
+		db.ETHTransactionMined:    false,
+		db.ETHTransactionReverted: true,
+		db.ETHTransactionDropped:  true,
+	} {
+		tx.Status = status
+		rebroadcast, err = c.needsRebroadcast(pending)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, rebroadcast, string(status))
+	}
+}
+
+func TestChannelHandler_OnPollMarksSpentFundingClosed(t *testing.T) {
+	funding := common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e")
+	channels := &memChannels{
+		channels: []*db.ETHChannel{{ID: common.HexToHash("0x01"), FundingOutput: funding}},
+	}
+	c := NewChannelHandler(nil, nil, nil, &db.DB{Channels: channels})
+
+	c.OnPoll(&db.PolledOutputs{Spent: []common.Hash{common.HexToHash("0x02")}})
+	assert.Empty(t, channels.closed)
+
+	c.OnPoll(&db.PolledOutputs{Spent: []common.Hash{funding}})
+	assert.Equal(t, []common.Hash{common.HexToHash("0x01")}, channels.closed)
+}
+
+func TestChannelHandler_InitialCommitmentSignatures(t *testing.T) {
+	funderKm, err := wallet.NewKeyManager("c87509a1c067bbde78beb793e6fa76530b6382a4c0241e5e4a9ec0a0f44dc0d3", big.NewInt(1337))
+	assert.Nil(t, err)
+	fundeeKm, err := wallet.NewKeyManager("ae6ae8e5ccbfb04590405997ee2d52d2b330726137b875053c36d94e974d162f", big.NewInt(1337))
+	assert.Nil(t, err)
+	funder := NewChannelHandler(nil, funderKm, nil, nil)
+	fundee := NewChannelHandler(nil, fundeeKm, nil, nil)
+
+	funderRoot := common.HexToHash("0x9a3af8416df249e86d4e508718ab492a00471ca8fb23992a5dc56a158e885cc3")
+	fundeeRoot := common.HexToHash("0x0c6d5b3ab2b7cfc41ad3b18e1a3bb07f2ed4b0e4a1e4c0b5ea5d4c3e4a4fd1c2")
+	funderPoint, err := commitmentPoint(funderRoot, 0)
+	assert.Nil(t, err)
+	fundeePoint, err := commitmentPoint(fundeeRoot, 0)
+	assert.Nil(t, err)
+
+	funding := common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e")
+	funderSide := &pendingChannel{
+		FundingOutput:   funding,
+		FundingAmount:   big.NewInt(1000),
+		OurFundingKey:   funderKm.PublicKey(),
+		TheirFundingKey: fundeeKm.PublicKey(),
+		OurSignature:    crypto.Signature{1},
+		RevocationRoot:  funderRoot,
+		TheirFirstPoint: fundeePoint,
+	}
+	fundeeSide := &pendingChannel{
+		FundingOutput:   funding,
+		FundingAmount:   big.NewInt(1000),
+		OurFundingKey:   fundeeKm.PublicKey(),
+		TheirFundingKey: funderKm.PublicKey(),
+		RevocationRoot:  fundeeRoot,
+		TheirFirstPoint: funderPoint,
+	}
+
+	toFundee, err := funder.signInitialCommitment(funderSide)
+	assert.Nil(t, err)
+	assert.Nil(t, fundee.verifyInitialCommitment(fundeeSide, toFundee))
+
+	toFunder, err := fundee.signInitialCommitment(fundeeSide)
+	assert.Nil(t, err)
+	assert.Nil(t, funder.verifyInitialCommitment(funderSide, toFunder))
+
+	// each signature only covers the commitment it was made for
+	assert.NotNil(t, funder.verifyInitialCommitment(funderSide, toFundee))
+}
+
+func TestChannelHandler_DelayedSweep(t *testing.T) {
+	us := common.HexToAddress("0x627306090abab3a6e1400e9345bc60c78a8bef57")
+	channel := &db.ETHChannel{
+		ID:            common.HexToHash("0x01"),
+		FundingOutput: common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e"),
+		Counterparty:  common.HexToAddress("0xf17f52151ebef6c7334fad080c5704d77216b732"),
+		LocalBalance:  big.NewInt(600),
+		RemoteBalance: big.NewInt(400),
+	}
+	sweeps := &memDelayedSweeps{sweeps: make(map[common.Hash]*db.DelayedSweep)}
+	channels := &memChannels{channels: []*db.ETHChannel{channel}}
+	c := NewChannelHandler(nil, nil, nil, &db.DB{
+		Outputs:       &unindexedOutputs{},
+		Channels:      channels,
+		DelayedSweeps: sweeps,
+	})
+
+	commitment := genCommitmentSpendRequest(channel, nil, us, us, common.HexToAddress("0x02"))
+	assert.Nil(t, c.saveDelayedSweep(channel.ID, commitment))
+	ids, err := txout.GenOutputIDs(commitment)
+	assert.Nil(t, err)
+	assert.Len(t, sweeps.sweeps, 1)
+	local := ids[1]
+	if _, ok := commitment.Outputs[0].(*txout.CommitmentLocal); ok {
+		local = ids[0]
+	}
+	assert.Equal(t, channel.ID, sweeps.sweeps[local].ChannelID)
+
+	// kept while the commitment may still confirm
+	assert.Nil(t, c.checkDelayedSweep(sweeps.sweeps[local], 100))
+	assert.Len(t, sweeps.sweeps, 1)
+
+	// dropped once the channel closed through some other spend
+	channel.IsClosed = true
+	assert.Nil(t, c.checkDelayedSweep(sweeps.sweeps[local], 100))
+	assert.Empty(t, sweeps.sweeps)
+}
@@ -0,0 +1,293 @@
+package protocol
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/pkg/eth"
+	"github.com/kyokan/drawbridge/pkg/txout"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"github.com/kyokan/drawbridge/internal/wallet"
+	"math/big"
+	"bytes"
+	"sort"
+	"errors"
+)
+
+// csvDelay is the number of blocks the owner of a broadcast commitment has to
+// wait before sweeping their balance, giving the other side time to punish a
+// revoked one.
+const csvDelay = 7
+
+// pendingUpdates are the updates proposed on top of a channel's latest
+// commitment. Only one side proposes at a time: while signedNext is set the
+// adds, fulfills and fails are ours, otherwise they are the counterparty's. Our own
+// updates made in the meantime wait in deferred. ignoreRemote drops the rest
+// of a proposal the counterparty made concurrently with ours.
+type pendingUpdates struct {
+	adds         []*db.ETHHTLC
+	fulfills     []*db.ETHHTLC
+	fails        []*db.ETHHTLC
+	deferred     []*db.ETHHTLC
+	signedNext   bool
+	ignoreRemote bool
+}
+
+func pendingFromRecord(record *db.ChannelUpdates) *pendingUpdates {
+	updates := &pendingUpdates{
+		deferred:   record.Deferred,
+		signedNext: record.SignedNext,
+	}
+	for _, htlc := range record.Updates {
+		updates.propose(htlc)
+	}
+	return updates
+}
+
+func (u *pendingUpdates) record(channel *db.ETHChannel) *db.ChannelUpdates {
+	var proposed []*db.ETHHTLC
+	proposed = append(proposed, u.adds...)
+	proposed = append(proposed, u.fulfills...)
+	proposed = append(proposed, u.fails...)
+	return &db.ChannelUpdates{
+		ChannelID:        channel.ID,
+		CommitmentNumber: channel.CommitmentNumber,
+		Updates:          proposed,
+		Deferred:         u.deferred,
+		SignedNext:       u.signedNext,
+	}
+}
+
+func (u *pendingUpdates) clone() *pendingUpdates {
+	out := *u
+	out.adds = append([]*db.ETHHTLC(nil), u.adds...)
+	out.fulfills = append([]*db.ETHHTLC(nil), u.fulfills...)
+	out.fails = append([]*db.ETHHTLC(nil), u.fails...)
+	out.deferred = append([]*db.ETHHTLC(nil), u.deferred...)
+	return &out
+}
+
+// propose adds update to the updates we are proposing.
+func (u *pendingUpdates) propose(update *db.ETHHTLC) {
+	switch {
+	case update.Fulfilled:
+		u.fulfills = append(u.fulfills, update)
+	case update.Failed:
+		u.fails = append(u.fails, update)
+	default:
+		u.adds = append(u.adds, update)
+	}
+}
+
+// settles reports whether the updates already fulfill or fail the HTLC.
+func (u *pendingUpdates) settles(offered bool, id uint64) bool {
+	var settling []*db.ETHHTLC
+	settling = append(settling, u.fulfills...)
+	settling = append(settling, u.fails...)
+	for _, settle := range settling {
+		if settle.Offered == offered && settle.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *pendingUpdates) empty() bool {
+	return len(u.adds) == 0 && len(u.fulfills) == 0 && len(u.fails) == 0
+}
+
+// withDeferred includes our deferred updates, for checking new ones against
+// everything we've committed to make.
+func (u *pendingUpdates) withDeferred() *pendingUpdates {
+	out := u.clone()
+	for _, update := range u.deferred {
+		out.propose(update)
+	}
+	return out
+}
+
+// theirUnrevokedIndex returns the index of the counterparty's oldest commitment
+// that they have not revoked yet. It trails our commitment number by one
+// between us committing to a new state and them revoking their previous one.
+func theirUnrevokedIndex(channel *db.ETHChannel) uint64 {
+	if channel.CommitmentNumber == 0 {
+		return 0
+	}
+	if _, err := wallet.LookupRevocation(channel.TheirRevocationStore, channel.CommitmentNumber-1); err != nil {
+		return channel.CommitmentNumber - 1
+	}
+	return channel.CommitmentNumber
+}
+
+// applyUpdates returns the channel state and HTLC set that result from
+// applying the pending updates on top of the latest commitment, along with the
+// HTLCs that were added or changed.
+func applyUpdates(channel *db.ETHChannel, htlcs []*db.ETHHTLC, updates *pendingUpdates) (*db.ETHChannel, []*db.ETHHTLC, []*db.ETHHTLC) {
+	next := *channel
+	next.CommitmentNumber = channel.CommitmentNumber + 1
+	next.LocalBalance = new(big.Int).Set(channel.LocalBalance)
+	next.RemoteBalance = new(big.Int).Set(channel.RemoteBalance)
+
+	all := make([]*db.ETHHTLC, len(htlcs))
+	copy(all, htlcs)
+	var changed []*db.ETHHTLC
+
+	if updates == nil {
+		return &next, all, changed
+	}
+
+	for _, add := range updates.adds {
+		if add.Offered {
+			next.LocalBalance.Sub(next.LocalBalance, add.Amount)
+		} else {
+			next.RemoteBalance.Sub(next.RemoteBalance, add.Amount)
+		}
+
+		all = append(all, add)
+		changed = append(changed, add)
+	}
+
+	for _, fulfill := range updates.fulfills {
+		for i, htlc := range all {
+			if htlc.Offered != fulfill.Offered || htlc.ID != fulfill.ID {
+				continue
+			}
+
+			settled := *htlc
+			settled.Fulfilled = true
+			settled.Preimage = fulfill.Preimage
+			all[i] = &settled
+			changed = append(changed, &settled)
+
+			// the receiving side of an HTLC is credited when it is fulfilled
+			if htlc.Offered {
+				next.RemoteBalance.Add(next.RemoteBalance, htlc.Amount)
+			} else {
+				next.LocalBalance.Add(next.LocalBalance, htlc.Amount)
+			}
+		}
+	}
+
+	for _, fail := range updates.fails {
+		for i, htlc := range all {
+			if htlc.Offered != fail.Offered || htlc.ID != fail.ID {
+				continue
+			}
+
+			failed := *htlc
+			failed.Failed = true
+			all[i] = &failed
+			changed = append(changed, &failed)
+
+			// and the offering side gets its amount back when it fails
+			if htlc.Offered {
+				next.LocalBalance.Add(next.LocalBalance, htlc.Amount)
+			} else {
+				next.RemoteBalance.Add(next.RemoteBalance, htlc.Amount)
+			}
+		}
+	}
+
+	return &next, all, changed
+}
+
+// ourCommitment builds our own commitment at the channel's current state,
+// which is what we broadcast when force closing. Its revocation key combines
+// our commitment point with the counterparty's revocation basepoint.
+func ourCommitment(channel *db.ETHChannel, htlcs []*db.ETHHTLC, us common.Address) (*txout.SpendRequest, error) {
+	if channel.TheirRevocationBasepoint == nil {
+		return nil, errors.New("no revocation basepoint for counterparty")
+	}
+
+	point, err := wallet.CommitmentAtIndex(channel.RevocationRoot[:], channel.CommitmentNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	revocation := wallet.RevocationAddress(channel.TheirRevocationBasepoint.BTCEC(), point)
+	return genCommitmentSpendRequest(channel, htlcs, us, us, revocation), nil
+}
+
+// theirCommitment builds the counterparty's commitment at the channel's state
+// using the commitment point they handed us for it and our own revocation
+// basepoint.
+func theirCommitment(channel *db.ETHChannel, htlcs []*db.ETHHTLC, us common.Address, basepoint *crypto.PublicKey, point *crypto.PublicKey) (*txout.SpendRequest, error) {
+	if point == nil {
+		return nil, errors.New("no commitment point for counterparty")
+	}
+
+	revocation := wallet.RevocationAddress(basepoint.BTCEC(), point.BTCEC())
+	return genCommitmentSpendRequest(channel, htlcs, us, channel.Counterparty, revocation), nil
+}
+
+// genCommitmentSpendRequest builds owner's commitment, the spend of a channel's
+// funding output at the given state. The owner's balance is locked up behind
+// csvDelay and can be claimed with the revocation key in the meantime, while
+// the other side is paid out directly. Outputs are ordered by address rather
+// than by who is building the request so both parties sign identical data.
+func genCommitmentSpendRequest(channel *db.ETHChannel, htlcs []*db.ETHHTLC, us common.Address, owner common.Address, revocation common.Address) *txout.SpendRequest {
+	them := channel.Counterparty
+	alice, bob := eth.SortAddresses(us, them)
+	balances := map[common.Address]*big.Int{
+		us:   channel.LocalBalance,
+		them: channel.RemoteBalance,
+	}
+
+	var values []*big.Int
+	var outputs []txout.Output
+	for _, addr := range []common.Address{alice, bob} {
+		if balances[addr].Sign() <= 0 {
+			continue
+		}
+
+		values = append(values, balances[addr])
+		if addr == owner {
+			outputs = append(outputs, txout.NewCommitmentLocal(big.NewInt(csvDelay), owner, revocation))
+		} else {
+			outputs = append(outputs, txout.NewPayment(addr))
+		}
+	}
+
+	var active []*db.ETHHTLC
+	for _, htlc := range htlcs {
+		if !htlc.Fulfilled && !htlc.Failed {
+			active = append(active, htlc)
+		}
+	}
+
+	offerer := func(htlc *db.ETHHTLC) common.Address {
+		if htlc.Offered {
+			return us
+		}
+		return them
+	}
+
+	sort.Slice(active, func(i, j int) bool {
+		cmp := bytes.Compare(offerer(active[i]).Bytes(), offerer(active[j]).Bytes())
+		if cmp != 0 {
+			return cmp < 0
+		}
+		return active[i].ID < active[j].ID
+	})
+
+	for _, htlc := range active {
+		redeemer := them
+		if !htlc.Offered {
+			redeemer = us
+		}
+
+		values = append(values, htlc.Amount)
+		outputs = append(outputs, &txout.OfferedHTLC{
+			Delay:             new(big.Int).SetUint64(htlc.Delay),
+			RedemptionAddress: redeemer,
+			TimeoutAddress:    offerer(htlc),
+			PaymentHash:       htlc.PaymentHash,
+		})
+	}
+
+	return &txout.SpendRequest{
+		InputID: channel.FundingOutput,
+		Witness: txout.NewMultisigWitness(),
+		Values:  values,
+		Outputs: outputs,
+	}
+}
@@ -0,0 +1,714 @@
+package protocol
+
+import (
+	"github.com/kyokan/drawbridge/internal/p2p"
+	"github.com/kyokan/drawbridge/internal/wallet"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"github.com/kyokan/drawbridge/pkg/wire"
+	"github.com/kyokan/drawbridge/pkg/txout"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"errors"
+	"sync"
+	"crypto/sha256"
+	"context"
+	"bytes"
+)
+
+// CommitmentHandler moves funded ETH channels from one commitment to the next
+// using BOLT-2 style update_add_htlc, update_fulfill_htlc, update_fail_htlc,
+// commitment_signed and revoke_and_ack messages. Only one side may have updates in flight at a time;
+// updates made while the other side's are in flight wait for them to be
+// committed. When both sides propose at once, the side with the lower address
+// backs off and proposes again afterwards.
+// ClosingChecker reports whether a channel is being cooperatively closed.
+type ClosingChecker interface {
+	IsClosing(chanId common.Hash) bool
+}
+
+type CommitmentHandler struct {
+	peerBook *p2p.PeerBook
+	km       *wallet.KeyManager
+	db       *db.DB
+	closing  ClosingChecker
+	mtx      sync.Mutex
+	updates  map[common.Hash]*pendingUpdates
+}
+
+func NewCommitmentHandler(peerBook *p2p.PeerBook, km *wallet.KeyManager, db *db.DB, closing ClosingChecker) *CommitmentHandler {
+	return &CommitmentHandler{
+		peerBook: peerBook,
+		km:       km,
+		db:       db,
+		closing:  closing,
+		updates:  make(map[common.Hash]*pendingUpdates),
+	}
+}
+
+func (c *CommitmentHandler) AddHTLC(ctx context.Context, pub *crypto.PublicKey, chanId common.Hash, amount *big.Int, paymentHash [32]byte, delay uint64) (uint64, error) {
+	peer := c.peerBook.FindPeer(pub)
+	if peer == nil {
+		return 0, errors.New("peer not found")
+	}
+
+	c.mtx.Lock()
+	channel, htlcs, updates, err := c.loadUpdatable(chanId)
+	if err != nil {
+		c.mtx.Unlock()
+		return 0, err
+	}
+
+	view, all, _ := applyUpdates(channel, htlcs, updates.withDeferred())
+	if view.LocalBalance.Cmp(amount) < 0 {
+		c.mtx.Unlock()
+		return 0, errors.New("insufficient channel balance")
+	}
+
+	htlc := &db.ETHHTLC{
+		ChannelID:   chanId,
+		ID:          nextHTLCID(all, true),
+		Offered:     true,
+		Amount:      amount,
+		PaymentHash: paymentHash,
+		Delay:       delay,
+	}
+	msgs, err := c.propose(channel, htlcs, updates, htlc)
+	c.mtx.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	return htlc.ID, sendAll(ctx, peer, msgs)
+}
+
+func (c *CommitmentHandler) FulfillHTLC(ctx context.Context, pub *crypto.PublicKey, chanId common.Hash, htlcId uint64, preimage [32]byte) error {
+	peer := c.peerBook.FindPeer(pub)
+	if peer == nil {
+		return errors.New("peer not found")
+	}
+
+	c.mtx.Lock()
+	channel, htlcs, updates, err := c.loadUpdatable(chanId)
+	if err != nil {
+		c.mtx.Unlock()
+		return err
+	}
+
+	if updates.withDeferred().settles(false, htlcId) {
+		c.mtx.Unlock()
+		return errors.New("htlc is already being settled")
+	}
+	fulfill, err := findFulfillable(htlcs, false, htlcId, preimage)
+	if err != nil {
+		c.mtx.Unlock()
+		return err
+	}
+	msgs, err := c.propose(channel, htlcs, updates, fulfill)
+	c.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return sendAll(ctx, peer, msgs)
+}
+
+// FailHTLC gives an HTLC we were offered back to the peer, for when we won't
+// be able to fulfill it.
+func (c *CommitmentHandler) FailHTLC(ctx context.Context, pub *crypto.PublicKey, chanId common.Hash, htlcId uint64) error {
+	peer := c.peerBook.FindPeer(pub)
+	if peer == nil {
+		return errors.New("peer not found")
+	}
+
+	c.mtx.Lock()
+	channel, htlcs, updates, err := c.loadUpdatable(chanId)
+	if err != nil {
+		c.mtx.Unlock()
+		return err
+	}
+
+	if updates.withDeferred().settles(false, htlcId) {
+		c.mtx.Unlock()
+		return errors.New("htlc is already being settled")
+	}
+	fail, err := findFailable(htlcs, false, htlcId)
+	if err != nil {
+		c.mtx.Unlock()
+		return err
+	}
+	msgs, err := c.propose(channel, htlcs, updates, fail)
+	c.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return sendAll(ctx, peer, msgs)
+}
+
+func (c *CommitmentHandler) CanAccept(msg lnwire.Message) bool {
+	switch msg.MsgType() {
+	case wire.MsgUpdateAddHTLC, wire.MsgUpdateFulfillHTLC, wire.MsgUpdateFailHTLC, wire.MsgCommitmentSigned,
+		wire.MsgRevokeAndAck:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *CommitmentHandler) Accept(envelope *p2p.Envelope) ([]lnwire.Message, error) {
+	msg := envelope.Msg
+	switch msg.MsgType() {
+	case wire.MsgUpdateAddHTLC:
+		return p2p.Reply(c.onUpdateAddHTLC(msg.(*wire.UpdateAddHTLC)))
+	case wire.MsgUpdateFulfillHTLC:
+		return p2p.Reply(c.onUpdateFulfillHTLC(msg.(*wire.UpdateFulfillHTLC)))
+	case wire.MsgUpdateFailHTLC:
+		return p2p.Reply(c.onUpdateFailHTLC(msg.(*wire.UpdateFailHTLC)))
+	case wire.MsgCommitmentSigned:
+		return c.onCommitmentSigned(msg.(*wire.CommitmentSigned))
+	case wire.MsgRevokeAndAck:
+		return c.onRevokeAndAck(msg.(*wire.RevokeAndAck))
+	default:
+		return nil, errors.New("unknown message type")
+	}
+}
+
+// OnConnect sends our updates again if the peer went away before committing
+// to them.
+func (c *CommitmentHandler) OnConnect(peer *p2p.Peer) {
+	go c.retransmit(peer)
+}
+
+// OnDisconnect forgets the updates the peer was proposing. It proposes them
+// again when it reconnects.
+func (c *CommitmentHandler) OnDisconnect(peer *p2p.Peer, reason error) {
+	channels, err := c.db.Channels.FindByCounterparty(peer.Identity.ETHAddress())
+	if err != nil {
+		log.Errorw("failed to look up peer channels", "err", err.Error())
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, channel := range channels {
+		updates, exists := c.updates[channel.ID]
+		if !exists || updates.signedNext {
+			continue
+		}
+
+		next := updates.clone()
+		next.adds = nil
+		next.fulfills = nil
+		next.fails = nil
+		next.ignoreRemote = false
+		if err := c.saveUpdates(channel, next); err != nil {
+			log.Errorw("failed to drop peer updates", "chanId", channel.ID.Hex(), "err", err.Error())
+		}
+	}
+}
+
+func (c *CommitmentHandler) retransmit(peer *p2p.Peer) {
+	channels, err := c.db.Channels.FindByCounterparty(peer.Identity.ETHAddress())
+	if err != nil {
+		log.Errorw("failed to look up peer channels", "err", err.Error())
+		return
+	}
+
+	for _, channel := range channels {
+		if channel.IsClosed {
+			continue
+		}
+
+		c.mtx.Lock()
+		current, htlcs, updates, err := c.loadChannel(channel.ID)
+		if err != nil {
+			c.mtx.Unlock()
+			log.Errorw("failed to load channel", "chanId", channel.ID.Hex(), "err", err.Error())
+			continue
+		}
+
+		var msgs []lnwire.Message
+		if updates.signedNext {
+			msgs, err = c.signProposal(current, htlcs, updates)
+		} else {
+			msgs, err = c.proposeDeferred(current, htlcs, updates)
+		}
+		c.mtx.Unlock()
+		if err != nil {
+			log.Errorw("failed to resend channel updates", "chanId", channel.ID.Hex(), "err", err.Error())
+			continue
+		}
+
+		if err := sendAll(peer.Context(), peer, msgs); err != nil {
+			log.Errorw("failed to resend channel updates", "chanId", channel.ID.Hex(), "err", err.Error())
+		}
+	}
+}
+
+func (c *CommitmentHandler) onUpdateAddHTLC(msg *wire.UpdateAddHTLC) (lnwire.Message, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	channel, htlcs, updates, err := c.loadUpdatable(msg.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	updates, proceed, err := c.receiveUpdate(channel, updates)
+	if err != nil || !proceed {
+		return nil, err
+	}
+
+	view, all, _ := applyUpdates(channel, htlcs, updates)
+	if msg.ID != nextHTLCID(all, false) {
+		return nil, errors.New("unexpected htlc id")
+	}
+	if view.RemoteBalance.Cmp(msg.Amount) < 0 {
+		return nil, errors.New("htlc exceeds remote balance")
+	}
+
+	updates.adds = append(updates.adds, &db.ETHHTLC{
+		ChannelID:   msg.ChannelID,
+		ID:          msg.ID,
+		Offered:     false,
+		Amount:      msg.Amount,
+		PaymentHash: msg.PaymentHash,
+		Delay:       msg.Delay,
+	})
+
+	return nil, c.saveUpdates(channel, updates)
+}
+
+func (c *CommitmentHandler) onUpdateFulfillHTLC(msg *wire.UpdateFulfillHTLC) (lnwire.Message, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	channel, htlcs, updates, err := c.loadUpdatable(msg.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	updates, proceed, err := c.receiveUpdate(channel, updates)
+	if err != nil || !proceed {
+		return nil, err
+	}
+
+	if updates.settles(true, msg.ID) {
+		return nil, errors.New("htlc is already being settled")
+	}
+	fulfill, err := findFulfillable(htlcs, true, msg.ID, msg.PaymentPreimage)
+	if err != nil {
+		return nil, err
+	}
+	updates.fulfills = append(updates.fulfills, fulfill)
+
+	return nil, c.saveUpdates(channel, updates)
+}
+
+func (c *CommitmentHandler) onUpdateFailHTLC(msg *wire.UpdateFailHTLC) (lnwire.Message, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	channel, htlcs, updates, err := c.loadUpdatable(msg.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	updates, proceed, err := c.receiveUpdate(channel, updates)
+	if err != nil || !proceed {
+		return nil, err
+	}
+
+	if updates.settles(true, msg.ID) {
+		return nil, errors.New("htlc is already being settled")
+	}
+	fail, err := findFailable(htlcs, true, msg.ID)
+	if err != nil {
+		return nil, err
+	}
+	updates.fails = append(updates.fails, fail)
+
+	return nil, c.saveUpdates(channel, updates)
+}
+
+// receiveUpdate returns the updates to add the counterparty's update to, or
+// false if the update should be dropped. An update arriving while ours are in
+// flight means both sides proposed at once: the side with the lower address
+// defers its own updates, and the other drops the counterparty's proposal.
+func (c *CommitmentHandler) receiveUpdate(channel *db.ETHChannel, updates *pendingUpdates) (*pendingUpdates, bool, error) {
+	if updates.ignoreRemote {
+		return nil, false, nil
+	}
+
+	next := updates.clone()
+	if !updates.signedNext {
+		return next, true, nil
+	}
+
+	us := c.km.PublicKey().ETHAddress()
+	if bytes.Compare(us.Bytes(), channel.Counterparty.Bytes()) > 0 {
+		log.Infow("dropping concurrent update from peer", "chanId", channel.ID.Hex())
+		next.ignoreRemote = true
+		return nil, false, c.saveUpdates(channel, next)
+	}
+
+	log.Infow("deferring our updates to concurrent ones from peer", "chanId", channel.ID.Hex())
+	var ours []*db.ETHHTLC
+	ours = append(ours, updates.adds...)
+	ours = append(ours, updates.fulfills...)
+	ours = append(ours, updates.fails...)
+	next.deferred = append(ours, updates.deferred...)
+	next.adds = nil
+	next.fulfills = nil
+	next.fails = nil
+	next.signedNext = false
+	return next, true, nil
+}
+
+func (c *CommitmentHandler) onCommitmentSigned(msg *wire.CommitmentSigned) ([]lnwire.Message, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	channel, htlcs, updates, err := c.loadChannel(msg.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	if updates.ignoreRemote {
+		next := updates.clone()
+		next.ignoreRemote = false
+		return nil, c.saveUpdates(channel, next)
+	}
+	if msg.CommitmentNumber != channel.CommitmentNumber+1 {
+		return nil, errors.New("unexpected commitment number")
+	}
+
+	us := c.km.PublicKey().ETHAddress()
+	next, all, changed := applyUpdates(channel, htlcs, updates)
+	ours, err := ourCommitment(next, all, us)
+	if err != nil {
+		return nil, err
+	}
+	sigHash, err := txout.SigData(ours)
+	if err != nil {
+		return nil, err
+	}
+	if !msg.Sig.VerifyAddress(sigHash, channel.Counterparty) {
+		return nil, p2p.Misbehaving(p2p.ScoreSevere, errors.New("commitment signature verification failed"))
+	}
+	next.TheirCommitSig = msg.Sig
+
+	revocation, err := genRevocation(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	// revoke_and_ack must reach the peer before our own commitment_signed
+	res := []lnwire.Message{revocation}
+	if !updates.signedNext {
+		commitSig, err := c.signNext(channel, htlcs, updates)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, commitSig)
+	}
+
+	err = c.db.Channels.UpdateState(next, changed)
+	if err != nil {
+		return nil, err
+	}
+	c.updates[msg.ChannelID] = &pendingUpdates{deferred: updates.deferred}
+	log.Infow("committed channel state", "chanId", msg.ChannelID.Hex(), "commitmentNumber", next.CommitmentNumber)
+
+	deferred, err := c.proposeDeferred(next, all, &pendingUpdates{deferred: updates.deferred})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(res, deferred...), nil
+}
+
+func (c *CommitmentHandler) onRevokeAndAck(msg *wire.RevokeAndAck) ([]lnwire.Message, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	channel, htlcs, updates, err := c.loadChannel(msg.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.CommitmentNumber != theirUnrevokedIndex(channel) {
+		return nil, errors.New("unexpected revocation commitment number")
+	}
+	if channel.TheirRevocationPoint == nil {
+		return nil, errors.New("no commitment point to check revocation against")
+	}
+	if !wallet.ValidateRevocation(msg.Revocation[:], channel.TheirRevocationPoint.BTCEC()) {
+		return nil, p2p.Misbehaving(p2p.ScoreSevere, errors.New("invalid revocation secret"))
+	}
+
+	store, err := wallet.AddRevocation(channel.TheirRevocationStore, msg.Revocation[:])
+	if err != nil {
+		return nil, err
+	}
+	channel.TheirRevocationStore = store
+	channel.TheirRevocationPoint = channel.TheirNextRevocationPoint
+	channel.TheirNextRevocationPoint = msg.NextPerCommitmentPoint
+
+	if err := c.db.Channels.UpdateState(channel, nil); err != nil {
+		return nil, err
+	}
+
+	return c.proposeDeferred(channel, htlcs, updates)
+}
+
+func (c *CommitmentHandler) loadChannel(chanId common.Hash) (*db.ETHChannel, []*db.ETHHTLC, *pendingUpdates, error) {
+	channel, err := c.db.Channels.FindById(chanId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if channel == nil {
+		return nil, nil, nil, errors.New("no channel with that id found")
+	}
+	if channel.IsClosed {
+		return nil, nil, nil, errors.New("channel is closed")
+	}
+
+	htlcs, err := c.db.Channels.FindHTLCs(chanId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	updates, exists := c.updates[chanId]
+	if !exists {
+		record, err := c.db.ChannelUpdates.FindByChannel(chanId)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		updates = pendingFromRecord(record)
+
+		// updates saved against an earlier commitment were committed before
+		// we could clear them, and the peer proposes its own again when it
+		// reconnects
+		if record.CommitmentNumber != channel.CommitmentNumber || !record.SignedNext {
+			updates = &pendingUpdates{deferred: updates.deferred}
+		}
+		c.updates[chanId] = updates
+	}
+
+	return channel, htlcs, updates, nil
+}
+
+// loadUpdatable loads a channel that is about to be updated, refusing channels
+// that are being cooperatively closed.
+func (c *CommitmentHandler) loadUpdatable(chanId common.Hash) (*db.ETHChannel, []*db.ETHHTLC, *pendingUpdates, error) {
+	if c.closing.IsClosing(chanId) {
+		return nil, nil, nil, errors.New("channel is closing")
+	}
+
+	return c.loadChannel(chanId)
+}
+
+func (c *CommitmentHandler) saveUpdates(channel *db.ETHChannel, updates *pendingUpdates) error {
+	if err := c.db.ChannelUpdates.Save(updates.record(channel)); err != nil {
+		return err
+	}
+
+	c.updates[channel.ID] = updates
+	return nil
+}
+
+// propose makes update part of the next commitment, or defers it while
+// another update is in flight.
+func (c *CommitmentHandler) propose(channel *db.ETHChannel, htlcs []*db.ETHHTLC, updates *pendingUpdates, update *db.ETHHTLC) ([]lnwire.Message, error) {
+	next := updates.clone()
+	if !canPropose(channel, updates) {
+		next.deferred = append(next.deferred, update)
+		return nil, c.saveUpdates(channel, next)
+	}
+
+	next.propose(update)
+	return c.signProposal(channel, htlcs, next)
+}
+
+// proposeDeferred proposes our deferred updates once nothing else is in
+// flight.
+func (c *CommitmentHandler) proposeDeferred(channel *db.ETHChannel, htlcs []*db.ETHHTLC, updates *pendingUpdates) ([]lnwire.Message, error) {
+	next := updates.clone()
+	if len(updates.deferred) == 0 || !canPropose(channel, updates) {
+		return nil, c.saveUpdates(channel, next)
+	}
+
+	next.deferred = nil
+	for _, update := range updates.deferred {
+		next.propose(update)
+	}
+	return c.signProposal(channel, htlcs, next)
+}
+
+// signProposal signs the commitment that results from our proposed updates and
+// returns the messages that propose them.
+func (c *CommitmentHandler) signProposal(channel *db.ETHChannel, htlcs []*db.ETHHTLC, updates *pendingUpdates) ([]lnwire.Message, error) {
+	commitSig, err := c.signNext(channel, htlcs, updates)
+	if err != nil {
+		return nil, err
+	}
+
+	next := updates.clone()
+	next.signedNext = true
+	if err := c.saveUpdates(channel, next); err != nil {
+		return nil, err
+	}
+
+	var msgs []lnwire.Message
+	for _, add := range next.adds {
+		msgs = append(msgs, &wire.UpdateAddHTLC{
+			ChannelID:   add.ChannelID,
+			ID:          add.ID,
+			Amount:      add.Amount,
+			PaymentHash: add.PaymentHash,
+			Delay:       add.Delay,
+		})
+	}
+	for _, fulfill := range next.fulfills {
+		msgs = append(msgs, &wire.UpdateFulfillHTLC{
+			ChannelID:       fulfill.ChannelID,
+			ID:              fulfill.ID,
+			PaymentPreimage: fulfill.Preimage,
+		})
+	}
+	for _, fail := range next.fails {
+		msgs = append(msgs, &wire.UpdateFailHTLC{
+			ChannelID: fail.ChannelID,
+			ID:        fail.ID,
+		})
+	}
+
+	return append(msgs, commitSig), nil
+}
+
+// canPropose reports whether we can start a round of updates: neither side
+// has updates in flight, and the counterparty has revoked its previous
+// commitment, handing us the point for its next one.
+func canPropose(channel *db.ETHChannel, updates *pendingUpdates) bool {
+	if updates.signedNext || !updates.empty() {
+		return false
+	}
+
+	return theirUnrevokedIndex(channel) == channel.CommitmentNumber
+}
+
+func sendAll(ctx context.Context, peer *p2p.Peer, msgs []lnwire.Message) error {
+	for _, msg := range msgs {
+		if err := peer.Send(ctx, msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// signNext signs the counterparty's commitment for the state that results from
+// the pending updates.
+func (c *CommitmentHandler) signNext(channel *db.ETHChannel, htlcs []*db.ETHHTLC, updates *pendingUpdates) (*wire.CommitmentSigned, error) {
+	next, all, _ := applyUpdates(channel, htlcs, updates)
+	ourKey := c.km.PublicKey()
+	theirs, err := theirCommitment(next, all, ourKey.ETHAddress(), ourKey, channel.TheirNextRevocationPoint)
+	if err != nil {
+		return nil, err
+	}
+	sigHash, err := txout.SigData(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := c.km.SignData(sigHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wire.CommitmentSigned{
+		ChannelID:        channel.ID,
+		CommitmentNumber: next.CommitmentNumber,
+		Sig:              sig,
+	}, nil
+}
+
+// genRevocation reveals the secret for the channel's current commitment. The
+// point for the next commitment was already handed over, so this carries the
+// one after that.
+func genRevocation(channel *db.ETHChannel) (*wire.RevokeAndAck, error) {
+	secret, err := wallet.RevocationSecretAtIndex(channel.RevocationRoot[:], channel.CommitmentNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	point, err := wallet.CommitmentAtIndex(channel.RevocationRoot[:], channel.CommitmentNumber+2)
+	if err != nil {
+		return nil, err
+	}
+
+	nextPoint, err := crypto.PublicFromBTCEC(point)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &wire.RevokeAndAck{
+		ChannelID:              channel.ID,
+		CommitmentNumber:       channel.CommitmentNumber,
+		NextPerCommitmentPoint: nextPoint,
+	}
+	copy(res.Revocation[:], secret)
+	return res, nil
+}
+
+func findFulfillable(htlcs []*db.ETHHTLC, offered bool, id uint64, preimage [32]byte) (*db.ETHHTLC, error) {
+	for _, htlc := range htlcs {
+		if htlc.Offered != offered || htlc.ID != id {
+			continue
+		}
+		if htlc.Fulfilled || htlc.Failed {
+			return nil, errors.New("htlc already settled")
+		}
+		if common.Hash(sha256.Sum256(preimage[:])) != htlc.PaymentHash {
+			return nil, errors.New("preimage does not match payment hash")
+		}
+
+		return &db.ETHHTLC{
+			ChannelID:   htlc.ChannelID,
+			ID:          htlc.ID,
+			Offered:     htlc.Offered,
+			Amount:      htlc.Amount,
+			PaymentHash: htlc.PaymentHash,
+			Delay:       htlc.Delay,
+			Preimage:    preimage,
+			Fulfilled:   true,
+		}, nil
+	}
+
+	return nil, errors.New("no htlc with that id found")
+}
+
+func findFailable(htlcs []*db.ETHHTLC, offered bool, id uint64) (*db.ETHHTLC, error) {
+	for _, htlc := range htlcs {
+		if htlc.Offered != offered || htlc.ID != id {
+			continue
+		}
+		if htlc.Fulfilled || htlc.Failed {
+			return nil, errors.New("htlc already settled")
+		}
+
+		failed := *htlc
+		failed.Failed = true
+		return &failed, nil
+	}
+
+	return nil, errors.New("no htlc with that id found")
+}
+
+func nextHTLCID(htlcs []*db.ETHHTLC, offered bool) uint64 {
+	var next uint64
+	for _, htlc := range htlcs {
+		if htlc.Offered == offered && htlc.ID >= next {
+			next = htlc.ID + 1
+		}
+	}
+	return next
+}
@@ -0,0 +1,121 @@
+package protocol
+
+import (
+	"testing"
+	"math/big"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/internal/wallet"
+	"github.com/kyokan/drawbridge/pkg/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+type memChannelUpdates struct {
+	updates map[common.Hash]*db.ChannelUpdates
+}
+
+func (m *memChannelUpdates) Save(updates *db.ChannelUpdates) error {
+	if m.updates == nil {
+		m.updates = make(map[common.Hash]*db.ChannelUpdates)
+	}
+	m.updates[updates.ChannelID] = updates
+	return nil
+}
+
+func (m *memChannelUpdates) FindByChannel(chanId common.Hash) (*db.ChannelUpdates, error) {
+	if updates, ok := m.updates[chanId]; ok {
+		return updates, nil
+	}
+	return &db.ChannelUpdates{ChannelID: chanId}, nil
+}
+
+type closingSet map[common.Hash]bool
+
+func (c closingSet) IsClosing(chanId common.Hash) bool {
+	return c[chanId]
+}
+
+func TestCommitmentHandler_RejectsUpdatesWhileClosing(t *testing.T) {
+	chanId := common.HexToHash("0x01")
+	c := &CommitmentHandler{
+		closing: closingSet{chanId: true},
+		updates: make(map[common.Hash]*pendingUpdates),
+	}
+
+	_, err := c.onUpdateAddHTLC(&wire.UpdateAddHTLC{ChannelID: chanId, Amount: big.NewInt(100)})
+	assert.EqualError(t, err, "channel is closing")
+	_, err = c.onUpdateFulfillHTLC(&wire.UpdateFulfillHTLC{ChannelID: chanId})
+	assert.EqualError(t, err, "channel is closing")
+	_, err = c.onUpdateFailHTLC(&wire.UpdateFailHTLC{ChannelID: chanId})
+	assert.EqualError(t, err, "channel is closing")
+}
+
+func TestCommitmentHandler_ConcurrentUpdates(t *testing.T) {
+	km, err := wallet.NewKeyManager("c87509a1c067bbde78beb793e6fa76530b6382a4c0241e5e4a9ec0a0f44dc0d3", big.NewInt(1337))
+	assert.Nil(t, err)
+
+	ours := &db.ETHHTLC{ID: 0, Offered: true, Amount: big.NewInt(100)}
+	inFlight := &pendingUpdates{
+		adds:       []*db.ETHHTLC{ours},
+		signedNext: true,
+	}
+
+	store := &memChannelUpdates{}
+	c := &CommitmentHandler{
+		km:      km,
+		db:      &db.DB{ChannelUpdates: store},
+		updates: make(map[common.Hash]*pendingUpdates),
+	}
+
+	// our address is higher, so the peer's proposal is dropped
+	lower := &db.ETHChannel{
+		ID:           common.HexToHash("0x01"),
+		Counterparty: common.HexToAddress("0x0000000000000000000000000000000000000001"),
+	}
+	_, proceed, err := c.receiveUpdate(lower, inFlight)
+	assert.Nil(t, err)
+	assert.False(t, proceed)
+	assert.True(t, c.updates[lower.ID].ignoreRemote)
+	assert.True(t, c.updates[lower.ID].signedNext)
+	assert.Equal(t, []*db.ETHHTLC{ours}, c.updates[lower.ID].adds)
+
+	_, proceed, err = c.receiveUpdate(lower, c.updates[lower.ID])
+	assert.Nil(t, err)
+	assert.False(t, proceed)
+
+	// our address is lower, so ours are deferred in favor of the peer's
+	higher := &db.ETHChannel{
+		ID:           common.HexToHash("0x02"),
+		Counterparty: common.HexToAddress("0xf17f52151ebef6c7334fad080c5704d77216b732"),
+	}
+	next, proceed, err := c.receiveUpdate(higher, inFlight)
+	assert.Nil(t, err)
+	assert.True(t, proceed)
+	assert.False(t, next.signedNext)
+	assert.Empty(t, next.adds)
+	assert.Equal(t, []*db.ETHHTLC{ours}, next.deferred)
+	assert.True(t, inFlight.signedNext)
+}
+
+func TestCanPropose(t *testing.T) {
+	root := common.HexToHash("0x01")
+	secret, err := wallet.RevocationSecretAtIndex(root[:], 0)
+	assert.Nil(t, err)
+	store, err := wallet.AddRevocation(nil, secret)
+	assert.Nil(t, err)
+
+	channel := &db.ETHChannel{CommitmentNumber: 0}
+	assert.Equal(t, uint64(0), theirUnrevokedIndex(channel))
+	assert.True(t, canPropose(channel, &pendingUpdates{}))
+	assert.False(t, canPropose(channel, &pendingUpdates{signedNext: true}))
+	assert.False(t, canPropose(channel, &pendingUpdates{adds: []*db.ETHHTLC{{}}}))
+
+	// committed to the next state, waiting for the peer to revoke its last
+	channel.CommitmentNumber = 1
+	assert.Equal(t, uint64(0), theirUnrevokedIndex(channel))
+	assert.False(t, canPropose(channel, &pendingUpdates{}))
+
+	channel.TheirRevocationStore = store
+	assert.Equal(t, uint64(1), theirUnrevokedIndex(channel))
+	assert.True(t, canPropose(channel, &pendingUpdates{}))
+}
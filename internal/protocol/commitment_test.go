@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"testing"
+	"math/big"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/pkg/txout"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenCommitmentSpendRequest_Agreement(t *testing.T) {
+	addrA := common.HexToAddress("0x627306090abab3a6e1400e9345bc60c78a8bef57")
+	addrB := common.HexToAddress("0xf17f52151ebef6c7334fad080c5704d77216b732")
+	revocation := common.HexToAddress("0x08e4f70109ccc5135f50cc359d24cb7686247df4")
+	fundingOutput := common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e")
+	paymentHash := common.HexToHash("0xf2f452833095a6d4a81f0845f5712a67a9bcbec74cad1c1c5c151f2fa62a59c3")
+
+	chanA := &db.ETHChannel{
+		FundingOutput: fundingOutput,
+		Counterparty:  addrB,
+		LocalBalance:  big.NewInt(900),
+		RemoteBalance: big.NewInt(50),
+	}
+	htlcsA := []*db.ETHHTLC{
+		{ID: 0, Offered: true, Amount: big.NewInt(50), PaymentHash: paymentHash, Delay: 5},
+	}
+	chanB := &db.ETHChannel{
+		FundingOutput: fundingOutput,
+		Counterparty:  addrA,
+		LocalBalance:  big.NewInt(50),
+		RemoteBalance: big.NewInt(900),
+	}
+	htlcsB := []*db.ETHHTLC{
+		{ID: 0, Offered: false, Amount: big.NewInt(50), PaymentHash: paymentHash, Delay: 5},
+	}
+
+	// A signs B's commitment, which B must build identically on its own
+	reqA := genCommitmentSpendRequest(chanA, htlcsA, addrA, addrB, revocation)
+	reqB := genCommitmentSpendRequest(chanB, htlcsB, addrB, addrB, revocation)
+	sigA, err := txout.SigData(reqA)
+	assert.Nil(t, err)
+	sigB, err := txout.SigData(reqB)
+	assert.Nil(t, err)
+	assert.Equal(t, sigA, sigB)
+
+	var owned int
+	for _, out := range reqB.Outputs {
+		if local, ok := out.(*txout.CommitmentLocal); ok {
+			owned++
+			assert.Equal(t, addrB, local.DelayedAddress)
+			assert.Equal(t, revocation, local.RevocationAddress)
+		}
+	}
+	assert.Equal(t, 1, owned)
+
+	// B's commitment is not A's
+	ownA, err := txout.SigData(genCommitmentSpendRequest(chanA, htlcsA, addrA, addrA, revocation))
+	assert.Nil(t, err)
+	assert.NotEqual(t, sigA, ownA)
+}
+
+func TestApplyUpdates(t *testing.T) {
+	paymentHash := common.HexToHash("0xf2f452833095a6d4a81f0845f5712a67a9bcbec74cad1c1c5c151f2fa62a59c3")
+	channel := &db.ETHChannel{
+		CommitmentNumber: 3,
+		LocalBalance:     big.NewInt(1000),
+		RemoteBalance:    big.NewInt(0),
+	}
+	htlcs := []*db.ETHHTLC{
+		{ID: 0, Offered: true, Amount: big.NewInt(100), PaymentHash: paymentHash},
+	}
+	updates := &pendingUpdates{
+		adds: []*db.ETHHTLC{
+			{ID: 1, Offered: true, Amount: big.NewInt(200), PaymentHash: paymentHash},
+		},
+		fulfills: []*db.ETHHTLC{
+			{ID: 0, Offered: true, Fulfilled: true},
+		},
+	}
+
+	next, all, changed := applyUpdates(channel, htlcs, updates)
+	assert.Equal(t, uint64(4), next.CommitmentNumber)
+	assert.Equal(t, int64(800), next.LocalBalance.Int64())
+	assert.Equal(t, int64(100), next.RemoteBalance.Int64())
+	assert.Equal(t, 2, len(all))
+	assert.Equal(t, 2, len(changed))
+	assert.Equal(t, int64(1000), channel.LocalBalance.Int64())
+	assert.False(t, htlcs[0].Fulfilled)
+}
+
+func TestApplyUpdates_Fails(t *testing.T) {
+	paymentHash := common.HexToHash("0xf2f452833095a6d4a81f0845f5712a67a9bcbec74cad1c1c5c151f2fa62a59c3")
+	channel := &db.ETHChannel{
+		CommitmentNumber: 3,
+		LocalBalance:     big.NewInt(700),
+		RemoteBalance:    big.NewInt(100),
+	}
+	htlcs := []*db.ETHHTLC{
+		{ID: 0, Offered: true, Amount: big.NewInt(100), PaymentHash: paymentHash},
+		{ID: 0, Offered: false, Amount: big.NewInt(200), PaymentHash: paymentHash},
+	}
+
+	ours, err := findFailable(htlcs, false, 0)
+	assert.Nil(t, err)
+	_, err = findFailable(htlcs, false, 1)
+	assert.NotNil(t, err)
+
+	updates := &pendingUpdates{}
+	updates.propose(ours)
+	updates.propose(&db.ETHHTLC{ID: 0, Offered: true, Failed: true})
+	assert.Len(t, updates.fails, 2)
+	assert.True(t, updates.settles(false, 0))
+
+	// each failed htlc goes back to whoever offered it
+	next, all, changed := applyUpdates(channel, htlcs, updates)
+	assert.Equal(t, int64(800), next.LocalBalance.Int64())
+	assert.Equal(t, int64(300), next.RemoteBalance.Int64())
+	assert.True(t, all[0].Failed)
+	assert.True(t, all[1].Failed)
+	assert.Equal(t, 2, len(changed))
+	assert.False(t, htlcs[1].Failed)
+
+	_, err = findFailable(all, false, 0)
+	assert.NotNil(t, err)
+}
+
+func TestGenClosingSpendRequest_SkipsEmptyBalance(t *testing.T) {
+	us := common.HexToAddress("0x627306090abab3a6e1400e9345bc60c78a8bef57")
+	them := common.HexToAddress("0xf17f52151ebef6c7334fad080c5704d77216b732")
+	channel := &db.ETHChannel{
+		Counterparty:  them,
+		LocalBalance:  big.NewInt(1000),
+		RemoteBalance: big.NewInt(0),
+	}
+
+	req := genClosingSpendRequest(channel, us)
+	assert.Equal(t, []*big.Int{big.NewInt(1000)}, req.Values)
+	assert.Equal(t, []txout.Output{txout.NewPayment(us)}, req.Outputs)
+}
@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/kyokan/drawbridge/pkg/wire"
+	"errors"
+	"github.com/kyokan/drawbridge/internal/p2p"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Failer abandons whatever pending work id refers to after the peer reports
+// that it failed on their side.
+type Failer interface {
+	Fail(peer *crypto.PublicKey, id common.Hash, reason string)
+}
+
+type ErrorHandler struct {
+	failers []Failer
+}
+
+func NewErrorHandler(failers ...Failer) *ErrorHandler {
+	return &ErrorHandler{
+		failers: failers,
+	}
+}
+
+func (h *ErrorHandler) CanAccept(msg lnwire.Message) bool {
+	switch msg.MsgType() {
+	case wire.MsgError, wire.MsgWarning:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *ErrorHandler) Accept(envelope *p2p.Envelope) ([]lnwire.Message, error) {
+	msg := envelope.Msg
+	switch msg.MsgType() {
+	case wire.MsgError:
+		return nil, h.onError(msg.(*wire.Error), envelope.Peer)
+	case wire.MsgWarning:
+		warning := msg.(*wire.Warning)
+		log.Warnw("received warning from peer", "peer", envelope.Peer, "id", common.Hash(warning.ID).Hex(),
+			"reason", warning.Data)
+		return nil, nil
+	default:
+		return nil, errors.New("unknown message type")
+	}
+}
+
+func (h *ErrorHandler) onError(msg *wire.Error, peer *p2p.Peer) error {
+	id := common.Hash(msg.ID)
+	log.Errorw("received error from peer", "peer", peer, "id", id.Hex(), "reason", msg.Data)
+
+	if id == (common.Hash{}) {
+		return nil
+	}
+
+	for _, failer := range h.failers {
+		failer.Fail(peer.Identity, id, msg.Data)
+	}
+
+	return nil
+}
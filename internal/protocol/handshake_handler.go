@@ -6,15 +6,18 @@ import (
 	"github.com/kyokan/drawbridge/pkg/wire"
 	"github.com/go-errors/errors"
 	"github.com/kyokan/drawbridge/internal/p2p"
+	"github.com/kyokan/drawbridge/internal/db"
 )
 
 type HandshakeHandler struct {
-	lndClient        *lndclient.Client
+	lndClient *lndclient.Client
+	peers     db.Peers
 }
 
-func NewHandshakeHandler(lndClient *lndclient.Client) *HandshakeHandler {
+func NewHandshakeHandler(lndClient *lndclient.Client, peers db.Peers) *HandshakeHandler {
 	return &HandshakeHandler{
 		lndClient: lndClient,
+		peers:     peers,
 	}
 }
 
@@ -27,17 +30,31 @@ func (h *HandshakeHandler) CanAccept(msg lnwire.Message) bool {
 	}
 }
 
-func (h *HandshakeHandler) Accept(envelope *p2p.Envelope) (lnwire.Message, error) {
+func (h *HandshakeHandler) Accept(envelope *p2p.Envelope) ([]lnwire.Message, error) {
 	msg := envelope.Msg
 	switch msg.MsgType() {
 	case wire.MsgInit:
-		return h.acceptInit(msg.(*wire.Init), envelope.Peer)
+		return p2p.Reply(h.acceptInit(msg.(*wire.Init), envelope.Peer))
 	default:
 		return nil, errors.New("unknown message type")
 	}
 }
 
 func (h *HandshakeHandler) acceptInit(msg *wire.Init, peer *p2p.Peer) (lnwire.Message, error) {
+	globalFeatures := wire.NewFeatureVector(msg.GlobalFeatures)
+	localFeatures := wire.NewFeatureVector(msg.LocalFeatures)
+	unknown := append(globalFeatures.UnknownRequiredFeatures(), localFeatures.UnknownRequiredFeatures()...)
+	if len(unknown) > 0 {
+		log.Warnw("disconnecting peer with unknown required features", "peer", peer, "features", unknown)
+		err := errors.New("unknown required features")
+		peer.Disconnect(err)
+		return nil, err
+	}
+
+	if err := peer.ReceiveInit(msg.LNDIdentificationKey, globalFeatures, localFeatures); err != nil {
+		return nil, p2p.Misbehaving(p2p.ScoreMinor, err)
+	}
+
 	log.Infow(
 		"connecting lnd peers",
 		"pubkey",
@@ -49,6 +66,11 @@ func (h *HandshakeHandler) acceptInit(msg *wire.Init, peer *p2p.Peer) (lnwire.Me
 	if err != nil {
 		return nil, err
 	}
-	peer.LNDIdentity = msg.LNDIdentificationKey
+	peer.CompleteHandshake()
+
+	if err := h.peers.MarkSeen(peer.Identity, msg.LNDIdentificationKey); err != nil {
+		log.Warnw("failed to mark peer as seen", "peer", peer.Identity.CompressedHex(), "err", err)
+	}
+
 	return nil, nil
 }
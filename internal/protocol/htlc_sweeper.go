@@ -0,0 +1,220 @@
+package protocol
+
+import (
+	"github.com/kyokan/drawbridge/internal/wallet"
+	"github.com/kyokan/drawbridge/internal/ethclient"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/pkg/txout"
+	"math/big"
+	"bytes"
+	"sync"
+	"context"
+	"time"
+	"crypto/sha256"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// refundTimeout bounds how long a refund or redemption may stay unmined
+// before it is retried on a later poll.
+const refundTimeout = time.Minute * 30
+
+// HTLCSweeper settles the offered HTLCs that end up on chain when a channel is
+// force closed. HTLCs paying us are redeemed as soon as we know their
+// preimage, and HTLCs we are the timeout party for are refunded once their
+// delay has passed without the counterparty redeeming them.
+type HTLCSweeper struct {
+	km       *wallet.KeyManager
+	client   *ethclient.Client
+	db       *db.DB
+	inFlight map[common.Hash]bool
+	redeemed map[common.Hash]bool
+	mtx      sync.Mutex
+}
+
+func NewHTLCSweeper(km *wallet.KeyManager, client *ethclient.Client, db *db.DB) *HTLCSweeper {
+	return &HTLCSweeper{
+		km:       km,
+		client:   client,
+		db:       db,
+		inFlight: make(map[common.Hash]bool),
+		redeemed: make(map[common.Hash]bool),
+	}
+}
+
+// OnPoll looks at every unspent HTLC on each poll rather than just the new
+// ones, since HTLCs become refundable as blocks pass and redeemable once we
+// learn their preimage.
+func (h *HTLCSweeper) OnPoll(outputs *db.PolledOutputs) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	height, err := h.client.BlockHeight()
+	if err != nil {
+		log.Errorw("failed to get block height", "err", err.Error())
+		return
+	}
+
+	htlcs, err := h.db.Outputs.FindUnspentByType(txout.OutputOfferedHTLC)
+	if err != nil {
+		log.Errorw("failed to find unspent htlcs", "err", err.Error())
+		return
+	}
+
+	us := h.km.PublicKey().ETHAddress()
+	for _, out := range htlcs {
+		script := &txout.OfferedHTLC{}
+		if err := script.Decode(bytes.NewReader(out.Script[1:]), 0); err != nil {
+			log.Errorw("failed to decode htlc script", "outputId", out.ID.Hex(), "err", err.Error())
+			continue
+		}
+		if h.inFlight[out.ID] || h.redeemed[out.ID] {
+			continue
+		}
+
+		if script.RedemptionAddress == us {
+			if err := h.redeem(out, script); err != nil {
+				log.Errorw("failed to redeem htlc", "outputId", out.ID.Hex(), "err", err.Error())
+			}
+			continue
+		}
+		if script.TimeoutAddress != us {
+			continue
+		}
+		// the contract refuses the refund until a block after the delay
+		if height <= out.BlockNumber+script.Delay.Uint64() {
+			continue
+		}
+
+		refund, err := h.db.HTLCRefunds.FindByOutputId(out.ID)
+		if err != nil {
+			log.Errorw("failed to look up htlc refund", "outputId", out.ID.Hex(), "err", err.Error())
+			continue
+		}
+		if refund != nil {
+			continue
+		}
+
+		if err := h.refund(out); err != nil {
+			log.Errorw("failed to refund htlc", "outputId", out.ID.Hex(), "err", err.Error())
+		}
+	}
+}
+
+// redeem claims an HTLC paying us with the preimage stored for its swap or for
+// the channel HTLC it came from. HTLCs whose preimage we don't know are left
+// for the counterparty to refund.
+func (h *HTLCSweeper) redeem(out *db.ETHOutput, script *txout.OfferedHTLC) error {
+	preimage, err := findPreimage(h.db, script.PaymentHash)
+	if err != nil || preimage == nil {
+		return err
+	}
+
+	tx, err := h.client.RedeemHTLC(out.ID, out.Amount, *preimage)
+	if err != nil {
+		return err
+	}
+
+	log.Infow("broadcast htlc redemption", "outputId", out.ID.Hex(), "amount", out.Amount.Text(10), "txHash", tx.Hash().Hex())
+	h.inFlight[out.ID] = true
+	go h.awaitRedemption(out, tx)
+	return nil
+}
+
+// awaitRedemption remembers a redemption once it is mined, until the chainsaw
+// indexes the spend. Like refunds, redemptions that don't go through are
+// retried on the next poll.
+func (h *HTLCSweeper) awaitRedemption(out *db.ETHOutput, tx *ethclient.PendingTx) {
+	ctx, cancel := context.WithTimeout(context.Background(), refundTimeout)
+	defer cancel()
+	receipt, err := tx.Wait(ctx)
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	delete(h.inFlight, out.ID)
+	if err != nil {
+		log.Warnw("htlc redemption did not go through", "outputId", out.ID.Hex(), "txHash", tx.Hash().Hex(), "err", err.Error())
+		return
+	}
+
+	log.Infow("redeemed htlc", "outputId", out.ID.Hex(), "amount", out.Amount.Text(10), "txHash", receipt.TxHash.Hex())
+	h.redeemed[out.ID] = true
+}
+
+func (h *HTLCSweeper) refund(out *db.ETHOutput) error {
+	req := &txout.SpendRequest{
+		InputID: out.ID,
+		Witness: txout.NewOfferedHTLCTimeoutWitness(),
+		Values: []*big.Int{
+			out.Amount,
+		},
+		Outputs: []txout.Output{
+			txout.NewPayment(h.km.PublicKey().ETHAddress()),
+		},
+	}
+
+	sig, err := h.km.SignData(txout.OfferedHTLCTimeoutSigData(out.ID))
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.client.Spend(req, sig)
+	if err != nil {
+		return err
+	}
+
+	log.Infow("broadcast htlc refund", "outputId", out.ID.Hex(), "amount", out.Amount.Text(10), "txHash", tx.Hash().Hex())
+	h.inFlight[out.ID] = true
+	go h.awaitRefund(out, tx)
+	return nil
+}
+
+// awaitRefund records a refund once it is mined. A refund that reverts, is
+// dropped or takes longer than refundTimeout is forgotten, so that the output
+// is tried again on the next poll.
+func (h *HTLCSweeper) awaitRefund(out *db.ETHOutput, tx *ethclient.PendingTx) {
+	defer func() {
+		h.mtx.Lock()
+		delete(h.inFlight, out.ID)
+		h.mtx.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), refundTimeout)
+	defer cancel()
+	receipt, err := tx.Wait(ctx)
+	if err != nil {
+		log.Warnw("htlc refund did not go through", "outputId", out.ID.Hex(), "txHash", tx.Hash().Hex(), "err", err.Error())
+		return
+	}
+
+	log.Infow("refunded timed out htlc", "outputId", out.ID.Hex(), "amount", out.Amount.Text(10), "txHash", receipt.TxHash.Hex())
+	err = h.db.HTLCRefunds.Save(&db.HTLCRefund{
+		OutputID: out.ID,
+		TxHash:   receipt.TxHash,
+		Amount:   out.Amount,
+	})
+	if err != nil {
+		log.Errorw("failed to save htlc refund", "outputId", out.ID.Hex(), "err", err.Error())
+	}
+}
+
+// findPreimage looks up the preimage for paymentHash among our swaps and
+// fulfilled channel HTLCs. It returns nil if we don't know it.
+func findPreimage(d *db.DB, paymentHash common.Hash) (*common.Hash, error) {
+	swap, err := d.Swaps.FindByPaymentHash(paymentHash)
+	if err != nil {
+		return nil, err
+	}
+	if swap != nil && sha256.Sum256(swap.Preimage[:]) == paymentHash {
+		return &swap.Preimage, nil
+	}
+
+	htlc, err := d.Channels.FindFulfilledHTLC(paymentHash)
+	if err != nil {
+		return nil, err
+	}
+	if htlc != nil && sha256.Sum256(htlc.Preimage[:]) == paymentHash {
+		return &htlc.Preimage, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,122 @@
+package protocol
+
+import (
+	"testing"
+	"math/big"
+	"crypto/sha256"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/internal/wallet"
+	"github.com/kyokan/drawbridge/internal/ethclient"
+	"github.com/kyokan/drawbridge/pkg/txout"
+	"github.com/stretchr/testify/assert"
+)
+
+type memSwaps struct {
+	swaps []*db.Swap
+}
+
+func (m *memSwaps) Save(swap *db.Swap) error {
+	m.swaps = append(m.swaps, swap)
+	return nil
+}
+
+func (m *memSwaps) FindById(swapId common.Hash) (*db.Swap, error) {
+	for _, swap := range m.swaps {
+		if swap.ID == swapId {
+			return swap, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memSwaps) FindByPaymentHash(paymentHash common.Hash) (*db.Swap, error) {
+	for _, swap := range m.swaps {
+		if swap.PaymentHash == paymentHash {
+			return swap, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memSwaps) FindPending() ([]*db.Swap, error) {
+	return nil, nil
+}
+
+// unspentOutputs serves a fixed set of unspent outputs.
+type unspentOutputs struct {
+	db.Outputs
+	outputs []*db.ETHOutput
+}
+
+func (u *unspentOutputs) FindById(id common.Hash) (*db.ETHOutput, error) {
+	for _, output := range u.outputs {
+		if output.ID == id {
+			return output, nil
+		}
+	}
+	return nil, nil
+}
+
+func (u *unspentOutputs) FindUnspentByType(outputType txout.OutputType) ([]*db.ETHOutput, error) {
+	var out []*db.ETHOutput
+	for _, output := range u.outputs {
+		if output.Type == uint8(outputType) {
+			out = append(out, output)
+		}
+	}
+	return out, nil
+}
+
+func TestHTLCSweeper_RedeemsHTLCsPayingUs(t *testing.T) {
+	km, err := wallet.NewKeyManager("c87509a1c067bbde78beb793e6fa76530b6382a4c0241e5e4a9ec0a0f44dc0d3", big.NewInt(1337))
+	assert.Nil(t, err)
+	us := km.PublicKey().ETHAddress()
+	them := common.HexToAddress("0xf17f52151ebef6c7334fad080c5704d77216b732")
+
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{
+		us: {Balance: new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)},
+	})
+	txs := &memTransactions{}
+	client, err := ethclient.NewClientFromBackend(km, txs, sim, "0x345ca3e014aaf5dca488057592ee47305d9b3e10")
+	assert.Nil(t, err)
+
+	swapPreimage := common.HexToHash("0x05")
+	swapHash := common.Hash(sha256.Sum256(swapPreimage[:]))
+	htlcPreimage := common.HexToHash("0x07")
+	htlcHash := common.Hash(sha256.Sum256(htlcPreimage[:]))
+
+	// a swap whose invoice we paid, and a channel htlc that was fulfilled
+	// before the channel was force closed
+	swaps := &memSwaps{
+		swaps: []*db.Swap{
+			{ID: common.HexToHash("0x01"), PaymentHash: swapHash, Preimage: swapPreimage},
+			{ID: common.HexToHash("0x02"), PaymentHash: common.HexToHash("0x06")},
+		},
+	}
+	channels := &memChannels{
+		htlcs: []*db.ETHHTLC{
+			{ChannelID: common.HexToHash("0x01"), PaymentHash: htlcHash, Preimage: htlcPreimage, Fulfilled: true},
+		},
+	}
+	outputs := &unspentOutputs{
+		outputs: []*db.ETHOutput{
+			htlcOutput(t, common.HexToHash("0x10"), us, them, swapHash),
+			htlcOutput(t, common.HexToHash("0x11"), us, them, htlcHash),
+			htlcOutput(t, common.HexToHash("0x12"), us, them, common.HexToHash("0x06")),
+			htlcOutput(t, common.HexToHash("0x13"), them, us, swapHash),
+		},
+	}
+	sweeper := NewHTLCSweeper(km, client, &db.DB{Outputs: outputs, Channels: channels, Swaps: swaps})
+
+	sweeper.OnPoll(&db.PolledOutputs{})
+	// only the htlcs paying us whose preimage we know; the one we offered
+	// isn't refundable before its delay
+	assert.Len(t, txs.txs, 2)
+
+	// redemptions in flight aren't sent again
+	sweeper.OnPoll(&db.PolledOutputs{})
+	assert.Len(t, txs.txs, 2)
+}
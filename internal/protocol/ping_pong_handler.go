@@ -12,11 +12,11 @@ func (*PingPongHandler) CanAccept(msg lnwire.Message) bool {
 	return msg.MsgType() == lnwire.MsgPing || msg.MsgType() == lnwire.MsgPong
 }
 
-func (*PingPongHandler) Accept(envelope *p2p.Envelope) (lnwire.Message, error) {
+func (*PingPongHandler) Accept(envelope *p2p.Envelope) ([]lnwire.Message, error) {
 	msg := envelope.Msg
 	switch msg.MsgType() {
 	case lnwire.MsgPing:
-		return lnwire.NewPong(msg.(*lnwire.Ping).PaddingBytes), nil
+		return p2p.Reply(lnwire.NewPong(msg.(*lnwire.Ping).PaddingBytes), nil)
 	case lnwire.MsgPong:
 		return nil, nil
 	default:
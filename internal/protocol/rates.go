@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/kyokan/drawbridge/internal/conv"
+	"io/ioutil"
+	"math/big"
+)
+
+// rateUnit is the number of ETH-side base units a Rate is quoted against.
+var rateUnit = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+const basisPoints = 10000
+
+// Rate is what a node charges to swap BTC for ETH. BTCPerETH is in satoshis
+// per rateUnit of ETH, the fee is taken out of the BTC side, and Tolerance is
+// how far above our own quote a peer may ask before we reject the swap. Only
+// BTCPerETH and FeeBasisPoints are advertised.
+type Rate struct {
+	BTCPerETH            *big.Int
+	FeeBasisPoints       uint64
+	ToleranceBasisPoints uint64
+}
+
+// Quote returns the BTC amount we pay for ethAmount at this rate.
+func (r *Rate) Quote(ethAmount *big.Int) *big.Int {
+	gross := new(big.Int).Mul(ethAmount, r.BTCPerETH)
+	gross.Div(gross, rateUnit)
+	fee := new(big.Int).Mul(gross, new(big.Int).SetUint64(r.FeeBasisPoints))
+	fee.Div(fee, big.NewInt(basisPoints))
+	return gross.Sub(gross, fee)
+}
+
+// Accepts reports whether a peer asking for requested BTC in return for
+// ethAmount is within our tolerance of our own quote.
+func (r *Rate) Accepts(ethAmount *big.Int, requested *big.Int) bool {
+	max := new(big.Int).Mul(r.Quote(ethAmount), new(big.Int).SetUint64(basisPoints+r.ToleranceBasisPoints))
+	max.Div(max, big.NewInt(basisPoints))
+	return requested.Cmp(max) <= 0
+}
+
+// ReverseQuote returns the BTC amount we charge to send ethAmount at this rate.
+func (r *Rate) ReverseQuote(ethAmount *big.Int) *big.Int {
+	gross := new(big.Int).Mul(ethAmount, r.BTCPerETH)
+	gross.Div(gross, rateUnit)
+	fee := new(big.Int).Mul(gross, new(big.Int).SetUint64(r.FeeBasisPoints))
+	fee.Div(fee, big.NewInt(basisPoints))
+	return gross.Add(gross, fee)
+}
+
+// AcceptsReverse reports whether a peer offering offered BTC for ethAmount
+// is within our tolerance of our own reverse quote.
+func (r *Rate) AcceptsReverse(ethAmount *big.Int, offered *big.Int) bool {
+	tolerance := r.ToleranceBasisPoints
+	if tolerance > basisPoints {
+		tolerance = basisPoints
+	}
+
+	min := new(big.Int).Mul(r.ReverseQuote(ethAmount), new(big.Int).SetUint64(basisPoints-tolerance))
+	min.Div(min, big.NewInt(basisPoints))
+	return offered.Cmp(min) >= 0
+}
+
+// withinSlippage reports whether quoted is no more than maxSlippage basis
+// points below expected.
+func withinSlippage(quoted *big.Int, expected *big.Int, maxSlippage uint64) bool {
+	if maxSlippage > basisPoints {
+		maxSlippage = basisPoints
+	}
+
+	min := new(big.Int).Mul(expected, new(big.Int).SetUint64(basisPoints-maxSlippage))
+	min.Div(min, big.NewInt(basisPoints))
+	return quoted.Cmp(min) >= 0
+}
+
+// withinPaySlippage reports whether quoted is no more than maxSlippage basis
+// points above expected.
+func withinPaySlippage(quoted *big.Int, expected *big.Int, maxSlippage uint64) bool {
+	max := new(big.Int).Mul(expected, new(big.Int).SetUint64(basisPoints+maxSlippage))
+	max.Div(max, big.NewInt(basisPoints))
+	return quoted.Cmp(max) <= 0
+}
+
+type RateProvider interface {
+	Rate() (*Rate, error)
+}
+
+type StaticRateProvider struct {
+	rate *Rate
+}
+
+func NewStaticRateProvider(rate *Rate) *StaticRateProvider {
+	return &StaticRateProvider{
+		rate: rate,
+	}
+}
+
+func (s *StaticRateProvider) Rate() (*Rate, error) {
+	return s.rate, nil
+}
+
+// FileRateProvider reads the rate from a JSON file on every call, so that
+// operators can update it without restarting the node.
+type FileRateProvider struct {
+	path string
+}
+
+type rateFile struct {
+	BTCPerETH            string `json:"btcPerEth"`
+	FeeBasisPoints       uint64 `json:"feeBasisPoints"`
+	ToleranceBasisPoints uint64 `json:"toleranceBasisPoints"`
+}
+
+func NewFileRateProvider(path string) *FileRateProvider {
+	return &FileRateProvider{
+		path: path,
+	}
+}
+
+func (f *FileRateProvider) Rate() (*Rate, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw rateFile
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	btcPerETH, err := conv.StringToBig(raw.BTCPerETH)
+	if err != nil {
+		return nil, err
+	}
+	if btcPerETH.Sign() <= 0 {
+		return nil, errors.New("rate must be positive")
+	}
+	if raw.FeeBasisPoints > basisPoints {
+		return nil, errors.New("fee cannot exceed 100%")
+	}
+
+	return &Rate{
+		BTCPerETH:            btcPerETH,
+		FeeBasisPoints:       raw.FeeBasisPoints,
+		ToleranceBasisPoints: raw.ToleranceBasisPoints,
+	}, nil
+}
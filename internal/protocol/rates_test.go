@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"testing"
+	"math/big"
+	"io/ioutil"
+	"os"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRate_Quote(t *testing.T) {
+	rate := &Rate{
+		BTCPerETH:      big.NewInt(3000000),
+		FeeBasisPoints: 100,
+	}
+
+	// half a token at 3,000,000 sat/token less 1%
+	ethAmount := new(big.Int).Div(rateUnit, big.NewInt(2))
+	assert.Equal(t, int64(1485000), rate.Quote(ethAmount).Int64())
+}
+
+func TestRate_Accepts(t *testing.T) {
+	rate := &Rate{
+		BTCPerETH:            big.NewInt(1000000),
+		ToleranceBasisPoints: 50,
+	}
+
+	assert.True(t, rate.Accepts(rateUnit, big.NewInt(1000000)))
+	assert.True(t, rate.Accepts(rateUnit, big.NewInt(1005000)))
+	assert.False(t, rate.Accepts(rateUnit, big.NewInt(1005001)))
+}
+
+func TestRate_ReverseQuote(t *testing.T) {
+	rate := &Rate{
+		BTCPerETH:      big.NewInt(3000000),
+		FeeBasisPoints: 100,
+	}
+
+	ethAmount := new(big.Int).Div(rateUnit, big.NewInt(2))
+	assert.Equal(t, int64(1515000), rate.ReverseQuote(ethAmount).Int64())
+}
+
+func TestRate_AcceptsReverse(t *testing.T) {
+	rate := &Rate{
+		BTCPerETH:            big.NewInt(1000000),
+		ToleranceBasisPoints: 50,
+	}
+
+	assert.True(t, rate.AcceptsReverse(rateUnit, big.NewInt(1000000)))
+	assert.True(t, rate.AcceptsReverse(rateUnit, big.NewInt(995000)))
+	assert.False(t, rate.AcceptsReverse(rateUnit, big.NewInt(994999)))
+}
+
+func TestWithinSlippage(t *testing.T) {
+	assert.True(t, withinSlippage(big.NewInt(1000), big.NewInt(1000), 0))
+	assert.True(t, withinSlippage(big.NewInt(990), big.NewInt(1000), 100))
+	assert.False(t, withinSlippage(big.NewInt(989), big.NewInt(1000), 100))
+	assert.True(t, withinSlippage(big.NewInt(1200), big.NewInt(1000), 0))
+}
+
+func TestWithinPaySlippage(t *testing.T) {
+	assert.True(t, withinPaySlippage(big.NewInt(1000), big.NewInt(1000), 0))
+	assert.True(t, withinPaySlippage(big.NewInt(1010), big.NewInt(1000), 100))
+	assert.False(t, withinPaySlippage(big.NewInt(1011), big.NewInt(1000), 100))
+	assert.True(t, withinPaySlippage(big.NewInt(800), big.NewInt(1000), 0))
+}
+
+func TestFileRateProvider(t *testing.T) {
+	f, err := ioutil.TempFile("", "rates")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(`{"btcPerEth": "3000000", "feeBasisPoints": 30, "toleranceBasisPoints": 100}`)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	rate, err := NewFileRateProvider(f.Name()).Rate()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3000000), rate.BTCPerETH.Int64())
+	assert.Equal(t, uint64(30), rate.FeeBasisPoints)
+	assert.Equal(t, uint64(100), rate.ToleranceBasisPoints)
+}
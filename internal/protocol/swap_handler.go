@@ -2,7 +2,6 @@ package protocol
 
 import (
 	"github.com/kyokan/drawbridge/internal/lndclient"
-	"github.com/kyokan/drawbridge/internal/ethclient"
 	"sync"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/lightningnetwork/lnd/lnwire"
@@ -12,60 +11,102 @@ import (
 	"github.com/kyokan/drawbridge/internal/db"
 	"github.com/kyokan/drawbridge/pkg/wire"
 	"errors"
-	"github.com/kyokan/drawbridge/pkg/txout"
 	"github.com/kyokan/drawbridge/internal/wallet"
 	"crypto/sha256"
+	"encoding/hex"
 	"github.com/kyokan/drawbridge/internal/p2p"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"context"
+	"time"
 )
 
+// swapSendTimeout bounds how long swaps wait on a peer's full send queue.
+const swapSendTimeout = time.Second * 30
+
+// swapHTLCDelay is the number of blocks the ETH sender has to wait before it
+// can reclaim a swap HTLC that made it on chain. The receiver redeems it from
+// the HTLCSweeper, so it has to leave plenty of room for the commitment to
+// confirm and be polled, and it outlasts the commitment's own csvDelay.
+const swapHTLCDelay = 20 * csvDelay
+
+// swapTimeout is how long the ETH receiver waits for a swap to reach the
+// point where it pays the invoice before failing the swap's HTLC. It matches
+// the invoice expiry, so the invoice can't be paid after the HTLC is gone.
+const swapTimeout = time.Second * lndclient.InvoiceExpiry
+
 type SwapHandler struct {
-	peerBook     *p2p.PeerBook
-	lnd          *lndclient.Client
-	eth          *ethclient.Client
-	db           *db.DB
-	km           *wallet.KeyManager
-	mtx          sync.Mutex
-	pendingSwaps map[common.Hash]*pendingSwap
+	peerBook      *p2p.PeerBook
+	lnd           *lndclient.Client
+	commitments   *CommitmentHandler
+	db            *db.DB
+	km            *wallet.KeyManager
+	rates         RateProvider
+	mtx           sync.Mutex
+	pendingSwaps  map[common.Hash]*pendingSwap
+	pendingQuotes map[common.Hash]*pendingQuote
 }
 
-type pendingSwap struct {
-	SwapID       [32]byte
-	PaymentHash  [32]byte
-	ETHChannelID common.Hash
-	BTCChannelID uint64
+type pendingQuote struct {
+	Counterparty *crypto.PublicKey
 	ETHAmount    *big.Int
 	BTCAmount    *big.Int
-	ETHCommitSig crypto.Signature
-	Invoice      *lnrpc.Invoice
-	Preimage     [32]byte
+	MaxSlippage  uint64
+	Direction    db.SwapDirection
+}
+
+type pendingSwap struct {
+	SwapID         [32]byte
+	PaymentHash    [32]byte
+	ETHChannelID   common.Hash
+	BTCChannelID   uint64
+	ETHAmount      *big.Int
+	BTCAmount      *big.Int
+	Invoice        *lnrpc.Invoice
+	Preimage       [32]byte
+	PaymentRequest string
+	Counterparty   *crypto.PublicKey
+	IsInitiator    bool
+	Direction      db.SwapDirection
+	State          db.SwapState
+	Paying         bool
+	Executing      bool
+	ctx            context.Context
+	cancel         context.CancelFunc
 }
 
-func NewSwapHandler(pb *p2p.PeerBook, lnd *lndclient.Client, eth *ethclient.Client, d *db.DB, km *wallet.KeyManager) *SwapHandler {
+func NewSwapHandler(pb *p2p.PeerBook, lnd *lndclient.Client, commitments *CommitmentHandler, d *db.DB, km *wallet.KeyManager, rates RateProvider) *SwapHandler {
 	return &SwapHandler{
-		peerBook: pb,
-		lnd:lnd,
-		eth:eth,
-		db: d,
-		km:km,
-		pendingSwaps: make(map[common.Hash]*pendingSwap),
+		peerBook:      pb,
+		lnd:           lnd,
+		commitments:   commitments,
+		db:            d,
+		km:            km,
+		rates:         rates,
+		pendingSwaps:  make(map[common.Hash]*pendingSwap),
+		pendingQuotes: make(map[common.Hash]*pendingQuote),
 	}
 }
 
-func (s *SwapHandler) InitSwap(pub *crypto.PublicKey, ethAmount *big.Int, btcAmount *big.Int) error {
+// InitSwap asks the peer for a quote on ethAmount. The swap itself starts
+// once the peer quotes within maxSlippage basis points of btcAmount, which is
+// what we expect to receive for ETH-to-BTC swaps and to pay for BTC-to-ETH.
+func (s *SwapHandler) InitSwap(ctx context.Context, pub *crypto.PublicKey, direction db.SwapDirection, ethAmount *big.Int, btcAmount *big.Int, maxSlippage uint64) error {
 	peer := s.peerBook.FindPeer(pub)
 	if peer == nil {
 		return errors.New("peer not found")
 	}
 
-	swapId, err := crypto.Rand32()
+	wireDirection, err := toWireDirection(direction)
 	if err != nil {
 		return err
 	}
-	preimage, err := crypto.Rand32()
-	if err != nil {
-		return err
+	if !peer.HasFeature(wire.SwapQuotesOptional) {
+		return errors.New("peer does not support swap quotes")
+	}
+	if !peer.HasFeature(directionFeature(direction)) {
+		return errors.New("peer does not support swaps in that direction")
 	}
+
 	ethChan, err := s.db.Channels.FindByAmount(ethAmount)
 	if err != nil {
 		return err
@@ -73,112 +114,256 @@ func (s *SwapHandler) InitSwap(pub *crypto.PublicKey, ethAmount *big.Int, btcAmo
 	if ethChan == nil {
 		return errors.New("no suitable channel found")
 	}
-	paymentHash := sha256.Sum256(preimage[:])
 
-	spendReq := &txout.SpendRequest{
-		InputID: ethChan.ID,
-		Witness: txout.NewMultisigWitness(),
-		Values: []*big.Int{
-			ethAmount,
-		},
-		Outputs: []txout.Output{
-			&txout.OfferedHTLC{
-				Delay:             big.NewInt(5),
-				RedemptionAddress: ethChan.Counterparty,
-				TimeoutAddress:    s.km.PublicKey().ETHAddress(),
-				PaymentHash:       paymentHash,
-			},
-		},
-	}
-	sigHash, err := txout.SigData(spendReq)
-	if err != nil {
-		return err
-	}
-	sig, err := s.km.SignData(sigHash)
+	quoteId, err := crypto.Rand32()
 	if err != nil {
 		return err
 	}
 
 	s.mtx.Lock()
-	s.pendingSwaps[swapId] = &pendingSwap{
-		SwapID: swapId,
-		PaymentHash: paymentHash,
-		ETHChannelID: ethChan.ID,
-		ETHAmount: ethAmount,
-		BTCAmount: btcAmount,
-		ETHCommitSig: sig,
-		Preimage: preimage,
+	s.pendingQuotes[quoteId] = &pendingQuote{
+		Counterparty: pub,
+		ETHAmount:    ethAmount,
+		BTCAmount:    btcAmount,
+		MaxSlippage:  maxSlippage,
+		Direction:    direction,
 	}
 	s.mtx.Unlock()
 
-	msg := &wire.InitiateSwap{
-		SwapID: swapId,
-		PaymentHash: paymentHash,
-		ETHChannelID: ethChan.ID,
+	return peer.Send(ctx, &wire.RequestQuote{
+		QuoteID:   quoteId,
 		ETHAmount: ethAmount,
-		ETHCommitmentSignature: sig,
-		SendingAddress: s.km.PublicKey(),
+		Direction: wireDirection,
+	})
+}
+
+func (s *SwapHandler) startSwap(pub *crypto.PublicKey, ethAmount *big.Int, btcAmount *big.Int) (*wire.InitiateSwap, error) {
+	swapId, err := crypto.Rand32()
+	if err != nil {
+		return nil, err
+	}
+	preimage, err := crypto.Rand32()
+	if err != nil {
+		return nil, err
+	}
+	ethChan, err := s.db.Channels.FindByAmount(ethAmount)
+	if err != nil {
+		return nil, err
+	}
+	if ethChan == nil {
+		return nil, errors.New("no suitable channel found")
+	}
+	paymentHash := sha256.Sum256(preimage[:])
+
+	// the HTLC is offered ahead of initiate_swap, so the peer has it in its
+	// commitment by the time it decides whether to pay our invoice
+	ctx, cancel := context.WithTimeout(context.Background(), swapSendTimeout)
+	defer cancel()
+	if _, err := s.commitments.AddHTLC(ctx, pub, ethChan.ID, ethAmount, paymentHash, swapHTLCDelay); err != nil {
+		return nil, err
+	}
+
+	swap := &pendingSwap{
+		SwapID:       swapId,
+		PaymentHash:  paymentHash,
+		ETHChannelID: ethChan.ID,
+		ETHAmount:    ethAmount,
+		BTCAmount:    btcAmount,
+		Preimage:     preimage,
+		Counterparty: pub,
+		IsInitiator:  true,
+		Direction:    db.SwapETHToBTC,
+	}
+	err = s.persist(swap, db.SwapInitiated)
+	if err != nil {
+		return nil, err
+	}
+
+	s.addSwap(swap)
+
+	return &wire.InitiateSwap{
+		SwapID:          swapId,
+		PaymentHash:     paymentHash,
+		ETHChannelID:    ethChan.ID,
+		ETHAmount:       ethAmount,
+		SendingAddress:  s.km.PublicKey(),
 		RequestedAmount: btcAmount,
+	}, nil
+}
+
+// startReverseSwap asks the peer to lock ethAmount into an HTLC for us in
+// return for btcAmount over Lightning. The peer picks the preimage, so we
+// learn nothing about the HTLC until it replies with its invoice.
+func (s *SwapHandler) startReverseSwap(pub *crypto.PublicKey, ethAmount *big.Int, btcAmount *big.Int) (*wire.InitiateReverseSwap, error) {
+	swapId, err := crypto.Rand32()
+	if err != nil {
+		return nil, err
 	}
-	return peer.Send(msg)
+	ethChan, err := s.db.Channels.FindByAmount(ethAmount)
+	if err != nil {
+		return nil, err
+	}
+	if ethChan == nil {
+		return nil, errors.New("no suitable channel found")
+	}
+
+	swap := &pendingSwap{
+		SwapID:       swapId,
+		ETHChannelID: ethChan.ID,
+		ETHAmount:    ethAmount,
+		BTCAmount:    btcAmount,
+		Counterparty: pub,
+		IsInitiator:  true,
+		Direction:    db.SwapBTCToETH,
+	}
+	err = s.persist(swap, db.SwapInitiated)
+	if err != nil {
+		return nil, err
+	}
+
+	s.addSwap(swap)
+	s.scheduleTimeout(swap)
+
+	return &wire.InitiateReverseSwap{
+		SwapID:           swapId,
+		ETHChannelID:     ethChan.ID,
+		ETHAmount:        ethAmount,
+		ReceivingAddress: s.km.PublicKey(),
+		OfferedAmount:    btcAmount,
+	}, nil
 }
 
 func (s *SwapHandler) CanAccept(msg lnwire.Message) bool {
 	switch msg.MsgType() {
-	case wire.MsgInitiateSwap, wire.MsgSwapAccepted, wire.MsgInvoiceGenerated, wire.MsgInvoiceExecuted:
+	case wire.MsgRequestQuote, wire.MsgSwapQuote, wire.MsgInitiateSwap, wire.MsgSwapAccepted, wire.MsgInvoiceGenerated,
+		wire.MsgInvoiceExecuted, wire.MsgInitiateReverseSwap, wire.MsgReverseSwapAccepted:
 		return true
 	default:
 		return false
 	}
 }
 
-func (s *SwapHandler) Accept(envelope *p2p.Envelope) (lnwire.Message, error) {
+func (s *SwapHandler) Accept(envelope *p2p.Envelope) ([]lnwire.Message, error) {
 	msg := envelope.Msg
 	switch msg.MsgType() {
+	case wire.MsgRequestQuote:
+		return p2p.Reply(s.onRequestQuote(msg.(*wire.RequestQuote)))
+	case wire.MsgSwapQuote:
+		return p2p.Reply(s.onSwapQuote(msg.(*wire.SwapQuote), envelope.Peer))
 	case wire.MsgInitiateSwap:
-		return s.onInitiateSwap(msg.(*wire.InitiateSwap), envelope.Peer)
+		return p2p.Reply(s.onInitiateSwap(msg.(*wire.InitiateSwap), envelope.Peer))
 	case wire.MsgSwapAccepted:
-		return s.onSwapAccepted(msg.(*wire.SwapAccepted), envelope.Peer)
+		return p2p.Reply(s.onSwapAccepted(msg.(*wire.SwapAccepted), envelope.Peer))
 	case wire.MsgInvoiceGenerated:
-		return s.onInvoiceGenerated(msg.(*wire.InvoiceGenerated))
+		return p2p.Reply(s.onInvoiceGenerated(msg.(*wire.InvoiceGenerated), envelope))
 	case wire.MsgInvoiceExecuted:
-		return s.onInvoiceExecuted(msg.(*wire.InvoiceExecuted))
+		return p2p.Reply(s.onInvoiceExecuted(msg.(*wire.InvoiceExecuted)))
+	case wire.MsgInitiateReverseSwap:
+		return p2p.Reply(s.onInitiateReverseSwap(msg.(*wire.InitiateReverseSwap), envelope.Peer))
+	case wire.MsgReverseSwapAccepted:
+		return p2p.Reply(s.onReverseSwapAccepted(msg.(*wire.ReverseSwapAccepted), envelope.Peer))
 	default:
 		return nil, errors.New("unknown message type")
 	}
 }
 
+func (s *SwapHandler) onRequestQuote(msg *wire.RequestQuote) (*wire.SwapQuote, error) {
+	rate, err := s.rates.Rate()
+	if err != nil {
+		return nil, err
+	}
+
+	var btcAmount *big.Int
+	switch msg.Direction {
+	case wire.SwapDirectionETHToBTC:
+		btcAmount = rate.Quote(msg.ETHAmount)
+	case wire.SwapDirectionBTCToETH:
+		btcAmount = rate.ReverseQuote(msg.ETHAmount)
+	default:
+		return nil, errors.New("unknown swap direction")
+	}
+
+	return &wire.SwapQuote{
+		QuoteID:        msg.QuoteID,
+		ETHAmount:      msg.ETHAmount,
+		BTCAmount:      btcAmount,
+		Rate:           rate.BTCPerETH,
+		FeeBasisPoints: rate.FeeBasisPoints,
+	}, nil
+}
+
+func (s *SwapHandler) onSwapQuote(msg *wire.SwapQuote, peer *p2p.Peer) (lnwire.Message, error) {
+	s.mtx.Lock()
+	quote, exists := s.pendingQuotes[msg.QuoteID]
+	if !exists {
+		defer s.mtx.Unlock()
+		return nil, errors.New("no quote with that ID found")
+	}
+	delete(s.pendingQuotes, msg.QuoteID)
+	s.mtx.Unlock()
+
+	if !quote.Counterparty.Equal(peer.Identity) {
+		return nil, errors.New("quote came from the wrong peer")
+	}
+	if msg.ETHAmount.Cmp(quote.ETHAmount) != 0 {
+		return nil, errors.New("quote is for a different amount")
+	}
+
+	var ok bool
+	if quote.Direction == db.SwapBTCToETH {
+		ok = withinPaySlippage(msg.BTCAmount, quote.BTCAmount, quote.MaxSlippage)
+	} else {
+		ok = withinSlippage(msg.BTCAmount, quote.BTCAmount, quote.MaxSlippage)
+	}
+	if !ok {
+		log.Warnw("rejecting quote outside max slippage", "expected", quote.BTCAmount.Text(10),
+			"quoted", msg.BTCAmount.Text(10), "rate", msg.Rate.Text(10), "feeBps", msg.FeeBasisPoints,
+			"direction", quote.Direction)
+		return nil, p2p.Warn(errors.New("quote is outside max slippage"))
+	}
+
+	if quote.Direction == db.SwapBTCToETH {
+		res, err := s.startReverseSwap(quote.Counterparty, quote.ETHAmount, msg.BTCAmount)
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+
+	res, err := s.startSwap(quote.Counterparty, quote.ETHAmount, msg.BTCAmount)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 func (s *SwapHandler) onInitiateSwap(msg *wire.InitiateSwap, peer *p2p.Peer) (*wire.SwapAccepted, error) {
 	s.mtx.Lock()
 	_, exists := s.pendingSwaps[msg.SwapID]
 	if exists {
 		defer s.mtx.Unlock()
-		return nil, errors.New("duplicate swap id")
+		return nil, p2p.Misbehaving(p2p.ScoreMinor, errors.New("duplicate swap id"))
 	}
 	s.mtx.Unlock()
 
-	spendReq := &txout.SpendRequest{
-		InputID: msg.ETHChannelID,
-		Witness: txout.NewMultisigWitness(),
-		Values: []*big.Int{
-			msg.ETHAmount,
-		},
-		Outputs: []txout.Output{
-			&txout.OfferedHTLC{
-				Delay:             big.NewInt(5),
-				RedemptionAddress: s.km.PublicKey().ETHAddress(),
-				TimeoutAddress:    msg.SendingAddress.ETHAddress(),
-				PaymentHash:       msg.PaymentHash,
-			},
-		},
+	rate, err := s.rates.Rate()
+	if err != nil {
+		return nil, err
+	}
+	if !rate.Accepts(msg.ETHAmount, msg.RequestedAmount) {
+		return nil, errors.New("requested amount is outside of our rate tolerance")
 	}
-	sigHash, err := txout.SigData(spendReq)
+
+	ethChan, err := s.db.Channels.FindById(msg.ETHChannelID)
 	if err != nil {
 		return nil, err
 	}
-	ok := msg.ETHCommitmentSignature.Verify(sigHash, msg.SendingAddress)
-	if !ok {
-		return nil, errors.New("signature verification failed")
+	if ethChan == nil {
+		return nil, errors.New("no channel with that id found")
+	}
+
+	if ethChan.Counterparty != msg.SendingAddress.ETHAddress() {
+		return nil, errors.New("channel is not with the sending address")
 	}
 
 	btcChan, err := s.lnd.ChannelByCounterparty(peer.LNDIdentity)
@@ -189,24 +374,156 @@ func (s *SwapHandler) onInitiateSwap(msg *wire.InitiateSwap, peer *p2p.Peer) (*w
 		return nil, errors.New("no suitable lnd channel found")
 	}
 
-	s.mtx.Lock()
-	s.pendingSwaps[msg.SwapID] = &pendingSwap{
-		SwapID: msg.SwapID,
-		PaymentHash: msg.PaymentHash,
+	swap := &pendingSwap{
+		SwapID:       msg.SwapID,
+		PaymentHash:  msg.PaymentHash,
 		ETHChannelID: msg.ETHChannelID,
-		ETHAmount: msg.ETHAmount,
-		BTCAmount: msg.RequestedAmount,
-		ETHCommitSig: msg.ETHCommitmentSignature,
+		ETHAmount:    msg.ETHAmount,
+		BTCAmount:    msg.RequestedAmount,
 		BTCChannelID: btcChan.ChanId,
+		Counterparty: peer.Identity,
+		IsInitiator:  false,
+		Direction:    db.SwapETHToBTC,
 	}
-	s.mtx.Unlock()
+	err = s.persist(swap, db.SwapAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	s.addSwap(swap)
+	s.scheduleTimeout(swap)
 
 	return &wire.SwapAccepted{
-		SwapID: msg.SwapID,
+		SwapID:       msg.SwapID,
 		BTCChannelID: btcChan.ChanId,
 	}, nil
 }
 
+// onInitiateReverseSwap sets up our side of a BTC-to-ETH swap: we hold the
+// preimage, invoice the peer for the BTC, and offer them an HTLC on the
+// channel that they can fulfill once they learn the preimage by paying.
+func (s *SwapHandler) onInitiateReverseSwap(msg *wire.InitiateReverseSwap, peer *p2p.Peer) (*wire.ReverseSwapAccepted, error) {
+	s.mtx.Lock()
+	_, exists := s.pendingSwaps[msg.SwapID]
+	if exists {
+		defer s.mtx.Unlock()
+		return nil, p2p.Misbehaving(p2p.ScoreMinor, errors.New("duplicate swap id"))
+	}
+	s.mtx.Unlock()
+
+	rate, err := s.rates.Rate()
+	if err != nil {
+		return nil, err
+	}
+	if !rate.AcceptsReverse(msg.ETHAmount, msg.OfferedAmount) {
+		return nil, errors.New("offered amount is outside of our rate tolerance")
+	}
+
+	ethChan, err := s.db.Channels.FindById(msg.ETHChannelID)
+	if err != nil {
+		return nil, err
+	}
+	if ethChan == nil {
+		return nil, errors.New("no channel with that id found")
+	}
+	if ethChan.Counterparty != msg.ReceivingAddress.ETHAddress() {
+		return nil, errors.New("channel is not with the receiving address")
+	}
+	if ethChan.LocalBalance.Cmp(msg.ETHAmount) < 0 {
+		return nil, errors.New("insufficient channel balance")
+	}
+
+	btcChan, err := s.lnd.ChannelByCounterparty(peer.LNDIdentity)
+	if err != nil {
+		return nil, err
+	}
+	if btcChan == nil {
+		return nil, errors.New("no suitable lnd channel found")
+	}
+
+	preimage, err := crypto.Rand32()
+	if err != nil {
+		return nil, err
+	}
+	paymentHash := sha256.Sum256(preimage[:])
+
+	invoice, err := s.lnd.AddInvoice(msg.OfferedAmount.Int64(), preimage[:])
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(peer.Context(), swapSendTimeout)
+	defer cancel()
+	if _, err := s.commitments.AddHTLC(ctx, peer.Identity, ethChan.ID, msg.ETHAmount, paymentHash, swapHTLCDelay); err != nil {
+		return nil, err
+	}
+
+	swap := &pendingSwap{
+		SwapID:         msg.SwapID,
+		PaymentHash:    paymentHash,
+		ETHChannelID:   msg.ETHChannelID,
+		ETHAmount:      msg.ETHAmount,
+		BTCAmount:      msg.OfferedAmount,
+		BTCChannelID:   btcChan.ChanId,
+		Preimage:       preimage,
+		PaymentRequest: invoice.PaymentRequest,
+		Counterparty:   peer.Identity,
+		IsInitiator:    false,
+		Direction:      db.SwapBTCToETH,
+	}
+	err = s.persist(swap, db.SwapAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	s.addSwap(swap)
+
+	return &wire.ReverseSwapAccepted{
+		SwapID:         msg.SwapID,
+		PaymentHash:    paymentHash,
+		PaymentRequest: invoice.PaymentRequest,
+	}, nil
+}
+
+func (s *SwapHandler) onReverseSwapAccepted(msg *wire.ReverseSwapAccepted, peer *p2p.Peer) (lnwire.Message, error) {
+	s.mtx.Lock()
+	swap, exists := s.pendingSwaps[msg.SwapID]
+	if !exists {
+		defer s.mtx.Unlock()
+		return nil, errors.New("no swap with that ID found")
+	}
+	s.mtx.Unlock()
+
+	if !swap.Counterparty.Equal(peer.Identity) {
+		return nil, errors.New("swap accepted by the wrong peer")
+	}
+
+	payReq, err := s.lnd.DecodePayReq(msg.PaymentRequest)
+	if err != nil {
+		return nil, err
+	}
+	if payReq.PaymentHash != hex.EncodeToString(msg.PaymentHash[:]) {
+		return nil, errors.New("invoice is for a different payment hash")
+	}
+	if payReq.NumSatoshis != swap.BTCAmount.Int64() {
+		return nil, errors.New("invoice is for a different amount")
+	}
+
+	s.mtx.Lock()
+	swap.PaymentHash = msg.PaymentHash
+	swap.PaymentRequest = msg.PaymentRequest
+	swap.Paying = true
+	s.mtx.Unlock()
+
+	err = s.persist(swap, db.SwapInvoiceGenerated)
+	if err != nil {
+		return nil, err
+	}
+
+	go s.pay(peer.Context(), peer, swap)
+	return nil, nil
+}
+
 func (s *SwapHandler) onSwapAccepted(msg *wire.SwapAccepted, peer *p2p.Peer) (*wire.InvoiceGenerated, error) {
 	s.mtx.Lock()
 	swap, exists := s.pendingSwaps[msg.SwapID]
@@ -223,33 +540,93 @@ func (s *SwapHandler) onSwapAccepted(msg *wire.SwapAccepted, peer *p2p.Peer) (*w
 		return nil, err
 	}
 
+	s.mtx.Lock()
+	swap.BTCChannelID = msg.BTCChannelID
+	swap.PaymentRequest = res.PaymentRequest
+	s.mtx.Unlock()
+	err = s.persist(swap, db.SwapInvoiceGenerated)
+	if err != nil {
+		return nil, err
+	}
+
 	return &wire.InvoiceGenerated{
-		SwapID: swap.SwapID,
+		SwapID:         swap.SwapID,
 		PaymentRequest: res.PaymentRequest,
 	}, nil
 }
 
-func (s *SwapHandler) onInvoiceGenerated(msg *wire.InvoiceGenerated) (*wire.InvoiceExecuted, error) {
+// onInvoiceGenerated pays the peer's invoice once its HTLC is locked in.
+// Payments can take a while, so invoice_executed follows separately.
+func (s *SwapHandler) onInvoiceGenerated(msg *wire.InvoiceGenerated, envelope *p2p.Envelope) (lnwire.Message, error) {
 	s.mtx.Lock()
 	swap, exists := s.pendingSwaps[msg.SwapID]
 	if !exists {
 		defer s.mtx.Unlock()
 		return nil, errors.New("no swap with that ID found")
 	}
+	if !swap.Counterparty.Equal(envelope.Peer.Identity) {
+		defer s.mtx.Unlock()
+		return nil, errors.New("invoice came from the wrong peer")
+	}
+	if swap.Paying {
+		defer s.mtx.Unlock()
+		return nil, errors.New("swap invoice is already being paid")
+	}
+	swap.PaymentRequest = msg.PaymentRequest
+	swap.Paying = true
 	s.mtx.Unlock()
 
-	res, err := s.lnd.PayInvoice(msg.PaymentRequest)
+	// record the invoice before paying it so that a crash mid-payment
+	// can be recovered by Resume
+	err := s.persist(swap, db.SwapInvoiceGenerated)
 	if err != nil {
+		s.mtx.Lock()
+		swap.Paying = false
+		s.mtx.Unlock()
 		return nil, err
 	}
 
+	envelope.Go(func(ctx context.Context, sender p2p.Sender) {
+		s.pay(ctx, sender, swap)
+	})
+	return nil, nil
+}
+
+func (s *SwapHandler) executeInvoice(swap *pendingSwap) (*wire.InvoiceExecuted, error) {
+	htlc, err := s.lockedInHTLC(swap)
+	if err != nil {
+		return nil, err
+	}
+	if htlc.Fulfilled {
+		return nil, errors.New("swap htlc is already fulfilled")
+	}
+
+	res, err := s.lnd.PayInvoice(swap.PaymentRequest)
+	if err != nil {
+		return nil, err
+	}
+	if res.PaymentError != "" {
+		return nil, errors.New(res.PaymentError)
+	}
+
 	log.Infow("successfully received payment preimage", "preimage", hexutil.Encode(res.PaymentPreimage))
+	return s.invoicePaid(swap, res.PaymentPreimage)
+}
 
+// invoicePaid records the preimage our payment of swap's invoice revealed and
+// goes on to redeem the ETH side.
+func (s *SwapHandler) invoicePaid(swap *pendingSwap, preimage []byte) (*wire.InvoiceExecuted, error) {
 	s.mtx.Lock()
-	delete(s.pendingSwaps, msg.SwapID)
+	copy(swap.Preimage[:], preimage)
 	s.mtx.Unlock()
+	err := s.persist(swap, db.SwapInvoiceExecuted)
+	if err != nil {
+		return nil, err
+	}
+
+	s.removeSwap(swap)
 
-	// TODO: logging and persistence
+	go s.redeem(swap)
 
 	return &wire.InvoiceExecuted{
 		SwapID: swap.SwapID,
@@ -258,12 +635,431 @@ func (s *SwapHandler) onInvoiceGenerated(msg *wire.InvoiceGenerated) (*wire.Invo
 
 func (s *SwapHandler) onInvoiceExecuted(msg *wire.InvoiceExecuted) (lnwire.Message, error) {
 	s.mtx.Lock()
-	_, exists := s.pendingSwaps[msg.SwapID]
+	swap, exists := s.pendingSwaps[msg.SwapID]
 	if !exists {
 		defer s.mtx.Unlock()
 		return nil, errors.New("no swap with that ID found")
 	}
-	delete(s.pendingSwaps, msg.SwapID)
 	s.mtx.Unlock()
+
+	err := s.persist(swap, db.SwapInvoiceExecuted)
+	if err != nil {
+		return nil, err
+	}
+
+	s.removeSwap(swap)
 	return nil, nil
-}
\ No newline at end of file
+}
+
+// Fail abandons a quote or swap the peer gave up on. If we were offered the
+// swap's HTLC we fail it back to the peer, unless we are already paying the
+// invoice, in which case the swap carries on.
+func (s *SwapHandler) Fail(peer *crypto.PublicKey, id common.Hash, reason string) {
+	s.mtx.Lock()
+	if quote, exists := s.pendingQuotes[id]; exists && quote.Counterparty.Equal(peer) {
+		delete(s.pendingQuotes, id)
+	}
+	swap, exists := s.pendingSwaps[id]
+	s.mtx.Unlock()
+
+	if !exists || !swap.Counterparty.Equal(peer) {
+		return
+	}
+
+	s.failSwap(swap, reason, false)
+}
+
+// scheduleTimeout fails swap if we haven't started paying its invoice within
+// swapTimeout. Only the ETH receiver can give the HTLC back, so only its swaps
+// time out.
+func (s *SwapHandler) scheduleTimeout(swap *pendingSwap) {
+	if !receivesETH(swap) {
+		return
+	}
+
+	time.AfterFunc(swapTimeout, func() {
+		s.mtx.Lock()
+		current, exists := s.pendingSwaps[swap.SwapID]
+		executing := swap.Executing
+		s.mtx.Unlock()
+		if !exists || current != swap {
+			return
+		}
+		// a payment that fails leaves the swap to time out again
+		if executing {
+			s.scheduleTimeout(swap)
+			return
+		}
+
+		s.failSwap(swap, "swap timed out", true)
+	})
+}
+
+// failSwap marks swap failed and, if we were offered its HTLC, fails that back
+// to the peer. notify tells the peer about it, for failures that start on our
+// side. Swaps whose invoice we may have paid are left to carry on.
+func (s *SwapHandler) failSwap(swap *pendingSwap, reason string, notify bool) {
+	swapId := hexutil.Encode(swap.SwapID[:])
+
+	s.mtx.Lock()
+	state := swap.State
+	s.mtx.Unlock()
+
+	if receivesETH(swap) && state == db.SwapInvoiceGenerated {
+		// the payment may have gone through before we last shut down
+		payment, err := s.lnd.FindPayment(swap.PaymentHash)
+		if err != nil || payment != nil {
+			log.Warnw("not failing swap whose invoice may have been paid", "swapId", swapId, "reason", reason)
+			return
+		}
+	}
+
+	// removing the swap stops a payment that is still waiting on the HTLC,
+	// and one that already started keeps the swap going
+	s.mtx.Lock()
+	if swap.Executing || swap.State == db.SwapInvoiceExecuted || swap.State == db.SwapHTLCRedeemed {
+		s.mtx.Unlock()
+		log.Warnw("not failing swap whose invoice is being paid", "swapId", swapId, "reason", reason)
+		return
+	}
+	if current, exists := s.pendingSwaps[swap.SwapID]; !exists || current != swap {
+		s.mtx.Unlock()
+		return
+	}
+	delete(s.pendingSwaps, swap.SwapID)
+	swap.cancel()
+	s.mtx.Unlock()
+
+	log.Infow("failing swap", "swapId", swapId, "reason", reason)
+	if err := s.persist(swap, db.SwapFailed); err != nil {
+		log.Errorw("failed to persist failed swap", "swapId", swapId, "err", err.Error())
+	}
+
+	if notify {
+		if peer := s.peerBook.FindPeer(swap.Counterparty); peer != nil {
+			ctx, cancel := context.WithTimeout(peer.Context(), swapSendTimeout)
+			err := peer.Send(ctx, wire.NewError(swap.SwapID, errors.New(reason)))
+			cancel()
+			if err != nil {
+				log.Errorw("failed to tell peer swap failed", "swapId", swapId, "err", err.Error())
+			}
+		}
+	}
+
+	if receivesETH(swap) && swap.PaymentHash != [32]byte{} {
+		go s.failHTLC(swap)
+	}
+}
+
+// failHTLC gives the HTLC of a failed swap back to the peer. The peer may
+// still be committing to it, so it waits for the HTLC to be locked in first.
+func (s *SwapHandler) failHTLC(swap *pendingSwap) {
+	swapId := hexutil.Encode(swap.SwapID[:])
+	htlc, err := s.awaitHTLC(context.Background(), swap)
+	if err != nil {
+		log.Errorw("failed to find htlc of failed swap", "swapId", swapId, "err", err.Error())
+		return
+	}
+	if htlc.Fulfilled {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), swapSendTimeout)
+	defer cancel()
+	if err := s.commitments.FailHTLC(ctx, swap.Counterparty, swap.ETHChannelID, htlc.ID); err != nil {
+		log.Errorw("failed to fail swap htlc", "swapId", swapId, "err", err.Error())
+		return
+	}
+	log.Infow("failed swap htlc", "swapId", swapId, "htlcId", htlc.ID)
+}
+
+// OnDisconnect drops the quotes we asked the peer for, since its answers
+// will never arrive. Swaps are left alone and resume when the peer returns.
+func (s *SwapHandler) OnDisconnect(peer *p2p.Peer, reason error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for id, quote := range s.pendingQuotes {
+		if quote.Counterparty.Equal(peer.Identity) {
+			delete(s.pendingQuotes, id)
+		}
+	}
+}
+
+// Resume reloads every swap that had not completed when the node last shut
+// down. Both paying an invoice and fulfilling an HTLC end with messages to the
+// counterparty, so swaps carry on once it connects; see OnConnect.
+func (s *SwapHandler) Resume() error {
+	swaps, err := s.db.Swaps.FindPending()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range swaps {
+		swap := swapFromRecord(record)
+
+		s.addSwap(swap)
+		if swap.State != db.SwapInvoiceExecuted {
+			s.scheduleTimeout(swap)
+		}
+
+		log.Infow("resuming swap", "swapId", hexutil.Encode(swap.SwapID[:]), "state", swap.State)
+	}
+
+	return nil
+}
+
+// OnConnect picks up the resumed swaps that are waiting on us to pay the
+// peer's invoice or to fulfill its HTLC.
+func (s *SwapHandler) OnConnect(peer *p2p.Peer) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for id, swap := range s.pendingSwaps {
+		if swap.Paying || !swap.Counterparty.Equal(peer.Identity) {
+			continue
+		}
+
+		if receivesETH(swap) && swap.State == db.SwapInvoiceExecuted {
+			delete(s.pendingSwaps, id)
+			swap.cancel()
+			go s.redeem(swap)
+			continue
+		}
+		if receivesETH(swap) && swap.State == db.SwapInvoiceGenerated {
+			swap.Paying = true
+			go s.pay(peer.Context(), peer, swap)
+		}
+	}
+}
+
+// pay pays swap's invoice and tells the peer through sender. The peer offers
+// the HTLC before sending its invoice, but could still broadcast a commitment
+// without it until it revokes that, so we wait for the HTLC to be locked in
+// first. The payment may have gone through before we shut down, in which case
+// lnd refuses to pay again, so we take the preimage from the earlier payment
+// instead.
+func (s *SwapHandler) pay(ctx context.Context, sender p2p.Sender, swap *pendingSwap) {
+	swapId := hexutil.Encode(swap.SwapID[:])
+	defer func() {
+		s.mtx.Lock()
+		swap.Paying = false
+		swap.Executing = false
+		s.mtx.Unlock()
+	}()
+
+	if _, err := s.awaitHTLC(swap.ctx, swap); err != nil {
+		log.Errorw("failed to lock in swap htlc", "swapId", swapId, "err", err.Error())
+		return
+	}
+
+	s.mtx.Lock()
+	if swap.ctx.Err() != nil {
+		s.mtx.Unlock()
+		log.Infow("not paying invoice of failed swap", "swapId", swapId)
+		return
+	}
+	swap.Executing = true
+	s.mtx.Unlock()
+
+	var res *wire.InvoiceExecuted
+	payment, err := s.lnd.FindPayment(swap.PaymentHash)
+	if err == nil && payment != nil {
+		var preimage []byte
+		preimage, err = hex.DecodeString(payment.PaymentPreimage)
+		if err == nil {
+			log.Infow("recovered preimage of earlier swap payment", "swapId", swapId)
+			res, err = s.invoicePaid(swap, preimage)
+		}
+	} else if err == nil {
+		res, err = s.executeInvoice(swap)
+	}
+	if err != nil {
+		log.Errorw("failed to pay swap invoice", "swapId", swapId, "err", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, swapSendTimeout)
+	defer cancel()
+	if err := sender.Send(ctx, res); err != nil {
+		log.Errorw("failed to send invoice executed", "swapId", swapId, "err", err.Error())
+	}
+}
+
+// redeem claims the ETH side of a swap we received once we hold the preimage
+// by fulfilling the peer's HTLC, which moves its amount over to our side of
+// the channel. If the peer can't be reached the swap is put back, and the
+// next OnConnect tries again.
+func (s *SwapHandler) redeem(swap *pendingSwap) {
+	swapId := hexutil.Encode(swap.SwapID[:])
+	ethChan, err := s.db.Channels.FindById(swap.ETHChannelID)
+	if err == nil && ethChan != nil && ethChan.IsClosed {
+		log.Infow("swap channel is closed, leaving its htlc to be redeemed on chain", "swapId", swapId)
+		return
+	}
+
+	htlc, err := s.lockedInHTLC(swap)
+	if err != nil {
+		log.Errorw("failed to find swap htlc", "swapId", swapId, "err", err.Error())
+		return
+	}
+	if htlc.Fulfilled {
+		// fulfilled before we last shut down
+		if err := s.persist(swap, db.SwapHTLCRedeemed); err != nil {
+			log.Errorw("failed to persist redeemed swap", "swapId", swapId, "err", err.Error())
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), swapSendTimeout)
+	defer cancel()
+	err = s.commitments.FulfillHTLC(ctx, swap.Counterparty, swap.ETHChannelID, htlc.ID, swap.Preimage)
+	if err != nil {
+		log.Errorw("failed to fulfill swap htlc", "swapId", swapId, "err", err.Error())
+		s.addSwap(swap)
+		return
+	}
+	log.Infow("fulfilled swap htlc", "swapId", swapId, "htlcId", htlc.ID)
+
+	if err := s.persist(swap, db.SwapHTLCRedeemed); err != nil {
+		log.Errorw("failed to persist redeemed swap", "swapId", swapId, "err", err.Error())
+	}
+}
+
+// lockedInHTLC finds the HTLC paying us the ETH side of swap. It is only
+// returned once the peer has revoked every commitment that came before ours,
+// so neither side can drop the HTLC by broadcasting an old one.
+func (s *SwapHandler) lockedInHTLC(swap *pendingSwap) (*db.ETHHTLC, error) {
+	ethChan, err := s.db.Channels.FindById(swap.ETHChannelID)
+	if err != nil {
+		return nil, err
+	}
+	if ethChan == nil {
+		return nil, errors.New("swap channel not found")
+	}
+	if ethChan.CommitmentNumber == 0 {
+		return nil, errors.New("swap htlc is not committed yet")
+	}
+	if _, err := wallet.LookupRevocation(ethChan.TheirRevocationStore, ethChan.CommitmentNumber-1); err != nil {
+		return nil, errors.New("swap htlc is not locked in yet")
+	}
+
+	htlcs, err := s.db.Channels.FindHTLCs(swap.ETHChannelID)
+	if err != nil {
+		return nil, err
+	}
+	for _, htlc := range htlcs {
+		if htlc.Offered || htlc.Failed || htlc.PaymentHash != swap.PaymentHash {
+			continue
+		}
+		if htlc.Amount.Cmp(swap.ETHAmount) != 0 {
+			return nil, errors.New("swap htlc is for a different amount")
+		}
+
+		return htlc, nil
+	}
+
+	return nil, errors.New("swap htlc is not committed yet")
+}
+
+// awaitHTLC waits for lockedInHTLC to find swap's HTLC, which takes a round of
+// commitment updates after the peer offers it. It gives up when ctx is done.
+func (s *SwapHandler) awaitHTLC(ctx context.Context, swap *pendingSwap) (*db.ETHHTLC, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		htlc, err := s.lockedInHTLC(swap)
+		if err == nil {
+			return htlc, nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+// addSwap tracks swap until it completes or fails. Its context is cancelled
+// by removeSwap, stopping anything still waiting on it.
+func (s *SwapHandler) addSwap(swap *pendingSwap) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	swap.ctx, swap.cancel = context.WithCancel(context.Background())
+	s.pendingSwaps[swap.SwapID] = swap
+}
+
+func (s *SwapHandler) removeSwap(swap *pendingSwap) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.pendingSwaps, swap.SwapID)
+	swap.cancel()
+}
+
+func (s *SwapHandler) persist(swap *pendingSwap, state db.SwapState) error {
+	s.mtx.Lock()
+	swap.State = state
+	record := &db.Swap{
+		ID:             swap.SwapID,
+		PaymentHash:    swap.PaymentHash,
+		Preimage:       swap.Preimage,
+		ETHChannelID:   swap.ETHChannelID,
+		BTCChannelID:   swap.BTCChannelID,
+		ETHAmount:      swap.ETHAmount,
+		BTCAmount:      swap.BTCAmount,
+		PaymentRequest: swap.PaymentRequest,
+		Counterparty:   swap.Counterparty,
+		IsInitiator:    swap.IsInitiator,
+		Direction:      swap.Direction,
+		State:          state,
+	}
+	s.mtx.Unlock()
+
+	return s.db.Swaps.Save(record)
+}
+
+func swapFromRecord(record *db.Swap) *pendingSwap {
+	return &pendingSwap{
+		SwapID:         record.ID,
+		PaymentHash:    record.PaymentHash,
+		ETHChannelID:   record.ETHChannelID,
+		BTCChannelID:   record.BTCChannelID,
+		ETHAmount:      record.ETHAmount,
+		BTCAmount:      record.BTCAmount,
+		Preimage:       record.Preimage,
+		PaymentRequest: record.PaymentRequest,
+		Counterparty:   record.Counterparty,
+		IsInitiator:    record.IsInitiator,
+		Direction:      record.Direction,
+		State:          record.State,
+	}
+}
+
+// receivesETH reports whether we redeem the HTLC in swap, which is also the
+// side that pays the invoice.
+func receivesETH(swap *pendingSwap) bool {
+	return swap.IsInitiator == (swap.Direction == db.SwapBTCToETH)
+}
+
+func directionFeature(direction db.SwapDirection) lnwire.FeatureBit {
+	if direction == db.SwapBTCToETH {
+		return wire.BTCToETHSwapsOptional
+	}
+
+	return wire.ETHToBTCSwapsOptional
+}
+
+func toWireDirection(direction db.SwapDirection) (uint16, error) {
+	switch direction {
+	case db.SwapETHToBTC:
+		return wire.SwapDirectionETHToBTC, nil
+	case db.SwapBTCToETH:
+		return wire.SwapDirectionBTCToETH, nil
+	default:
+		return 0, errors.New("unknown swap direction")
+	}
+}
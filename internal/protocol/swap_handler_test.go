@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"testing"
+	"math/big"
+	"crypto/sha256"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/kyokan/drawbridge/internal/db"
+	"github.com/kyokan/drawbridge/internal/wallet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwapHandler_LockedInHTLC(t *testing.T) {
+	root, err := hexutil.Decode("0x9a3af8416df249e86d4e508718ab492a00471ca8fb23992a5dc56a158e885cc3")
+	assert.Nil(t, err)
+	secret, err := wallet.RevocationSecretAtIndex(root, 0)
+	assert.Nil(t, err)
+	store, err := wallet.AddRevocation(nil, secret)
+	assert.Nil(t, err)
+
+	chanId := common.HexToHash("0x01")
+	preimage := common.HexToHash("0x05")
+	paymentHash := sha256.Sum256(preimage[:])
+	channel := &db.ETHChannel{
+		ID:               chanId,
+		CommitmentNumber: 1,
+	}
+	htlc := &db.ETHHTLC{
+		ChannelID:   chanId,
+		ID:          0,
+		Amount:      big.NewInt(100),
+		PaymentHash: paymentHash,
+		Delay:       swapHTLCDelay,
+	}
+	channels := &memChannels{
+		channels: []*db.ETHChannel{channel},
+		htlcs: []*db.ETHHTLC{
+			{ChannelID: chanId, ID: 0, Offered: true, Amount: big.NewInt(100), PaymentHash: paymentHash},
+			htlc,
+		},
+	}
+	swaps := &SwapHandler{db: &db.DB{Channels: channels}}
+	swap := &pendingSwap{
+		PaymentHash:  paymentHash,
+		ETHChannelID: chanId,
+		ETHAmount:    big.NewInt(100),
+	}
+
+	// the peer can still broadcast commitment 0, which has no htlc
+	_, err = swaps.lockedInHTLC(swap)
+	assert.NotNil(t, err)
+
+	channel.TheirRevocationStore = store
+	found, err := swaps.lockedInHTLC(swap)
+	assert.Nil(t, err)
+	assert.Equal(t, htlc, found)
+
+	htlc.Failed = true
+	_, err = swaps.lockedInHTLC(swap)
+	assert.NotNil(t, err)
+	htlc.Failed = false
+
+	swap.ETHAmount = big.NewInt(200)
+	_, err = swaps.lockedInHTLC(swap)
+	assert.NotNil(t, err)
+
+	swap.PaymentHash = common.HexToHash("0x06")
+	_, err = swaps.lockedInHTLC(swap)
+	assert.NotNil(t, err)
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/kyokan/drawbridge/internal/protocol"
 	"github.com/kyokan/drawbridge/internal/lndclient"
 	"golang.org/x/net/context"
+	"errors"
 )
 
 var log *zap.SugaredLogger
@@ -47,7 +48,17 @@ func Start() {
 		log.Panicw("failed to instantiate key manager", "err", err.Error())
 	}
 
-	ethClient, err := ethclient.NewClient(km, stringFlag("eth-rpc-url"), stringFlag("contract-address"))
+	database, err := db.NewDB(databaseUrl)
+	if err != nil {
+		log.Panicw("failed to open database connection", "err", err.Error())
+	}
+
+	err = database.Connect()
+	if err != nil {
+		log.Panicw("failed to connect to the database", "err", err.Error())
+	}
+
+	ethClient, err := ethclient.NewClient(km, database.Transactions, stringFlag("eth-rpc-url"), stringFlag("contract-address"))
 	if err != nil {
 		log.Panicw("failed to instantiate ETH client", "err", err.Error())
 	}
@@ -64,16 +75,6 @@ func Start() {
 		log.Panicw("failed to connect to lnd", "err", err.Error())
 	}
 
-	database, err := db.NewDB(databaseUrl)
-	if err != nil {
-		log.Panicw("failed to open database connection", "err", err.Error())
-	}
-
-	err = database.Connect()
-	if err != nil {
-		log.Panicw("failed to connect to the database", "err", err.Error())
-	}
-
 	info, err := lndClient.GetInfo()
 	if err != nil {
 		log.Panicw("failed to connect to lnd", "err", err.Error())
@@ -88,53 +89,95 @@ func Start() {
 		database,
 	)
 
+	rates, err := rateProvider()
+	if err != nil {
+		log.Panicw("failed to configure swap rates", "err", err.Error())
+	}
+
+	commitmentHandler := protocol.NewCommitmentHandler(
+		peerBook,
+		km,
+		database,
+		chanHandler,
+	)
+
 	swapHandler := protocol.NewSwapHandler(
 		peerBook,
 		lndClient,
-		ethClient,
+		commitmentHandler,
 		database,
 		km,
+		rates,
 	)
 
+	if err := chanHandler.Resume(); err != nil {
+		log.Panicw("failed to resume pending channels", "err", err.Error())
+	}
+
+	if err := swapHandler.Resume(); err != nil {
+		log.Panicw("failed to resume pending swaps", "err", err.Error())
+	}
+
 	reactor := p2p.NewReactor([]p2p.MsgHandler{
 		&protocol.PingPongHandler{},
-		protocol.NewHandshakeHandler(lndClient),
+		protocol.NewErrorHandler(chanHandler, swapHandler),
+		protocol.NewHandshakeHandler(lndClient, database.Peers),
 		chanHandler,
+		commitmentHandler,
 		swapHandler,
 	})
+	bans := p2p.NewBanManager(database.Bans)
+	reactor.SetBanManager(bans)
 
 	lndIdentity, err := dwcrypto.PublicFromCompressedHex("0x" + info.IdentityPubkey)
 	if err != nil {
 		log.Panicw("failed to parse identity key from lnd", "err", err.Error())
 	}
 
+	peerConfig := p2p.DefaultPeerConfig()
+	peerConfig.IncomingQueueSize = viper.GetInt("peer-queue-size")
+	peerConfig.OutgoingQueueSize = viper.GetInt("peer-queue-size")
+	peerConfig.DefaultRateLimit = p2p.RateLimit{
+		PerSecond: viper.GetFloat64("peer-msg-rate"),
+		Burst:     viper.GetInt("peer-msg-burst"),
+	}
+
 	node, err := p2p.NewNode(&p2p.NodeConfig{
 		Reactor:        reactor,
 		PeerBook:       peerBook,
+		Peers:          database.Peers,
+		Bans:           bans,
+		PeerConfig:     peerConfig,
 		P2PAddr:        stringFlag("p2p-ip"),
 		P2PPort:        stringFlag("p2p-port"),
 		BootstrapPeers: viper.GetStringSlice("bootstrap-peers"),
 		LNDIdentity:    lndIdentity,
 		LNDHost:        lndClientConfig.Host,
 	})
-
-	container := &api.ServiceContainer{
-		FundingService: api.NewFundingService(ethClient, chanHandler),
-		SwapService:    api.NewSwapService(swapHandler),
-	}
-
 	if err != nil {
 		log.Panicw("failed to create node", "err", err.Error())
 	}
 
-	go reactor.Run()
+	chainsaw := ethclient.NewChainsaw(ethClient, database, uint64(viper.GetInt64("eth-confirmations")))
+	chainsaw.AddObserver(chanHandler)
+	chainsaw.AddObserver(protocol.NewBreachArbiter(km, ethClient, database))
+	chainsaw.AddObserver(protocol.NewHTLCSweeper(km, ethClient, database))
 
-	chainsaw := ethclient.NewChainsaw(ethClient, database)
+	container := &api.ServiceContainer{
+		FundingService: api.NewFundingService(ethClient, chanHandler),
+		SwapService:    api.NewSwapService(swapHandler),
+		PeerService:    api.NewPeerService(node),
+		StatusService:  api.NewStatusService(chainsaw),
+	}
 
 	go (func() {
 		chainsaw.Start()
 	})()
 
+	go (func() {
+		ethClient.TxManager().Start()
+	})()
+
 	go (func() {
 		api.Start(container, stringFlag("rpc-ip"), stringFlag("rpc-port"))
 	})()
@@ -150,6 +193,23 @@ func Start() {
 	select {}
 }
 
+func rateProvider() (protocol.RateProvider, error) {
+	if file := stringFlag("swap-rate-file"); file != "" {
+		return protocol.NewFileRateProvider(file), nil
+	}
+
+	btcPerETH, ok := new(big.Int).SetString(stringFlag("swap-rate"), 10)
+	if !ok {
+		return nil, errors.New("mal-formed swap rate")
+	}
+
+	return protocol.NewStaticRateProvider(&protocol.Rate{
+		BTCPerETH:            btcPerETH,
+		FeeBasisPoints:       uint64(viper.GetInt64("swap-fee-bps")),
+		ToleranceBasisPoints: uint64(viper.GetInt64("swap-tolerance-bps")),
+	}), nil
+}
+
 func stringFlag(name string) (string) {
 	return viper.GetString(name)
 }
@@ -5,6 +5,10 @@ import (
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/lightningnetwork/lnd/lnwallet"
 	"crypto/rand"
+	"bytes"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/common"
 )
 
 func Rand32() ([]byte, error) {
@@ -30,7 +34,7 @@ func Rand32Array() ([32]byte, error) {
 	return out, nil
 }
 
-func CommitmentAtIndex(root []byte, index uint64) (*btcec.PublicKey, error) {
+func RevocationSecretAtIndex(root []byte, index uint64) ([]byte, error) {
 	producer, err := shachain.NewRevocationProducerFromBytes(root)
 
 	if err != nil {
@@ -43,7 +47,86 @@ func CommitmentAtIndex(root []byte, index uint64) (*btcec.PublicKey, error) {
 		return nil, err
 	}
 
-	return lnwallet.ComputeCommitmentPoint(preimage[:]), nil
+	return preimage[:], nil
+}
+
+func CommitmentAtIndex(root []byte, index uint64) (*btcec.PublicKey, error) {
+	preimage, err := RevocationSecretAtIndex(root, index)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return CommitmentPoint(preimage), nil
+}
+
+// CommitmentPoint returns the per-commitment point of a commitment secret.
+func CommitmentPoint(secret []byte) *btcec.PublicKey {
+	return lnwallet.ComputeCommitmentPoint(secret)
+}
+
+// ValidateRevocation checks that a revealed revocation secret is the preimage
+// of a commitment point the counterparty previously handed us.
+func ValidateRevocation(secret []byte, point *btcec.PublicKey) bool {
+	return CommitmentPoint(secret).IsEqual(point)
+}
+
+// AddRevocation appends the next revealed secret to an encoded shachain
+// revocation store, returning the re-encoded store. An empty store is created
+// when encoded is empty.
+func AddRevocation(encoded []byte, secret []byte) ([]byte, error) {
+	var store *shachain.RevocationStore
+	var err error
+
+	if len(encoded) == 0 {
+		store = shachain.NewRevocationStore()
+	} else {
+		store, err = shachain.NewRevocationStoreFromBytes(bytes.NewReader(encoded))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hash, err := chainhash.NewHash(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.AddNextEntry(hash); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err := store.Encode(&b); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// LookupRevocation returns the secret revealed for the commitment at index from
+// an encoded shachain revocation store.
+func LookupRevocation(encoded []byte, index uint64) ([]byte, error) {
+	store, err := shachain.NewRevocationStoreFromBytes(bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := store.LookUp(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return hash[:], nil
+}
+
+// RevocationAddress returns the address of a commitment's revocation key,
+// derived BOLT-3 style from the other side's revocation basepoint and the
+// commitment owner's per-commitment point. Only the holder of the basepoint can
+// sign for it, and only once the owner has revealed the commitment's secret.
+func RevocationAddress(basepoint *btcec.PublicKey, point *btcec.PublicKey) common.Address {
+	key := lnwallet.DeriveRevocationPubkey(basepoint, point)
+	return ethcrypto.PubkeyToAddress(*key.ToECDSA())
 }
 
 func FirstCommitmentPoint() ([]byte,  *btcec.PublicKey, error) {
@@ -0,0 +1,76 @@
+package wallet
+
+import (
+	"testing"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"math/big"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestValidateRevocation(t *testing.T) {
+	root, err := hexutil.Decode("0x9a3af8416df249e86d4e508718ab492a00471ca8fb23992a5dc56a158e885cc3")
+	assert.Nil(t, err)
+
+	point, err := CommitmentAtIndex(root, 1)
+	assert.Nil(t, err)
+	secret, err := RevocationSecretAtIndex(root, 1)
+	assert.Nil(t, err)
+	wrongSecret, err := RevocationSecretAtIndex(root, 2)
+	assert.Nil(t, err)
+
+	assert.True(t, ValidateRevocation(secret, point))
+	assert.False(t, ValidateRevocation(wrongSecret, point))
+}
+
+func TestAddLookupRevocation(t *testing.T) {
+	root, err := hexutil.Decode("0x9a3af8416df249e86d4e508718ab492a00471ca8fb23992a5dc56a158e885cc3")
+	assert.Nil(t, err)
+
+	var store []byte
+	for i := uint64(0); i < 3; i++ {
+		secret, err := RevocationSecretAtIndex(root, i)
+		assert.Nil(t, err)
+		store, err = AddRevocation(store, secret)
+		assert.Nil(t, err)
+	}
+
+	for i := uint64(0); i < 3; i++ {
+		expected, err := RevocationSecretAtIndex(root, i)
+		assert.Nil(t, err)
+		actual, err := LookupRevocation(store, i)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	}
+}
+
+func TestSignWithRevocation(t *testing.T) {
+	km, err := NewKeyManager("c87509a1c067bbde78beb793e6fa76530b6382a4c0241e5e4a9ec0a0f44dc0d3", big.NewInt(1))
+	assert.Nil(t, err)
+	root, err := hexutil.Decode("0x9a3af8416df249e86d4e508718ab492a00471ca8fb23992a5dc56a158e885cc3")
+	assert.Nil(t, err)
+
+	point, err := CommitmentAtIndex(root, 1)
+	assert.Nil(t, err)
+	secret, err := RevocationSecretAtIndex(root, 1)
+	assert.Nil(t, err)
+	pub, err := crypto.PublicFromBTCEC(point)
+	assert.Nil(t, err)
+
+	addr := RevocationAddress(km.PublicKey().BTCEC(), point)
+	assert.NotEqual(t, pub.ETHAddress(), addr)
+	assert.NotEqual(t, km.PublicKey().ETHAddress(), addr)
+
+	data := []byte("revoked commitment")
+	sig, err := km.SignWithRevocation(secret, data)
+	assert.Nil(t, err)
+	assert.True(t, sig.VerifyAddress(data, addr))
+
+	// the commitment secret alone is not enough
+	key, err := ethcrypto.ToECDSA(secret)
+	assert.Nil(t, err)
+	secretSig, err := ethcrypto.Sign(crypto.GethHash(data), key)
+	assert.Nil(t, err)
+	assert.False(t, crypto.Signature(secretSig).VerifyAddress(data, addr))
+}
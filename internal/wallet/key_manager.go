@@ -7,6 +7,8 @@ import (
 	"math/big"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/kyokan/drawbridge/pkg/crypto"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/lightningnetwork/lnd/lnwallet"
 )
 
 type KeyManager struct {
@@ -48,6 +50,22 @@ func (c *KeyManager) SignData(data []byte) (crypto.Signature, error) {
 	return res, nil
 }
 
+// SignWithRevocation signs data with the revocation key of a counterparty
+// commitment whose secret they have revealed. Our key is the revocation
+// basepoint we hand out, so the revocation key is only ours once we hold both.
+func (c *KeyManager) SignWithRevocation(secret []byte, data []byte) (crypto.Signature, error) {
+	base, _ := btcec.PrivKeyFromBytes(btcec.S256(), ethcrypto.FromECDSA(c.key))
+	commitSecret, _ := btcec.PrivKeyFromBytes(btcec.S256(), secret)
+	revocation := lnwallet.DeriveRevocationPrivKey(base, commitSecret)
+
+	key, err := ethcrypto.ToECDSA(revocation.Serialize())
+	if err != nil {
+		return nil, err
+	}
+
+	return ethcrypto.Sign(crypto.GethHash(data), key)
+}
+
 func (c *KeyManager) SignTx(tx *types.Transaction) (*types.Transaction, error) {
 	tx, err := types.SignTx(tx, types.NewEIP155Signer(c.chainId), c.key)
 
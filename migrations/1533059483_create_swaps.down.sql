@@ -0,0 +1 @@
+DROP TABLE swaps;
\ No newline at end of file
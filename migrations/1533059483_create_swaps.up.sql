@@ -0,0 +1,15 @@
+CREATE TABLE swaps (
+  id VARCHAR NOT NULL PRIMARY KEY,
+  payment_hash VARCHAR NOT NULL,
+  preimage VARCHAR NOT NULL,
+  eth_channel_id VARCHAR NOT NULL,
+  btc_channel_id DECIMAL(20, 0) NOT NULL,
+  eth_amount DECIMAL(72, 0) NOT NULL,
+  btc_amount DECIMAL(72, 0) NOT NULL,
+  eth_commit_sig VARCHAR NOT NULL,
+  payment_request VARCHAR NOT NULL DEFAULT '',
+  counterparty VARCHAR NOT NULL,
+  is_initiator BOOLEAN NOT NULL,
+  state VARCHAR NOT NULL,
+  updated_at BIGINT NOT NULL
+);
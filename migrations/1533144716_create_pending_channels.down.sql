@@ -0,0 +1 @@
+DROP TABLE pending_channels;
\ No newline at end of file
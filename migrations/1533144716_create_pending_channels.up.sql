@@ -0,0 +1,14 @@
+CREATE TABLE pending_channels (
+  pending_channel_id VARCHAR NOT NULL PRIMARY KEY,
+  channel_id VARCHAR NOT NULL,
+  input_id VARCHAR NOT NULL,
+  funding_amount DECIMAL(72, 0) NOT NULL,
+  our_funding_key VARCHAR NOT NULL,
+  their_funding_key VARCHAR NOT NULL DEFAULT '',
+  our_signature VARCHAR NOT NULL DEFAULT '0x',
+  their_signature VARCHAR NOT NULL DEFAULT '0x',
+  funding_output VARCHAR NOT NULL,
+  sent_locked BOOLEAN NOT NULL DEFAULT FALSE,
+  received_locked BOOLEAN NOT NULL DEFAULT FALSE,
+  updated_at BIGINT NOT NULL
+);
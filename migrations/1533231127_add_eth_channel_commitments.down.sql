@@ -0,0 +1,10 @@
+DROP TABLE eth_htlcs;
+
+ALTER TABLE eth_channels
+  DROP COLUMN commitment_number,
+  DROP COLUMN local_balance,
+  DROP COLUMN remote_balance,
+  DROP COLUMN revocation_root,
+  DROP COLUMN their_commit_sig,
+  DROP COLUMN their_revocation_point,
+  DROP COLUMN their_revocation_store;
@@ -0,0 +1,20 @@
+ALTER TABLE eth_channels
+  ADD COLUMN commitment_number BIGINT NOT NULL DEFAULT 0,
+  ADD COLUMN local_balance DECIMAL(72, 0) NOT NULL DEFAULT 0,
+  ADD COLUMN remote_balance DECIMAL(72, 0) NOT NULL DEFAULT 0,
+  ADD COLUMN revocation_root VARCHAR NOT NULL DEFAULT '',
+  ADD COLUMN their_commit_sig VARCHAR NOT NULL DEFAULT '0x',
+  ADD COLUMN their_revocation_point VARCHAR NOT NULL DEFAULT '',
+  ADD COLUMN their_revocation_store VARCHAR NOT NULL DEFAULT '0x';
+
+CREATE TABLE eth_htlcs (
+  channel_id VARCHAR NOT NULL REFERENCES eth_channels(id),
+  id BIGINT NOT NULL,
+  offered BOOLEAN NOT NULL,
+  amount DECIMAL(72, 0) NOT NULL,
+  payment_hash VARCHAR NOT NULL,
+  delay BIGINT NOT NULL,
+  preimage VARCHAR NOT NULL DEFAULT '',
+  fulfilled BOOLEAN NOT NULL DEFAULT FALSE,
+  PRIMARY KEY (channel_id, offered, id)
+);
@@ -0,0 +1 @@
+ALTER TABLE eth_channels DROP COLUMN closed;
@@ -0,0 +1 @@
+ALTER TABLE eth_channels ADD COLUMN closed BOOLEAN NOT NULL DEFAULT FALSE;
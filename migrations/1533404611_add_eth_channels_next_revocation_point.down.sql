@@ -0,0 +1,2 @@
+ALTER TABLE eth_channels
+  DROP COLUMN their_next_revocation_point;
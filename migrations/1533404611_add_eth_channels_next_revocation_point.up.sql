@@ -0,0 +1,2 @@
+ALTER TABLE eth_channels
+  ADD COLUMN their_next_revocation_point VARCHAR NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+DROP TABLE htlc_refunds;
@@ -0,0 +1,6 @@
+CREATE TABLE htlc_refunds (
+  output_id VARCHAR NOT NULL PRIMARY KEY REFERENCES eth_outputs(id),
+  tx_hash VARCHAR NOT NULL,
+  amount DECIMAL(72, 0) NOT NULL,
+  created_at BIGINT NOT NULL
+);
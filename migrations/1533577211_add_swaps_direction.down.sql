@@ -0,0 +1,2 @@
+ALTER TABLE swaps
+  DROP COLUMN direction;
@@ -0,0 +1,2 @@
+ALTER TABLE swaps
+  ADD COLUMN direction VARCHAR NOT NULL DEFAULT 'eth_to_btc';
@@ -0,0 +1,7 @@
+CREATE TABLE peers (
+  identity_key VARCHAR NOT NULL PRIMARY KEY,
+  addresses VARCHAR NOT NULL,
+  lnd_identity VARCHAR NOT NULL DEFAULT '',
+  last_seen BIGINT NOT NULL DEFAULT 0,
+  created_at BIGINT NOT NULL
+);
@@ -0,0 +1,6 @@
+CREATE TABLE bans (
+  identity_key VARCHAR NOT NULL PRIMARY KEY,
+  reason VARCHAR NOT NULL,
+  expires_at BIGINT NOT NULL,
+  created_at BIGINT NOT NULL
+);
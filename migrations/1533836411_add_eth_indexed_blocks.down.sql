@@ -0,0 +1,4 @@
+ALTER TABLE eth_outputs DROP COLUMN orphaned;
+ALTER TABLE eth_outputs DROP COLUMN spent_block;
+
+DROP TABLE eth_indexed_blocks;
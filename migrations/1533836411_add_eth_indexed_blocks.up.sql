@@ -0,0 +1,8 @@
+CREATE TABLE eth_indexed_blocks (
+  block_number BIGINT NOT NULL PRIMARY KEY,
+  block_hash VARCHAR NOT NULL,
+  parent_hash VARCHAR NOT NULL
+);
+
+ALTER TABLE eth_outputs ADD COLUMN spent_block BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE eth_outputs ADD COLUMN orphaned BOOLEAN NOT NULL DEFAULT FALSE;
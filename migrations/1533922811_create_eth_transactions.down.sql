@@ -0,0 +1 @@
+DROP TABLE eth_transactions;
@@ -0,0 +1,15 @@
+CREATE TABLE eth_transactions (
+  from_address VARCHAR NOT NULL,
+  nonce BIGINT NOT NULL,
+  to_address VARCHAR NOT NULL,
+  value DECIMAL(72, 0) NOT NULL,
+  data VARCHAR NOT NULL,
+  gas_limit BIGINT NOT NULL,
+  gas_price DECIMAL(72, 0) NOT NULL,
+  tx_hash VARCHAR NOT NULL,
+  tx_hashes VARCHAR NOT NULL,
+  status VARCHAR NOT NULL,
+  broadcast_at BIGINT NOT NULL,
+  created_at BIGINT NOT NULL,
+  PRIMARY KEY (from_address, nonce)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE eth_channels
+  DROP COLUMN their_revocation_basepoint;
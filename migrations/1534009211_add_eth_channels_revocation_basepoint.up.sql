@@ -0,0 +1,2 @@
+ALTER TABLE eth_channels
+  ADD COLUMN their_revocation_basepoint VARCHAR NOT NULL DEFAULT '';
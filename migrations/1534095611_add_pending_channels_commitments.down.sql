@@ -0,0 +1,4 @@
+ALTER TABLE pending_channels
+  DROP COLUMN revocation_root,
+  DROP COLUMN their_first_point,
+  DROP COLUMN their_commit_sig;
@@ -0,0 +1,4 @@
+ALTER TABLE pending_channels
+  ADD COLUMN revocation_root VARCHAR NOT NULL DEFAULT '',
+  ADD COLUMN their_first_point VARCHAR NOT NULL DEFAULT '',
+  ADD COLUMN their_commit_sig VARCHAR NOT NULL DEFAULT '0x';
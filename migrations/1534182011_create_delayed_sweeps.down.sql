@@ -0,0 +1 @@
+DROP TABLE delayed_sweeps;
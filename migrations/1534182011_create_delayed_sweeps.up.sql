@@ -0,0 +1,7 @@
+CREATE TABLE delayed_sweeps (
+  output_id VARCHAR NOT NULL PRIMARY KEY,
+  channel_id VARCHAR NOT NULL REFERENCES eth_channels(id),
+  tx_hash VARCHAR NOT NULL,
+  swept BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at BIGINT NOT NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE swaps
+  ADD COLUMN eth_commit_sig VARCHAR NOT NULL DEFAULT '';
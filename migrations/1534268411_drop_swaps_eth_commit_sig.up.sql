@@ -0,0 +1,2 @@
+ALTER TABLE swaps
+  DROP COLUMN eth_commit_sig;
@@ -0,0 +1,2 @@
+DROP TABLE eth_htlc_updates;
+DROP TABLE eth_channel_updates;
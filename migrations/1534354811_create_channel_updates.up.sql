@@ -0,0 +1,19 @@
+CREATE TABLE eth_channel_updates (
+  channel_id VARCHAR NOT NULL PRIMARY KEY REFERENCES eth_channels(id),
+  commitment_number BIGINT NOT NULL,
+  signed_next BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE eth_htlc_updates (
+  channel_id VARCHAR NOT NULL REFERENCES eth_channel_updates(channel_id),
+  position BIGINT NOT NULL,
+  htlc_id BIGINT NOT NULL,
+  offered BOOLEAN NOT NULL,
+  amount DECIMAL(72, 0) NOT NULL,
+  payment_hash VARCHAR NOT NULL,
+  delay BIGINT NOT NULL,
+  preimage VARCHAR NOT NULL DEFAULT '',
+  fulfilled BOOLEAN NOT NULL DEFAULT FALSE,
+  deferred BOOLEAN NOT NULL DEFAULT FALSE,
+  PRIMARY KEY (channel_id, position)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE eth_htlc_updates DROP COLUMN failed;
+ALTER TABLE eth_htlcs DROP COLUMN failed;
@@ -0,0 +1,2 @@
+ALTER TABLE eth_htlcs ADD COLUMN failed BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE eth_htlc_updates ADD COLUMN failed BOOLEAN NOT NULL DEFAULT FALSE;
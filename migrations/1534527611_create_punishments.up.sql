@@ -0,0 +1,7 @@
+CREATE TABLE punishments (
+  output_id VARCHAR NOT NULL PRIMARY KEY,
+  channel_id VARCHAR NOT NULL REFERENCES eth_channels(id),
+  tx_hash VARCHAR NOT NULL,
+  punished BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at BIGINT NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE pending_channels DROP COLUMN funding_tx;
@@ -0,0 +1 @@
+ALTER TABLE pending_channels ADD COLUMN funding_tx VARCHAR NOT NULL DEFAULT '';
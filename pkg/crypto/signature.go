@@ -3,6 +3,7 @@ package crypto
 import (
 	"github.com/ethereum/go-ethereum/crypto"
 	"fmt"
+	"github.com/ethereum/go-ethereum/common"
 )
 
 const SignaturePreamble = "\x19Ethereum Signed Message:\n"
@@ -35,6 +36,16 @@ func (s Signature) Verify(data []byte, expectedPub *PublicKey) bool {
 	return pub.Equal(expectedPub)
 }
 
+func (s Signature) VerifyAddress(data []byte, expected common.Address) bool {
+	hash := GethHash(data)
+	actualPub, err := crypto.SigToPub(hash, s)
+	if err != nil {
+		return false
+	}
+
+	return crypto.PubkeyToAddress(*actualPub) == expected
+}
+
 func VerifySignature(data [32]byte, expectedPub *PublicKey, sig Signature) bool {
 	actualPub, err := crypto.SigToPub(data[:], sig.Bytes())
 	if err != nil {
@@ -0,0 +1,95 @@
+package txout
+
+import (
+	"math/big"
+	"github.com/ethereum/go-ethereum/common"
+	"io"
+	"github.com/kyokan/drawbridge/internal/conv"
+)
+
+type CommitmentLocalSpendType uint8
+
+const (
+	CommitmentLocalDelayed    CommitmentLocalSpendType = 0x00
+	CommitmentLocalRevocation                          = 0x01
+)
+
+// CommitmentLocal pays the owner of a commitment their balance once Delay
+// blocks have passed. Until then the holder of the revocation key can claim it.
+type CommitmentLocal struct {
+	Delay             *big.Int
+	DelayedAddress    common.Address
+	RevocationAddress common.Address
+}
+
+func NewCommitmentLocal(delay *big.Int, delayed common.Address, revocation common.Address) *CommitmentLocal {
+	return &CommitmentLocal{
+		Delay:             delay,
+		DelayedAddress:    delayed,
+		RevocationAddress: revocation,
+	}
+}
+
+func (c *CommitmentLocal) OutputType() OutputType {
+	return OutputCommitmentLocal
+}
+
+func (c *CommitmentLocal) Decode(r io.Reader, pver uint32) error {
+	var delayBuf [32]byte
+	if _, err := io.ReadFull(r, delayBuf[:]); err != nil {
+		return err
+	}
+	delay, err := conv.BytesToBig(delayBuf[:])
+	if err != nil {
+		return err
+	}
+
+	var delayed common.Address
+	var revocation common.Address
+	if _, err := io.ReadFull(r, delayed[:]); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r, revocation[:]); err != nil {
+		return err
+	}
+
+	c.Delay = delay
+	c.DelayedAddress = delayed
+	c.RevocationAddress = revocation
+	return nil
+}
+
+func (c *CommitmentLocal) Encode(w io.Writer, pver uint32) error {
+	var b [1]byte
+	b[0] = byte(OutputCommitmentLocal)
+	if _, err := w.Write(b[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(conv.BigToBytes(c.Delay)); err != nil {
+		return err
+	}
+	if _, err := w.Write(c.DelayedAddress.Bytes()); err != nil {
+		return err
+	}
+	if _, err := w.Write(c.RevocationAddress.Bytes()); err != nil {
+		return err
+	}
+	return nil
+}
+
+type CommitmentLocalWitness struct {
+	SpendType CommitmentLocalSpendType
+}
+
+func NewCommitmentLocalWitness(spendType CommitmentLocalSpendType) *CommitmentLocalWitness {
+	return &CommitmentLocalWitness{
+		SpendType: spendType,
+	}
+}
+
+func (c *CommitmentLocalWitness) Encode(w io.Writer) error {
+	var b [1]byte
+	b[0] = byte(c.SpendType)
+	_, err := w.Write(b[:])
+	return err
+}
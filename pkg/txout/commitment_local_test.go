@@ -0,0 +1,36 @@
+package txout
+
+import (
+	"testing"
+	"github.com/ethereum/go-ethereum/common"
+	"bytes"
+	"math/big"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitmentLocal_EncodeDecode(t *testing.T) {
+	delayed := common.HexToAddress("0x08e4f70109ccc5135f50cc359d24cb7686247df4")
+	revocation := common.HexToAddress("0x627306090abab3a6e1400e9345bc60c78a8bef57")
+	out := NewCommitmentLocal(big.NewInt(7), delayed, revocation)
+
+	var buf bytes.Buffer
+	err := out.Encode(&buf, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 73, buf.Len())
+	assert.Equal(t, byte(OutputCommitmentLocal), buf.Bytes()[0])
+
+	decoded := &CommitmentLocal{}
+	err = decoded.Decode(bytes.NewReader(buf.Bytes()[1:]), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), decoded.Delay.Int64())
+	assert.Equal(t, delayed, decoded.DelayedAddress)
+	assert.Equal(t, revocation, decoded.RevocationAddress)
+}
+
+func TestCommitmentLocalWitness_Encode(t *testing.T) {
+	var b bytes.Buffer
+	err := NewCommitmentLocalWitness(CommitmentLocalRevocation).Encode(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "0x01", hexutil.Encode(b.Bytes()))
+}
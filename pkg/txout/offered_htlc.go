@@ -5,6 +5,14 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"io"
 	"github.com/kyokan/drawbridge/internal/conv"
+	"github.com/ethereum/go-ethereum/crypto/sha3"
+)
+
+type OfferedHTLCSpendType uint8
+
+const (
+	OfferedHTLCRedemption OfferedHTLCSpendType = 0x00
+	OfferedHTLCTimeout                         = 0x01
 )
 
 type OfferedHTLC struct {
@@ -67,4 +75,51 @@ func (o *OfferedHTLC) Encode(w io.Writer, pver uint32) error {
 	    return err
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+type OfferedHTLCTimeoutWitness struct {
+}
+
+func NewOfferedHTLCTimeoutWitness() *OfferedHTLCTimeoutWitness {
+	return &OfferedHTLCTimeoutWitness{}
+}
+
+func (o *OfferedHTLCTimeoutWitness) Encode(w io.Writer) error {
+	var b [1]byte
+	b[0] = byte(OfferedHTLCTimeout)
+	_, err := w.Write(b[:])
+	return err
+}
+
+// OfferedHTLCTimeoutSigData returns the data to sign when timing out an offered
+// HTLC. Unlike other spends, the contract only commits the signature to the
+// output being spent and the witness type, not to the new outputs.
+func OfferedHTLCTimeoutSigData(inputId common.Hash) []byte {
+	hash := sha3.NewKeccak256()
+	hash.Write(inputId[:])
+	hash.Write([]byte{byte(OfferedHTLCTimeout)})
+	return hash.Sum(nil)
+}
+
+// OfferedHTLCWitness redeems an offered HTLC with the payment preimage. The
+// contract checks the sender against the redemption address instead of
+// requiring a signature.
+type OfferedHTLCWitness struct {
+	Preimage [32]byte
+}
+
+func NewOfferedHTLCWitness(preimage [32]byte) *OfferedHTLCWitness {
+	return &OfferedHTLCWitness{
+		Preimage: preimage,
+	}
+}
+
+func (o *OfferedHTLCWitness) Encode(w io.Writer) error {
+	var b [1]byte
+	b[0] = byte(OfferedHTLCRedemption)
+	if _, err := w.Write(b[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(o.Preimage[:])
+	return err
+}
@@ -0,0 +1,31 @@
+package txout
+
+import (
+	"testing"
+	"bytes"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOfferedHTLCTimeoutWitness_Encode(t *testing.T) {
+	var b bytes.Buffer
+	err := NewOfferedHTLCTimeoutWitness().Encode(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "0x01", hexutil.Encode(b.Bytes()))
+}
+
+func TestOfferedHTLCTimeoutSigData(t *testing.T) {
+	inputId := common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e")
+	expected := crypto.Keccak256(inputId[:], []byte{0x01})
+	assert.Equal(t, expected, OfferedHTLCTimeoutSigData(inputId))
+}
+
+func TestOfferedHTLCWitness_Encode(t *testing.T) {
+	preimage := common.HexToHash("0xf2f452833095a6d4a81f0845f5712a67a9bcbec74cad1c1c5c151f2fa62a59c3")
+	var b bytes.Buffer
+	err := NewOfferedHTLCWitness(preimage).Encode(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "0x00f2f452833095a6d4a81f0845f5712a67a9bcbec74cad1c1c5c151f2fa62a59c3", hexutil.Encode(b.Bytes()))
+}
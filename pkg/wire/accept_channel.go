@@ -7,10 +7,12 @@ import (
 )
 
 type AcceptChannel struct {
-	PendingChannelID [32]byte
-	CsvDelay         uint16
-	MaxAcceptedHTLCs uint16
-	FundingKey       *crypto.PublicKey
+	PendingChannelID     [32]byte
+	CsvDelay             uint16
+	MaxAcceptedHTLCs     uint16
+	FundingKey           *crypto.PublicKey
+	FirstCommitmentPoint *crypto.PublicKey
+	Extensions
 }
 
 func (msg *AcceptChannel) MsgType() lnwire.MessageType {
@@ -18,7 +20,7 @@ func (msg *AcceptChannel) MsgType() lnwire.MessageType {
 }
 
 func (msg *AcceptChannel) MaxPayloadLength(uint32) uint32 {
-	return 129
+	return 65535
 }
 
 func (msg *AcceptChannel) Decode(r io.Reader, pver uint32) error {
@@ -28,6 +30,8 @@ func (msg *AcceptChannel) Decode(r io.Reader, pver uint32) error {
 		&msg.CsvDelay,
 		&msg.MaxAcceptedHTLCs,
 		&msg.FundingKey,
+		&msg.FirstCommitmentPoint,
+		&msg.Extensions,
 	)
 }
 
@@ -38,5 +42,7 @@ func (msg *AcceptChannel) Encode(w io.Writer, pver uint32) error {
 		msg.CsvDelay,
 		msg.MaxAcceptedHTLCs,
 		msg.FundingKey,
+		msg.FirstCommitmentPoint,
+		&msg.Extensions,
 	)
 }
\ No newline at end of file
@@ -0,0 +1,40 @@
+package wire
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"io"
+)
+
+type ClosingSigned struct {
+	ChannelID common.Hash
+	Sig       crypto.Signature
+	Extensions
+}
+
+func (msg *ClosingSigned) MsgType() lnwire.MessageType {
+	return MsgClosingSigned
+}
+
+func (msg *ClosingSigned) MaxPayloadLength(uint32) uint32 {
+	return 65535
+}
+
+func (msg *ClosingSigned) Decode(r io.Reader, pver uint32) error {
+	return readElements(
+		r,
+		&msg.ChannelID,
+		&msg.Sig,
+		&msg.Extensions,
+	)
+}
+
+func (msg *ClosingSigned) Encode(w io.Writer, pver uint32) error {
+	return writeElements(
+		w,
+		msg.ChannelID,
+		msg.Sig,
+		&msg.Extensions,
+	)
+}
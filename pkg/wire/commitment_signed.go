@@ -0,0 +1,43 @@
+package wire
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"io"
+)
+
+type CommitmentSigned struct {
+	ChannelID        common.Hash
+	CommitmentNumber uint64
+	Sig              crypto.Signature
+	Extensions
+}
+
+func (msg *CommitmentSigned) MsgType() lnwire.MessageType {
+	return MsgCommitmentSigned
+}
+
+func (msg *CommitmentSigned) MaxPayloadLength(uint32) uint32 {
+	return 65535
+}
+
+func (msg *CommitmentSigned) Decode(r io.Reader, pver uint32) error {
+	return readElements(
+		r,
+		&msg.ChannelID,
+		&msg.CommitmentNumber,
+		&msg.Sig,
+		&msg.Extensions,
+	)
+}
+
+func (msg *CommitmentSigned) Encode(w io.Writer, pver uint32) error {
+	return writeElements(
+		w,
+		msg.ChannelID,
+		msg.CommitmentNumber,
+		msg.Sig,
+		&msg.Extensions,
+	)
+}
@@ -0,0 +1,134 @@
+package wire
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+	"io"
+)
+
+// Error tells the peer that the channel or swap identified by ID has failed.
+// A zero ID refers to the connection as a whole.
+type Error struct {
+	ID   [32]byte
+	Data string
+	Extensions
+}
+
+func NewError(id [32]byte, err error) *Error {
+	return &Error{
+		ID:   id,
+		Data: err.Error(),
+	}
+}
+
+func (msg *Error) MsgType() lnwire.MessageType {
+	return MsgError
+}
+
+func (msg *Error) MaxPayloadLength(uint32) uint32 {
+	return 65535
+}
+
+func (msg *Error) Decode(r io.Reader, pver uint32) error {
+	return readElements(
+		r,
+		&msg.ID,
+		&msg.Data,
+		&msg.Extensions,
+	)
+}
+
+func (msg *Error) Encode(w io.Writer, pver uint32) error {
+	return writeElements(
+		w,
+		msg.ID,
+		msg.Data,
+		&msg.Extensions,
+	)
+}
+
+// Warning reports a problem without failing anything.
+type Warning struct {
+	ID   [32]byte
+	Data string
+	Extensions
+}
+
+func NewWarning(id [32]byte, err error) *Warning {
+	return &Warning{
+		ID:   id,
+		Data: err.Error(),
+	}
+}
+
+func (msg *Warning) MsgType() lnwire.MessageType {
+	return MsgWarning
+}
+
+func (msg *Warning) MaxPayloadLength(uint32) uint32 {
+	return 65535
+}
+
+func (msg *Warning) Decode(r io.Reader, pver uint32) error {
+	return readElements(
+		r,
+		&msg.ID,
+		&msg.Data,
+		&msg.Extensions,
+	)
+}
+
+func (msg *Warning) Encode(w io.Writer, pver uint32) error {
+	return writeElements(
+		w,
+		msg.ID,
+		msg.Data,
+		&msg.Extensions,
+	)
+}
+
+// TargetID returns the ID of the channel, swap or quote that msg is about, so
+// that an error in response to it can name the same thing.
+func TargetID(msg lnwire.Message) [32]byte {
+	switch m := msg.(type) {
+	case *OpenChannel:
+		return m.PendingChannelID
+	case *AcceptChannel:
+		return m.PendingChannelID
+	case *FundingCreated:
+		return m.PendingChannelID
+	case *FundingSigned:
+		return m.ChannelID
+	case *FundingLocked:
+		return m.ChannelID
+	case *Shutdown:
+		return m.ChannelID
+	case *ClosingSigned:
+		return m.ChannelID
+	case *UpdateAddHTLC:
+		return m.ChannelID
+	case *UpdateFulfillHTLC:
+		return m.ChannelID
+	case *CommitmentSigned:
+		return m.ChannelID
+	case *RevokeAndAck:
+		return m.ChannelID
+	case *RequestQuote:
+		return m.QuoteID
+	case *SwapQuote:
+		return m.QuoteID
+	case *InitiateSwap:
+		return m.SwapID
+	case *SwapAccepted:
+		return m.SwapID
+	case *InvoiceGenerated:
+		return m.SwapID
+	case *InvoiceExecuted:
+		return m.SwapID
+	case *InitiateReverseSwap:
+		return m.SwapID
+	case *ReverseSwapAccepted:
+		return m.SwapID
+	default:
+		return [32]byte{}
+	}
+}
@@ -0,0 +1,41 @@
+package wire
+
+import "github.com/lightningnetwork/lnd/lnwire"
+
+// Feature bits come in pairs as in BOLT 9: the even bit means the sender
+// requires the feature, the odd bit that it merely supports it.
+const (
+	ETHToBTCSwapsRequired lnwire.FeatureBit = 0
+	ETHToBTCSwapsOptional lnwire.FeatureBit = 1
+	BTCToETHSwapsRequired lnwire.FeatureBit = 2
+	BTCToETHSwapsOptional lnwire.FeatureBit = 3
+	SwapQuotesRequired    lnwire.FeatureBit = 4
+	SwapQuotesOptional    lnwire.FeatureBit = 5
+)
+
+var FeatureNames = map[lnwire.FeatureBit]string{
+	ETHToBTCSwapsRequired: "eth-to-btc-swaps",
+	ETHToBTCSwapsOptional: "eth-to-btc-swaps",
+	BTCToETHSwapsRequired: "btc-to-eth-swaps",
+	BTCToETHSwapsOptional: "btc-to-eth-swaps",
+	SwapQuotesRequired:    "swap-quotes",
+	SwapQuotesOptional:    "swap-quotes",
+}
+
+// DefaultGlobalFeatures is empty until a feature affects more than the
+// connection it was negotiated over.
+func DefaultGlobalFeatures() *lnwire.RawFeatureVector {
+	return lnwire.NewRawFeatureVector()
+}
+
+func DefaultLocalFeatures() *lnwire.RawFeatureVector {
+	return lnwire.NewRawFeatureVector(
+		ETHToBTCSwapsOptional,
+		BTCToETHSwapsOptional,
+		SwapQuotesOptional,
+	)
+}
+
+func NewFeatureVector(raw *lnwire.RawFeatureVector) *lnwire.FeatureVector {
+	return lnwire.NewFeatureVector(raw, FeatureNames)
+}
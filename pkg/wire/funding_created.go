@@ -11,6 +11,8 @@ type FundingCreated struct {
 	PendingChannelID [32]byte
 	InputID          common.Hash
 	Sig              crypto.Signature
+	CommitSig        crypto.Signature
+	Extensions
 }
 
 func (msg *FundingCreated) MsgType() lnwire.MessageType {
@@ -27,6 +29,8 @@ func (msg *FundingCreated) Decode(r io.Reader, pver uint32) error {
 		&msg.PendingChannelID,
 		&msg.InputID,
 		&msg.Sig,
+		&msg.CommitSig,
+		&msg.Extensions,
 	)
 }
 
@@ -36,5 +40,7 @@ func (msg *FundingCreated) Encode(w io.Writer, pver uint32) error {
 		msg.PendingChannelID,
 		msg.InputID,
 		msg.Sig,
+		msg.CommitSig,
+		&msg.Extensions,
 	)
 }
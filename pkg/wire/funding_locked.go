@@ -2,12 +2,15 @@ package wire
 
 import (
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/pkg/crypto"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"io"
 )
 
 type FundingLocked struct {
-	ChannelID common.Hash
+	ChannelID              common.Hash
+	NextPerCommitmentPoint *crypto.PublicKey
+	Extensions
 }
 
 func (msg *FundingLocked) MsgType() lnwire.MessageType {
@@ -22,6 +25,8 @@ func (msg *FundingLocked) Decode(r io.Reader, pver uint32) error {
 	return readElements(
 		r,
 		&msg.ChannelID,
+		&msg.NextPerCommitmentPoint,
+		&msg.Extensions,
 	)
 }
 
@@ -29,5 +34,7 @@ func (msg *FundingLocked) Encode(w io.Writer, pver uint32) error {
 	return writeElements(
 		w,
 		msg.ChannelID,
+		msg.NextPerCommitmentPoint,
+		&msg.Extensions,
 	)
 }
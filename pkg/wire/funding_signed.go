@@ -10,6 +10,8 @@ import (
 type FundingSigned struct {
 	ChannelID common.Hash
 	Sig       crypto.Signature
+	CommitSig crypto.Signature
+	Extensions
 }
 
 func (msg *FundingSigned) MsgType() lnwire.MessageType {
@@ -25,6 +27,8 @@ func (msg *FundingSigned) Decode(r io.Reader, pver uint32) error {
 		r,
 		&msg.ChannelID,
 		&msg.Sig,
+		&msg.CommitSig,
+		&msg.Extensions,
 	)
 }
 
@@ -33,5 +37,7 @@ func (msg *FundingSigned) Encode(w io.Writer, pver uint32) error {
 		w,
 		msg.ChannelID,
 		msg.Sig,
+		msg.CommitSig,
+		&msg.Extensions,
 	)
 }
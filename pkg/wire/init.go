@@ -8,13 +8,18 @@ import (
 
 type Init struct {
 	LNDIdentificationKey *crypto.PublicKey
-	LNDHost           string
+	LNDHost              string
+	GlobalFeatures       *lnwire.RawFeatureVector
+	LocalFeatures        *lnwire.RawFeatureVector
+	Extensions
 }
 
 func NewInit(ident *crypto.PublicKey, host string) (*Init) {
 	return &Init {
 		LNDIdentificationKey: ident,
-		LNDHost: host,
+		LNDHost:              host,
+		GlobalFeatures:       DefaultGlobalFeatures(),
+		LocalFeatures:        DefaultLocalFeatures(),
 	}
 }
 
@@ -23,7 +28,7 @@ func (msg *Init) MsgType() lnwire.MessageType {
 }
 
 func (msg *Init) MaxPayloadLength(uint32) uint32 {
-	return 40
+	return 65535
 }
 
 func (msg *Init) Decode(r io.Reader, pver uint32) error {
@@ -31,6 +36,9 @@ func (msg *Init) Decode(r io.Reader, pver uint32) error {
 		r,
 		&msg.LNDIdentificationKey,
 		&msg.LNDHost,
+		&msg.GlobalFeatures,
+		&msg.LocalFeatures,
+		&msg.Extensions,
 	)
 }
 
@@ -39,5 +47,8 @@ func (msg *Init) Encode(w io.Writer, pver uint32) error {
 		w,
 		msg.LNDIdentificationKey,
 		msg.LNDHost,
+		msg.GlobalFeatures,
+		msg.LocalFeatures,
+		&msg.Extensions,
 	)
 }
@@ -0,0 +1,49 @@
+package wire
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+	"io"
+	"math/big"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+)
+
+type InitiateReverseSwap struct {
+	SwapID           [32]byte
+	ETHChannelID     [32]byte
+	ETHAmount        *big.Int
+	ReceivingAddress *crypto.PublicKey
+	OfferedAmount    *big.Int
+	Extensions
+}
+
+func (msg *InitiateReverseSwap) MsgType() lnwire.MessageType {
+	return MsgInitiateReverseSwap
+}
+
+func (msg *InitiateReverseSwap) MaxPayloadLength(uint32) uint32 {
+	return 65535
+}
+
+func (msg *InitiateReverseSwap) Decode(r io.Reader, pver uint32) error {
+	return readElements(
+		r,
+		&msg.SwapID,
+		&msg.ETHChannelID,
+		&msg.ETHAmount,
+		&msg.ReceivingAddress,
+		&msg.OfferedAmount,
+		&msg.Extensions,
+	)
+}
+
+func (msg *InitiateReverseSwap) Encode(w io.Writer, pver uint32) error {
+	return writeElements(
+		w,
+		msg.SwapID,
+		msg.ETHChannelID,
+		msg.ETHAmount,
+		msg.ReceivingAddress,
+		msg.OfferedAmount,
+		&msg.Extensions,
+	)
+}
@@ -12,9 +12,9 @@ type InitiateSwap struct {
 	PaymentHash [32]byte
 	ETHChannelID [32]byte
 	ETHAmount *big.Int
-	ETHCommitmentSignature crypto.Signature
 	SendingAddress *crypto.PublicKey
 	RequestedAmount *big.Int
+	Extensions
 }
 
 func (msg *InitiateSwap) MsgType() lnwire.MessageType {
@@ -32,9 +32,9 @@ func (msg *InitiateSwap) Decode(r io.Reader, pver uint32) error {
 		&msg.PaymentHash,
 		&msg.ETHChannelID,
 		&msg.ETHAmount,
-		&msg.ETHCommitmentSignature,
 		&msg.SendingAddress,
 		&msg.RequestedAmount,
+		&msg.Extensions,
 	)
 }
 
@@ -45,8 +45,8 @@ func (msg *InitiateSwap) Encode(w io.Writer, pver uint32) error {
 		msg.PaymentHash,
 		msg.ETHChannelID,
 		msg.ETHAmount,
-		msg.ETHCommitmentSignature,
 		msg.SendingAddress,
 		msg.RequestedAmount,
+		&msg.Extensions,
 	)
 }
\ No newline at end of file
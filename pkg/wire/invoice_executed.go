@@ -7,6 +7,7 @@ import (
 
 type InvoiceExecuted struct {
 	SwapID [32]byte
+	Extensions
 }
 
 func (msg *InvoiceExecuted) MsgType() lnwire.MessageType {
@@ -21,6 +22,7 @@ func (msg *InvoiceExecuted) Decode(r io.Reader, pver uint32) error {
 	return readElements(
 		r,
 		&msg.SwapID,
+		&msg.Extensions,
 	)
 }
 
@@ -28,6 +30,7 @@ func (msg *InvoiceExecuted) Encode(w io.Writer, pver uint32) error {
 	return writeElements(
 		w,
 		msg.SwapID,
+		&msg.Extensions,
 	)
 }
  
\ No newline at end of file
@@ -8,6 +8,7 @@ import (
 type InvoiceGenerated struct {
 	SwapID         [32]byte
 	PaymentRequest string
+	Extensions
 }
 
 func (msg *InvoiceGenerated) MsgType() lnwire.MessageType {
@@ -23,6 +24,7 @@ func (msg *InvoiceGenerated) Decode(r io.Reader, pver uint32) error {
 		r,
 		&msg.SwapID,
 		&msg.PaymentRequest,
+		&msg.Extensions,
 	)
 }
 
@@ -31,5 +33,6 @@ func (msg *InvoiceGenerated) Encode(w io.Writer, pver uint32) error {
 		w,
 		msg.SwapID,
 		msg.PaymentRequest,
+		&msg.Extensions,
 	)
 }
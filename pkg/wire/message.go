@@ -18,17 +18,34 @@ const MsgPrefix = 0xbeef
 
 const UnknownMsg = "<unknown>"
 
+// ErrUnknownMessage is returned by ReadMessage for message types this node
+// does not implement.
+var ErrUnknownMessage = errors.New("unknown message")
+
 const (
-	MsgInit             lnwire.MessageType = 16
-	MsgOpenChannel                         = 32
-	MsgAcceptChannel                       = 33
-	MsgFundingCreated                      = 34
-	MsgFundingSigned                       = 35
-	MsgFundingLocked                       = 36
-	MsgInitiateSwap                        = 900
-	MsgSwapAccepted                        = 901
-	MsgInvoiceGenerated                    = 902
-	MsgInvoiceExecuted                     = 903
+	MsgWarning             lnwire.MessageType = 1
+	MsgInit                lnwire.MessageType = 16
+	MsgError                                  = 17
+	MsgOpenChannel                            = 32
+	MsgAcceptChannel                          = 33
+	MsgFundingCreated                         = 34
+	MsgFundingSigned                          = 35
+	MsgFundingLocked                          = 36
+	MsgShutdown                               = 38
+	MsgClosingSigned                          = 39
+	MsgUpdateAddHTLC                          = 128
+	MsgUpdateFulfillHTLC                      = 130
+	MsgUpdateFailHTLC                         = 131
+	MsgCommitmentSigned                       = 132
+	MsgRevokeAndAck                           = 133
+	MsgInitiateSwap                           = 900
+	MsgSwapAccepted                           = 901
+	MsgInvoiceGenerated                       = 902
+	MsgInvoiceExecuted                        = 903
+	MsgRequestQuote                           = 904
+	MsgSwapQuote                              = 905
+	MsgInitiateReverseSwap                    = 906
+	MsgReverseSwapAccepted                    = 907
 )
 
 func readElement(r io.Reader, element interface{}) error {
@@ -112,6 +129,14 @@ func readElement(r io.Reader, element interface{}) error {
 			return err
 		}
 		*e = binary.BigEndian.Uint64(b[:])
+	case *Extensions:
+		return e.decode(r)
+	case **lnwire.RawFeatureVector:
+		f := lnwire.NewRawFeatureVector()
+		if err := f.Decode(r); err != nil {
+			return err
+		}
+		*e = f
 	default:
 		return errors.New("reading unknown element type " + reflect.TypeOf(element).String())
 	}
@@ -190,6 +215,15 @@ func writeElement(w io.Writer, element interface{}) error {
 		if _, err := w.Write(b[:]); err != nil {
 			return err
 		}
+	case *Extensions:
+		return e.encode(w)
+	case *lnwire.RawFeatureVector:
+		if e == nil {
+			return errors.New("cannot write nil feature vector")
+		}
+		if err := e.Encode(w); err != nil {
+			return err
+		}
 	default:
 		return errors.New("writing unknown element type " + reflect.TypeOf(element).String())
 	}
@@ -237,8 +271,12 @@ func makeEmptyMessage(msgType lnwire.MessageType) (lnwire.Message, error) {
 	var msg lnwire.Message
 
 	switch msgType {
+	case MsgWarning:
+		msg = &Warning{}
 	case MsgInit:
 		msg = &Init{}
+	case MsgError:
+		msg = &Error{}
 	case lnwire.MsgPing:
 		msg = &lnwire.Ping{}
 	case lnwire.MsgPong:
@@ -253,6 +291,20 @@ func makeEmptyMessage(msgType lnwire.MessageType) (lnwire.Message, error) {
 		msg = &FundingSigned{}
 	case MsgFundingLocked:
 		msg = &FundingLocked{}
+	case MsgShutdown:
+		msg = &Shutdown{}
+	case MsgClosingSigned:
+		msg = &ClosingSigned{}
+	case MsgUpdateAddHTLC:
+		msg = &UpdateAddHTLC{}
+	case MsgUpdateFulfillHTLC:
+		msg = &UpdateFulfillHTLC{}
+	case MsgUpdateFailHTLC:
+		msg = &UpdateFailHTLC{}
+	case MsgCommitmentSigned:
+		msg = &CommitmentSigned{}
+	case MsgRevokeAndAck:
+		msg = &RevokeAndAck{}
 	case MsgInitiateSwap:
 		msg = &InitiateSwap{}
 	case MsgSwapAccepted:
@@ -261,8 +313,16 @@ func makeEmptyMessage(msgType lnwire.MessageType) (lnwire.Message, error) {
 		msg = &InvoiceGenerated{}
 	case MsgInvoiceExecuted:
 		msg = &InvoiceExecuted{}
+	case MsgRequestQuote:
+		msg = &RequestQuote{}
+	case MsgSwapQuote:
+		msg = &SwapQuote{}
+	case MsgInitiateReverseSwap:
+		msg = &InitiateReverseSwap{}
+	case MsgReverseSwapAccepted:
+		msg = &ReverseSwapAccepted{}
 	default:
-		return nil, errors.New("unknown message")
+		return nil, ErrUnknownMessage
 	}
 
 	return msg, nil
@@ -319,6 +379,11 @@ func ReadMessage(r io.Reader, pver uint32) (lnwire.Message, error) {
 	if err := msg.Decode(r, pver); err != nil {
 		return nil, err
 	}
+	if ext, ok := msg.(extensible); ok {
+		if err := ext.extensions().validate(msg.MsgType()); err != nil {
+			return nil, err
+		}
+	}
 
 	return msg, nil
 }
@@ -330,6 +395,8 @@ func MessageName(msgType lnwire.MessageType) string {
 	}
 
 	switch msgType {
+	case MsgWarning:
+		return "MsgWarning"
 	case MsgInitiateSwap:
 		return "MsgInitiateSwap"
 	case MsgSwapAccepted:
@@ -338,6 +405,14 @@ func MessageName(msgType lnwire.MessageType) string {
 		return "MsgInvoiceGenerated"
 	case MsgInvoiceExecuted:
 		return "MsgInvoiceExecuted"
+	case MsgRequestQuote:
+		return "MsgRequestQuote"
+	case MsgSwapQuote:
+		return "MsgSwapQuote"
+	case MsgInitiateReverseSwap:
+		return "MsgInitiateReverseSwap"
+	case MsgReverseSwapAccepted:
+		return "MsgReverseSwapAccepted"
 	default:
 		return UnknownMsg
 	}
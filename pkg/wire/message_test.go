@@ -0,0 +1,116 @@
+package wire
+
+import (
+	"testing"
+	"bytes"
+	"math/big"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+func roundTrip(t *testing.T, msg lnwire.Message) lnwire.Message {
+	var buf bytes.Buffer
+	_, err := WriteMessage(&buf, msg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	out, err := ReadMessage(&buf, 0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return out
+}
+
+func TestUpdateAddHTLC_RoundTrip(t *testing.T) {
+	msg := &UpdateAddHTLC{
+		ChannelID:   common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e"),
+		ID:          3,
+		Amount:      big.NewInt(1000),
+		PaymentHash: common.HexToHash("0xf2f452833095a6d4a81f0845f5712a67a9bcbec74cad1c1c5c151f2fa62a59c3"),
+		Delay:       5,
+	}
+	assert.Equal(t, msg, roundTrip(t, msg))
+}
+
+func TestUpdateFailHTLC_RoundTrip(t *testing.T) {
+	msg := &UpdateFailHTLC{
+		ChannelID: common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e"),
+		ID:        3,
+	}
+	assert.Equal(t, msg, roundTrip(t, msg))
+}
+
+func TestRevokeAndAck_RoundTrip(t *testing.T) {
+	point, err := crypto.RandomPublicKey()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	msg := &RevokeAndAck{
+		ChannelID:              common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e"),
+		CommitmentNumber:       1,
+		Revocation:             common.HexToHash("0xf2f452833095a6d4a81f0845f5712a67a9bcbec74cad1c1c5c151f2fa62a59c3"),
+		NextPerCommitmentPoint: point,
+	}
+	out := roundTrip(t, msg).(*RevokeAndAck)
+	assert.Equal(t, msg.ChannelID, out.ChannelID)
+	assert.Equal(t, msg.CommitmentNumber, out.CommitmentNumber)
+	assert.Equal(t, msg.Revocation, out.Revocation)
+	assert.True(t, msg.NextPerCommitmentPoint.Equal(out.NextPerCommitmentPoint))
+}
+
+func TestSwapQuote_RoundTrip(t *testing.T) {
+	msg := &SwapQuote{
+		QuoteID:        common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e"),
+		ETHAmount:      big.NewInt(1000000),
+		BTCAmount:      big.NewInt(3000),
+		Rate:           big.NewInt(3000000),
+		FeeBasisPoints: 30,
+	}
+	assert.Equal(t, msg, roundTrip(t, msg))
+}
+
+func TestReverseSwapAccepted_RoundTrip(t *testing.T) {
+	msg := &ReverseSwapAccepted{
+		SwapID:         common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e"),
+		PaymentHash:    common.HexToHash("0xf2f452833095a6d4a81f0845f5712a67a9bcbec74cad1c1c5c151f2fa62a59c3"),
+		PaymentRequest: "lnsb30u1pdk8zpupp5",
+	}
+	assert.Equal(t, msg, roundTrip(t, msg))
+}
+
+func TestError_RoundTrip(t *testing.T) {
+	msg := &Error{
+		ID:   common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e"),
+		Data: "no swap with that ID found",
+	}
+	assert.Equal(t, msg, roundTrip(t, msg))
+}
+
+func TestTargetID(t *testing.T) {
+	id := common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e")
+	assert.Equal(t, [32]byte(id), TargetID(&InvoiceExecuted{SwapID: id}))
+	assert.Equal(t, [32]byte(id), TargetID(&FundingLocked{ChannelID: id}))
+	assert.Equal(t, [32]byte{}, TargetID(&Init{}))
+}
+
+func TestInit_RoundTrip(t *testing.T) {
+	ident, err := crypto.RandomPublicKey()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	msg := NewInit(ident, "127.0.0.1:10009")
+	msg.LocalFeatures.Set(lnwire.FeatureBit(10))
+	out := roundTrip(t, msg).(*Init)
+	assert.Equal(t, msg.LNDHost, out.LNDHost)
+	assert.True(t, msg.LNDIdentificationKey.Equal(out.LNDIdentificationKey))
+
+	features := NewFeatureVector(out.LocalFeatures)
+	assert.True(t, features.HasFeature(BTCToETHSwapsOptional))
+	assert.True(t, features.HasFeature(SwapQuotesOptional))
+	assert.Equal(t, []lnwire.FeatureBit{10}, features.UnknownRequiredFeatures())
+	assert.Empty(t, NewFeatureVector(out.GlobalFeatures).UnknownRequiredFeatures())
+}
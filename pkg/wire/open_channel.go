@@ -8,11 +8,13 @@ import (
 )
 
 type OpenChannel struct {
-	PendingChannelID [32]byte
-	FundingAmount    *big.Int
-	CsvDelay         uint16
-	MaxAcceptedHTLCs uint16
-	FundingKey       *crypto.PublicKey
+	PendingChannelID     [32]byte
+	FundingAmount        *big.Int
+	CsvDelay             uint16
+	MaxAcceptedHTLCs     uint16
+	FundingKey           *crypto.PublicKey
+	FirstCommitmentPoint *crypto.PublicKey
+	Extensions
 }
 
 func (msg *OpenChannel) MsgType() lnwire.MessageType {
@@ -20,7 +22,7 @@ func (msg *OpenChannel) MsgType() lnwire.MessageType {
 }
 
 func (msg *OpenChannel) MaxPayloadLength(uint32) uint32 {
-	return 129
+	return 65535
 }
 
 func (msg *OpenChannel) Decode(r io.Reader, pver uint32) error {
@@ -31,6 +33,8 @@ func (msg *OpenChannel) Decode(r io.Reader, pver uint32) error {
 		&msg.CsvDelay,
 		&msg.MaxAcceptedHTLCs,
 		&msg.FundingKey,
+		&msg.FirstCommitmentPoint,
+		&msg.Extensions,
 	)
 }
 
@@ -42,5 +46,7 @@ func (msg *OpenChannel) Encode(w io.Writer, pver uint32) error {
 		msg.CsvDelay,
 		msg.MaxAcceptedHTLCs,
 		msg.FundingKey,
+		msg.FirstCommitmentPoint,
+		&msg.Extensions,
 	)
 }
\ No newline at end of file
@@ -0,0 +1,47 @@
+package wire
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+	"io"
+	"math/big"
+)
+
+const (
+	SwapDirectionETHToBTC uint16 = iota
+	SwapDirectionBTCToETH
+)
+
+type RequestQuote struct {
+	QuoteID   [32]byte
+	ETHAmount *big.Int
+	Direction uint16
+	Extensions
+}
+
+func (msg *RequestQuote) MsgType() lnwire.MessageType {
+	return MsgRequestQuote
+}
+
+func (msg *RequestQuote) MaxPayloadLength(uint32) uint32 {
+	return 65535
+}
+
+func (msg *RequestQuote) Decode(r io.Reader, pver uint32) error {
+	return readElements(
+		r,
+		&msg.QuoteID,
+		&msg.ETHAmount,
+		&msg.Direction,
+		&msg.Extensions,
+	)
+}
+
+func (msg *RequestQuote) Encode(w io.Writer, pver uint32) error {
+	return writeElements(
+		w,
+		msg.QuoteID,
+		msg.ETHAmount,
+		msg.Direction,
+		&msg.Extensions,
+	)
+}
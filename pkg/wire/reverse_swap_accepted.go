@@ -0,0 +1,41 @@
+package wire
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+	"io"
+)
+
+type ReverseSwapAccepted struct {
+	SwapID         [32]byte
+	PaymentHash    [32]byte
+	PaymentRequest string
+	Extensions
+}
+
+func (msg *ReverseSwapAccepted) MsgType() lnwire.MessageType {
+	return MsgReverseSwapAccepted
+}
+
+func (msg *ReverseSwapAccepted) MaxPayloadLength(uint32) uint32 {
+	return 65535
+}
+
+func (msg *ReverseSwapAccepted) Decode(r io.Reader, pver uint32) error {
+	return readElements(
+		r,
+		&msg.SwapID,
+		&msg.PaymentHash,
+		&msg.PaymentRequest,
+		&msg.Extensions,
+	)
+}
+
+func (msg *ReverseSwapAccepted) Encode(w io.Writer, pver uint32) error {
+	return writeElements(
+		w,
+		msg.SwapID,
+		msg.PaymentHash,
+		msg.PaymentRequest,
+		&msg.Extensions,
+	)
+}
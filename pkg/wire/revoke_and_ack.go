@@ -0,0 +1,46 @@
+package wire
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/kyokan/drawbridge/pkg/crypto"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"io"
+)
+
+type RevokeAndAck struct {
+	ChannelID              common.Hash
+	CommitmentNumber       uint64
+	Revocation             [32]byte
+	NextPerCommitmentPoint *crypto.PublicKey
+	Extensions
+}
+
+func (msg *RevokeAndAck) MsgType() lnwire.MessageType {
+	return MsgRevokeAndAck
+}
+
+func (msg *RevokeAndAck) MaxPayloadLength(uint32) uint32 {
+	return 65535
+}
+
+func (msg *RevokeAndAck) Decode(r io.Reader, pver uint32) error {
+	return readElements(
+		r,
+		&msg.ChannelID,
+		&msg.CommitmentNumber,
+		&msg.Revocation,
+		&msg.NextPerCommitmentPoint,
+		&msg.Extensions,
+	)
+}
+
+func (msg *RevokeAndAck) Encode(w io.Writer, pver uint32) error {
+	return writeElements(
+		w,
+		msg.ChannelID,
+		msg.CommitmentNumber,
+		msg.Revocation,
+		msg.NextPerCommitmentPoint,
+		&msg.Extensions,
+	)
+}
@@ -0,0 +1,36 @@
+package wire
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"io"
+)
+
+type Shutdown struct {
+	ChannelID common.Hash
+	Extensions
+}
+
+func (msg *Shutdown) MsgType() lnwire.MessageType {
+	return MsgShutdown
+}
+
+func (msg *Shutdown) MaxPayloadLength(uint32) uint32 {
+	return 65535
+}
+
+func (msg *Shutdown) Decode(r io.Reader, pver uint32) error {
+	return readElements(
+		r,
+		&msg.ChannelID,
+		&msg.Extensions,
+	)
+}
+
+func (msg *Shutdown) Encode(w io.Writer, pver uint32) error {
+	return writeElements(
+		w,
+		msg.ChannelID,
+		&msg.Extensions,
+	)
+}
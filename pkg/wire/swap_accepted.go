@@ -8,6 +8,7 @@ import (
 type SwapAccepted struct {
 	SwapID       [32]byte
 	BTCChannelID uint64
+	Extensions
 }
 
 func (msg *SwapAccepted) MsgType() lnwire.MessageType {
@@ -23,6 +24,7 @@ func (msg *SwapAccepted) Decode(r io.Reader, pver uint32) error {
 		r,
 		&msg.SwapID,
 		&msg.BTCChannelID,
+		&msg.Extensions,
 	)
 }
 
@@ -31,5 +33,6 @@ func (msg *SwapAccepted) Encode(w io.Writer, pver uint32) error {
 		w,
 		msg.SwapID,
 		msg.BTCChannelID,
+		&msg.Extensions,
 	)
 }
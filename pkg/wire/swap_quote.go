@@ -0,0 +1,51 @@
+package wire
+
+import (
+	"github.com/lightningnetwork/lnd/lnwire"
+	"io"
+	"math/big"
+)
+
+// SwapQuote answers a RequestQuote with the BTC amount the sender would pay
+// for the requested ETH amount. Rate is in satoshis per whole ETH-side token
+// (10^18 base units) and FeeBasisPoints is taken out of the BTC amount.
+type SwapQuote struct {
+	QuoteID        [32]byte
+	ETHAmount      *big.Int
+	BTCAmount      *big.Int
+	Rate           *big.Int
+	FeeBasisPoints uint64
+	Extensions
+}
+
+func (msg *SwapQuote) MsgType() lnwire.MessageType {
+	return MsgSwapQuote
+}
+
+func (msg *SwapQuote) MaxPayloadLength(uint32) uint32 {
+	return 65535
+}
+
+func (msg *SwapQuote) Decode(r io.Reader, pver uint32) error {
+	return readElements(
+		r,
+		&msg.QuoteID,
+		&msg.ETHAmount,
+		&msg.BTCAmount,
+		&msg.Rate,
+		&msg.FeeBasisPoints,
+		&msg.Extensions,
+	)
+}
+
+func (msg *SwapQuote) Encode(w io.Writer, pver uint32) error {
+	return writeElements(
+		w,
+		msg.QuoteID,
+		msg.ETHAmount,
+		msg.BTCAmount,
+		msg.Rate,
+		msg.FeeBasisPoints,
+		&msg.Extensions,
+	)
+}
@@ -0,0 +1,297 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// TLVType identifies a record in a message's extension stream. Following
+// "it's OK to be odd", a reader must reject a message carrying an even type it
+// does not know, and may skip odd ones.
+type TLVType uint64
+
+// TLVRecord is a typed optional field that can be carried in Extensions.
+type TLVRecord interface {
+	TLVType() TLVType
+	Encode(w io.Writer) error
+	Decode(r io.Reader, length uint64) error
+}
+
+var (
+	tlvMtx        sync.RWMutex
+	registeredTLV = make(map[lnwire.MessageType]map[TLVType]bool)
+)
+
+// RegisterTLV marks tlvType as understood in messages of msgType. Only even
+// types need registering, since unknown odd types are always skipped.
+func RegisterTLV(msgType lnwire.MessageType, tlvType TLVType) {
+	tlvMtx.Lock()
+	defer tlvMtx.Unlock()
+
+	types, exists := registeredTLV[msgType]
+	if !exists {
+		types = make(map[TLVType]bool)
+		registeredTLV[msgType] = types
+	}
+	types[tlvType] = true
+}
+
+// UnregisterTLV undoes RegisterTLV.
+func UnregisterTLV(msgType lnwire.MessageType, tlvType TLVType) {
+	tlvMtx.Lock()
+	defer tlvMtx.Unlock()
+
+	delete(registeredTLV[msgType], tlvType)
+	if len(registeredTLV[msgType]) == 0 {
+		delete(registeredTLV, msgType)
+	}
+}
+
+func isRegisteredTLV(msgType lnwire.MessageType, tlvType TLVType) bool {
+	tlvMtx.RLock()
+	defer tlvMtx.RUnlock()
+	return registeredTLV[msgType][tlvType]
+}
+
+// Extensions is the TLV stream trailing every drawbridge message. It is
+// embedded in each message so that optional fields can be added without a new
+// message type. An empty stream encodes to nothing, which keeps messages
+// readable by peers that predate it.
+type Extensions struct {
+	records map[TLVType][]byte
+}
+
+func (e *Extensions) extensions() *Extensions {
+	return e
+}
+
+type extensible interface {
+	extensions() *Extensions
+}
+
+func (e *Extensions) Has(t TLVType) bool {
+	_, exists := e.records[t]
+	return exists
+}
+
+func (e *Extensions) Set(record TLVRecord) error {
+	var buf bytes.Buffer
+	if err := record.Encode(&buf); err != nil {
+		return err
+	}
+
+	if e.records == nil {
+		e.records = make(map[TLVType][]byte)
+	}
+	e.records[record.TLVType()] = buf.Bytes()
+	return nil
+}
+
+// Get decodes record from the stream if it is present.
+func (e *Extensions) Get(record TLVRecord) (bool, error) {
+	value, exists := e.records[record.TLVType()]
+	if !exists {
+		return false, nil
+	}
+
+	return true, record.Decode(bytes.NewReader(value), uint64(len(value)))
+}
+
+func (e *Extensions) Delete(t TLVType) {
+	delete(e.records, t)
+}
+
+// validate rejects unknown even records for messages of msgType.
+func (e *Extensions) validate(msgType lnwire.MessageType) error {
+	for t := range e.records {
+		if t%2 == 0 && !isRegisteredTLV(msgType, t) {
+			return errors.New("unknown required TLV record")
+		}
+	}
+
+	return nil
+}
+
+func (e *Extensions) encode(w io.Writer) error {
+	types := make([]TLVType, 0, len(e.records))
+	for t := range e.records {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+
+	for _, t := range types {
+		value := e.records[t]
+		if err := writeBigSize(w, uint64(t)); err != nil {
+			return err
+		}
+		if err := writeBigSize(w, uint64(len(value))); err != nil {
+			return err
+		}
+		if _, err := w.Write(value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decode reads records until the end of the message. Types must be strictly
+// increasing so that every stream has a single encoding.
+func (e *Extensions) decode(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewReader(data)
+	var last TLVType
+	first := true
+	for buf.Len() > 0 {
+		rawType, err := readBigSize(buf)
+		if err != nil {
+			return err
+		}
+		t := TLVType(rawType)
+		if !first && t <= last {
+			return errors.New("TLV records out of order")
+		}
+		first = false
+		last = t
+
+		length, err := readBigSize(buf)
+		if err != nil {
+			return err
+		}
+		if length > uint64(buf.Len()) {
+			return errors.New("TLV record overruns message")
+		}
+		value := make([]byte, length)
+		if _, err := io.ReadFull(buf, value); err != nil {
+			return err
+		}
+
+		if e.records == nil {
+			e.records = make(map[TLVType][]byte)
+		}
+		e.records[t] = value
+	}
+
+	return nil
+}
+
+// writeBigSize writes n as a BOLT 1 BigSize integer.
+func writeBigSize(w io.Writer, n uint64) error {
+	var buf []byte
+	switch {
+	case n < 0xfd:
+		buf = []byte{byte(n)}
+	case n <= 0xffff:
+		buf = make([]byte, 3)
+		buf[0] = 0xfd
+		binary.BigEndian.PutUint16(buf[1:], uint16(n))
+	case n <= 0xffffffff:
+		buf = make([]byte, 5)
+		buf[0] = 0xfe
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+	default:
+		buf = make([]byte, 9)
+		buf[0] = 0xff
+		binary.BigEndian.PutUint64(buf[1:], n)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func readBigSize(r io.Reader) (uint64, error) {
+	var prefix [1]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return 0, err
+	}
+
+	var n, min uint64
+	switch prefix[0] {
+	case 0xfd:
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		n, min = uint64(binary.BigEndian.Uint16(b[:])), 0xfd
+	case 0xfe:
+		var b [4]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		n, min = uint64(binary.BigEndian.Uint32(b[:])), 0x10000
+	case 0xff:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		n, min = binary.BigEndian.Uint64(b[:]), 0x100000000
+	default:
+		return uint64(prefix[0]), nil
+	}
+
+	if n < min {
+		return 0, errors.New("non-canonical BigSize")
+	}
+	return n, nil
+}
+
+type Uint64Record struct {
+	Type  TLVType
+	Value uint64
+}
+
+func (rec *Uint64Record) TLVType() TLVType {
+	return rec.Type
+}
+
+func (rec *Uint64Record) Encode(w io.Writer) error {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], rec.Value)
+	_, err := w.Write(b[:])
+	return err
+}
+
+func (rec *Uint64Record) Decode(r io.Reader, length uint64) error {
+	if length != 8 {
+		return errors.New("uint64 record must be 8 bytes")
+	}
+
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return err
+	}
+	rec.Value = binary.BigEndian.Uint64(b[:])
+	return nil
+}
+
+type BytesRecord struct {
+	Type  TLVType
+	Value []byte
+}
+
+func (rec *BytesRecord) TLVType() TLVType {
+	return rec.Type
+}
+
+func (rec *BytesRecord) Encode(w io.Writer) error {
+	_, err := w.Write(rec.Value)
+	return err
+}
+
+func (rec *BytesRecord) Decode(r io.Reader, length uint64) error {
+	rec.Value = make([]byte, length)
+	_, err := io.ReadFull(r, rec.Value)
+	return err
+}
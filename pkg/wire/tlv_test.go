@@ -0,0 +1,84 @@
+package wire
+
+import (
+	"testing"
+	"bytes"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBigSize(t *testing.T) {
+	tests := []struct {
+		n       uint64
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{0xfc, []byte{0xfc}},
+		{0xfd, []byte{0xfd, 0x00, 0xfd}},
+		{0xffff, []byte{0xfd, 0xff, 0xff}},
+		{0x10000, []byte{0xfe, 0x00, 0x01, 0x00, 0x00}},
+		{0x100000000, []byte{0xff, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		assert.Nil(t, writeBigSize(&buf, tt.n))
+		assert.Equal(t, tt.encoded, buf.Bytes())
+
+		n, err := readBigSize(bytes.NewReader(tt.encoded))
+		assert.Nil(t, err)
+		assert.Equal(t, tt.n, n)
+	}
+
+	_, err := readBigSize(bytes.NewReader([]byte{0xfd, 0x00, 0xfc}))
+	assert.NotNil(t, err)
+}
+
+func TestExtensions_OddRecordsAreSkippable(t *testing.T) {
+	msg := &InvoiceExecuted{
+		SwapID: common.HexToHash("0xca89f758cdbc84fa247709df12b479ed8cf2f0eae5af8e820277dc86fc37054e"),
+	}
+	assert.Nil(t, msg.Set(&BytesRecord{Type: 7, Value: []byte("memo")}))
+	assert.Nil(t, msg.Set(&Uint64Record{Type: 3, Value: 42}))
+
+	out := roundTrip(t, msg).(*InvoiceExecuted)
+	assert.Equal(t, msg, out)
+
+	rec := &Uint64Record{Type: 3}
+	ok, err := out.Get(rec)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(42), rec.Value)
+
+	ok, _ = out.Get(&Uint64Record{Type: 5})
+	assert.False(t, ok)
+}
+
+func TestExtensions_UnknownEvenRecords(t *testing.T) {
+	msg := &SwapAccepted{BTCChannelID: 1}
+	assert.Nil(t, msg.Set(&Uint64Record{Type: 1000, Value: 1}))
+
+	var buf bytes.Buffer
+	_, err := WriteMessage(&buf, msg)
+	assert.Nil(t, err)
+	encoded := buf.Bytes()
+
+	_, err = ReadMessage(bytes.NewReader(encoded), 0)
+	assert.NotNil(t, err)
+
+	RegisterTLV(MsgSwapAccepted, 1000)
+	defer UnregisterTLV(MsgSwapAccepted, 1000)
+	out, err := ReadMessage(bytes.NewReader(encoded), 0)
+	assert.Nil(t, err)
+	assert.True(t, out.(*SwapAccepted).Has(1000))
+
+	UnregisterTLV(MsgSwapAccepted, 1000)
+	_, err = ReadMessage(bytes.NewReader(encoded), 0)
+	assert.NotNil(t, err)
+}
+
+func TestExtensions_RejectsOutOfOrderRecords(t *testing.T) {
+	stream := []byte{0x05, 0x00, 0x03, 0x00}
+	var ext Extensions
+	assert.NotNil(t, ext.decode(bytes.NewReader(stream)))
+}
@@ -0,0 +1,49 @@
+package wire
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"io"
+	"math/big"
+)
+
+type UpdateAddHTLC struct {
+	ChannelID   common.Hash
+	ID          uint64
+	Amount      *big.Int
+	PaymentHash [32]byte
+	Delay       uint64
+	Extensions
+}
+
+func (msg *UpdateAddHTLC) MsgType() lnwire.MessageType {
+	return MsgUpdateAddHTLC
+}
+
+func (msg *UpdateAddHTLC) MaxPayloadLength(uint32) uint32 {
+	return 65535
+}
+
+func (msg *UpdateAddHTLC) Decode(r io.Reader, pver uint32) error {
+	return readElements(
+		r,
+		&msg.ChannelID,
+		&msg.ID,
+		&msg.Amount,
+		&msg.PaymentHash,
+		&msg.Delay,
+		&msg.Extensions,
+	)
+}
+
+func (msg *UpdateAddHTLC) Encode(w io.Writer, pver uint32) error {
+	return writeElements(
+		w,
+		msg.ChannelID,
+		msg.ID,
+		msg.Amount,
+		msg.PaymentHash,
+		msg.Delay,
+		&msg.Extensions,
+	)
+}
@@ -0,0 +1,41 @@
+package wire
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"io"
+)
+
+// UpdateFailHTLC removes an HTLC the sender was offered, returning its amount
+// to the side that offered it.
+type UpdateFailHTLC struct {
+	ChannelID common.Hash
+	ID        uint64
+	Extensions
+}
+
+func (msg *UpdateFailHTLC) MsgType() lnwire.MessageType {
+	return MsgUpdateFailHTLC
+}
+
+func (msg *UpdateFailHTLC) MaxPayloadLength(uint32) uint32 {
+	return 65535
+}
+
+func (msg *UpdateFailHTLC) Decode(r io.Reader, pver uint32) error {
+	return readElements(
+		r,
+		&msg.ChannelID,
+		&msg.ID,
+		&msg.Extensions,
+	)
+}
+
+func (msg *UpdateFailHTLC) Encode(w io.Writer, pver uint32) error {
+	return writeElements(
+		w,
+		msg.ChannelID,
+		msg.ID,
+		&msg.Extensions,
+	)
+}
@@ -0,0 +1,42 @@
+package wire
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"io"
+)
+
+type UpdateFulfillHTLC struct {
+	ChannelID       common.Hash
+	ID              uint64
+	PaymentPreimage [32]byte
+	Extensions
+}
+
+func (msg *UpdateFulfillHTLC) MsgType() lnwire.MessageType {
+	return MsgUpdateFulfillHTLC
+}
+
+func (msg *UpdateFulfillHTLC) MaxPayloadLength(uint32) uint32 {
+	return 65535
+}
+
+func (msg *UpdateFulfillHTLC) Decode(r io.Reader, pver uint32) error {
+	return readElements(
+		r,
+		&msg.ChannelID,
+		&msg.ID,
+		&msg.PaymentPreimage,
+		&msg.Extensions,
+	)
+}
+
+func (msg *UpdateFulfillHTLC) Encode(w io.Writer, pver uint32) error {
+	return writeElements(
+		w,
+		msg.ChannelID,
+		msg.ID,
+		msg.PaymentPreimage,
+		&msg.Extensions,
+	)
+}